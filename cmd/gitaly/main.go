@@ -13,6 +13,7 @@ import (
 
 	"gitlab.com/gitlab-org/gitaly/internal/config"
 	"gitlab.com/gitlab-org/gitaly/internal/connectioncounter"
+	"gitlab.com/gitlab-org/gitaly/internal/healthcheck"
 	"gitlab.com/gitlab-org/gitaly/internal/linguist"
 	"gitlab.com/gitlab-org/gitaly/internal/rubyserver"
 	"gitlab.com/gitlab-org/gitaly/internal/server"
@@ -132,15 +133,6 @@ func main() {
 		listeners = append(listeners, connectioncounter.New("tcp", l))
 	}
 
-	if config.Config.PrometheusListenAddr != "" {
-		log.WithField("address", config.Config.PrometheusListenAddr).Info("Starting prometheus listener")
-		promMux := http.NewServeMux()
-		promMux.Handle("/metrics", promhttp.Handler())
-		go func() {
-			http.ListenAndServe(config.Config.PrometheusListenAddr, promMux)
-		}()
-	}
-
 	log.WithError(run(listeners)).Fatal("shutting down")
 }
 
@@ -166,9 +158,23 @@ func run(listeners []net.Listener) error {
 	}
 	defer ruby.Stop()
 
-	server := server.New(ruby)
+	healthReporter := healthcheck.NewReporter(ruby)
+	server := server.New(ruby, healthReporter)
 	defer server.Stop()
 
+	healthReporter.Start()
+	defer healthReporter.Stop()
+
+	if config.Config.PrometheusListenAddr != "" {
+		log.WithField("address", config.Config.PrometheusListenAddr).Info("Starting prometheus listener")
+		promMux := http.NewServeMux()
+		promMux.Handle("/metrics", promhttp.Handler())
+		promMux.Handle("/readiness", healthReporter)
+		go func() {
+			http.ListenAndServe(config.Config.PrometheusListenAddr, promMux)
+		}()
+	}
+
 	serverErrors := make(chan error, len(listeners))
 	for _, listener := range listeners {
 		// Must pass the listener as a function argument because there is a race
@@ -41,7 +41,22 @@ func CatFile(ctx context.Context, repoPath string, handler Handler) error {
 
 	stdout := bufio.NewReader(cmd)
 
-	return handler(stdinWriter, stdout)
+	handlerErr := handler(stdinWriter, stdout)
+
+	// cat-file exits once its input is closed, so that it is reaped before
+	// the caller returns
+	stdinWriter.Close()
+	waitErr := cmd.Wait()
+
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	if waitErr != nil {
+		return grpc.Errorf(codes.Internal, "CatFile: wait: %v", waitErr)
+	}
+
+	return nil
 }
 
 // ParseObjectInfo reads and parses one header line from `git cat-file --batch`
@@ -52,11 +67,15 @@ func ParseObjectInfo(stdout *bufio.Reader) (*ObjectInfo, error) {
 	}
 
 	infoLine = strings.TrimSuffix(infoLine, "\n")
-	if strings.HasSuffix(infoLine, " missing") {
+	// Short object IDs that match several objects are reported as ambiguous
+	if strings.HasSuffix(infoLine, " missing") || strings.HasSuffix(infoLine, " ambiguous") {
 		return &ObjectInfo{}, nil
 	}
 
 	info := strings.Split(infoLine, " ")
+	if len(info) != 3 {
+		return nil, fmt.Errorf("invalid info line: %q", infoLine)
+	}
 
 	objectSizeStr := info[2]
 	objectSize, err := strconv.ParseInt(objectSizeStr, 10, 64)
@@ -70,3 +89,32 @@ func ParseObjectInfo(stdout *bufio.Reader) (*ObjectInfo, error) {
 		Size: objectSize,
 	}, nil
 }
+
+// ReadObject requests revision from `git cat-file --batch` and returns its
+// header and content. The header has an empty Oid if the object is missing.
+func ReadObject(stdin io.Writer, stdout *bufio.Reader, revision string) (*ObjectInfo, []byte, error) {
+	if _, err := fmt.Fprintln(stdin, revision); err != nil {
+		return nil, nil, fmt.Errorf("write revision: %v", err)
+	}
+
+	info, err := ParseObjectInfo(stdout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info.Oid == "" {
+		return info, nil, nil
+	}
+
+	content := make([]byte, info.Size)
+	if _, err := io.ReadFull(stdout, content); err != nil {
+		return nil, nil, fmt.Errorf("read object content: %v", err)
+	}
+
+	// The content is followed by a newline
+	if _, err := stdout.Discard(1); err != nil {
+		return nil, nil, fmt.Errorf("read object content: %v", err)
+	}
+
+	return info, content, nil
+}
@@ -0,0 +1,91 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+)
+
+// Signature is the author or committer of a commit. An empty date means
+// the current time.
+type Signature struct {
+	Name  []byte
+	Email []byte
+	Date  string
+}
+
+// UserSignature returns the signature of user, dated now, with its name
+// and email sanitized by SanitizeIdentity
+func UserSignature(user *pb.User) Signature {
+	return Signature{Name: SanitizeIdentity(user.GetName()), Email: SanitizeIdentity(user.GetEmail())}
+}
+
+// AuthorEnv returns the environment variables that make git use s as the
+// author of the commits it creates
+func (s Signature) AuthorEnv() []string {
+	return s.env("AUTHOR")
+}
+
+// CommitterEnv returns the environment variables that make git use s as
+// the committer of the commits it creates
+func (s Signature) CommitterEnv() []string {
+	return s.env("COMMITTER")
+}
+
+func (s Signature) env(role string) []string {
+	env := []string{
+		"GIT_" + role + "_NAME=" + string(s.Name),
+		"GIT_" + role + "_EMAIL=" + string(s.Email),
+	}
+	if s.Date != "" {
+		env = append(env, "GIT_"+role+"_DATE="+s.Date)
+	}
+
+	return env
+}
+
+// SanitizeIdentity removes the characters of a name or email that could
+// break the header of an object, like git does when it writes the author
+// and committer of a commit: the special characters and whitespace at both
+// ends, and any newline or angle bracket.
+func SanitizeIdentity(identity []byte) []byte {
+	identity = bytes.TrimFunc(identity, func(r rune) bool {
+		return r <= ' ' || strings.ContainsRune(".,:;<>\"\\'", r)
+	})
+
+	return bytes.Map(func(r rune) rune {
+		if r == '\n' || r == '<' || r == '>' {
+			return -1
+		}
+		return r
+	}, identity)
+}
+
+// CommitTree creates a commit of tree and returns its ID.
+func CommitTree(ctx context.Context, repoPath string, author, committer Signature, tree string, message []byte, parents ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	args := []string{"--git-dir", repoPath, "commit-tree", tree}
+	for _, parent := range parents {
+		args = append(args, "-p", parent)
+	}
+
+	env := append(author.AuthorEnv(), committer.CommitterEnv()...)
+
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), args...), bytes.NewReader(message), &stdout, &stderr, env...)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("commit-tree: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
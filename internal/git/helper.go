@@ -3,6 +3,7 @@ package git
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/golang/protobuf/ptypes/timestamp"
@@ -36,6 +37,18 @@ func SafeTimeParse(timeStr string) time.Time {
 	return t
 }
 
+// SafeTimeUnix parses a git timestamp in seconds since the epoch, as found
+// in raw commit and tag objects. Like SafeTimeParse, it returns the maximum
+// date possible if the timestamp is invalid or too large.
+func SafeTimeUnix(seconds string) time.Time {
+	t, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil || t > maxTimeValue.Unix() {
+		return maxTimeValue
+	}
+
+	return time.Unix(t, 0)
+}
+
 // NewCommit creates a commit based on the given elements
 func NewCommit(id, subject, body, authorName, authorEmail, authorDate,
 	committerName, committerEmail, committerDate []byte, parentIds ...string) (*pb.GitCommit, error) {
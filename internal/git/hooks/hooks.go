@@ -0,0 +1,211 @@
+// Package hooks runs the hooks of a repository around the reference updates
+// Gitaly makes itself, with the same semantics as a push: the pre-receive and
+// update hooks can reject an update, and the post-receive hook runs once it
+// is done.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/config"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+// Names of the hooks run for reference updates
+const (
+	PreReceive  = "pre-receive"
+	Update      = "update"
+	PostReceive = "post-receive"
+)
+
+// Timeout limits how long a single hook may run before it is killed
+var Timeout = 2 * time.Minute
+
+// Change is a reference update as the hooks see it
+type Change struct {
+	Reference string
+	OldRev    string
+	NewRev    string
+}
+
+// Error means that a hook rejected an update
+type Error struct {
+	Hook   string
+	Stdout string
+	Stderr string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s hook failed: %s", e.Hook, e.Message())
+}
+
+// Message returns what the hook meant to tell the user: its standard error,
+// or its standard output if it wrote nothing there.
+func (e *Error) Message() string {
+	if strings.TrimSpace(e.Stderr) != "" {
+		return e.Stderr
+	}
+	return e.Stdout
+}
+
+// Hooks runs the hooks of a repository on behalf of a user
+type Hooks struct {
+	repoPath string
+	env      []string
+}
+
+// New returns the hooks of repo, run as if user pushed through the web.
+func New(repo *pb.Repository, user *pb.User) (*Hooks, error) {
+	repoPath, err := helper.GetRepoPath(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Hooks{
+		repoPath: repoPath,
+		env: []string{
+			"GL_ID=" + user.GetGlId(),
+			"GL_REPOSITORY=" + repo.GetGlRepository(),
+			"GL_PROTOCOL=web",
+		},
+	}, nil
+}
+
+// PreReceive runs the pre-receive hooks for all the changes at once.
+func (h *Hooks) PreReceive(ctx context.Context, changes ...Change) error {
+	return h.Run(ctx, PreReceive, formatChanges(changes))
+}
+
+// Update runs the update hooks for a single change.
+func (h *Hooks) Update(ctx context.Context, change Change) error {
+	return h.Run(ctx, Update, "", change.Reference, change.OldRev, change.NewRev)
+}
+
+// PostReceive runs the post-receive hooks for all the changes at once.
+func (h *Hooks) PostReceive(ctx context.Context, changes ...Change) error {
+	return h.Run(ctx, PostReceive, formatChanges(changes))
+}
+
+// formatChanges formats changes as git passes them to the hooks on their
+// standard input: one "<old> <new> <ref>" line per change.
+func formatChanges(changes []Change) string {
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, fmt.Sprintf("%s %s %s\n", c.OldRev, c.NewRev, c.Reference))
+	}
+
+	return strings.Join(lines, "")
+}
+
+// Run runs the hooks called name one after the other, in the order given by
+// Paths, and stops at the first one that fails. A hook that exits with a
+// non-zero status returns an *Error.
+func (h *Hooks) Run(ctx context.Context, name, stdin string, args ...string) error {
+	hookPaths, err := Paths(h.repoPath, name)
+	if err != nil {
+		return err
+	}
+
+	for _, hookPath := range hookPaths {
+		if err := h.run(ctx, name, hookPath, stdin, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *Hooks) run(ctx context.Context, name, hookPath, stdin string, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(hookPath, args...)
+	cmd.Dir = h.repoPath
+
+	hook, err := command.New(ctx, cmd, strings.NewReader(stdin), &stdout, &stderr, h.env...)
+	if err != nil {
+		return err
+	}
+
+	if err := hook.Wait(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("%s hook %s timed out after %v", name, hookPath, Timeout)
+		}
+
+		if _, ok := command.ExitStatus(err); ok {
+			return &Error{Hook: name, Stdout: stdout.String(), Stderr: stderr.String()}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Paths returns the hooks called name of the repository at repoPath: the
+// gitlab-shell hook, then the custom hook of the repository and the
+// executables in its <name>.d directory in alphabetical order. Hooks that
+// don't exist are left out.
+func Paths(repoPath, name string) ([]string, error) {
+	var paths []string
+
+	for _, p := range []string{
+		path.Join(config.Config.GitlabShell.Dir, "hooks", name),
+		path.Join(repoPath, "custom_hooks", name),
+	} {
+		ok, err := isExecutable(p)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			paths = append(paths, p)
+		}
+	}
+
+	hooksDir := path.Join(repoPath, "custom_hooks", name+".d")
+	// Sorted by name
+	entries, err := ioutil.ReadDir(hooksDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		// Skip the backup files of editors, like gitlab-shell does
+		if strings.HasSuffix(entry.Name(), "~") {
+			continue
+		}
+
+		p := path.Join(hooksDir, entry.Name())
+		ok, err := isExecutable(p)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths, nil
+}
+
+// isExecutable returns true if p is a regular file that can be executed.
+func isExecutable(p string) (bool, error) {
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return info.Mode().IsRegular() && info.Mode()&0111 != 0, nil
+}
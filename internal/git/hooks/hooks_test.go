@@ -0,0 +1,162 @@
+package hooks
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+var testUser = &pb.User{GlId: "user-123", Name: []byte("Jane Doe"), Email: []byte("janedoe@example.com")}
+
+// writeCustomHooks writes scripts into the custom_hooks directory of the
+// repository, keyed by their path in it.
+func writeCustomHooks(t *testing.T, repoPath string, scripts map[string]string) {
+	for name, script := range scripts {
+		hookPath := path.Join(repoPath, "custom_hooks", name)
+		require.NoError(t, os.MkdirAll(path.Dir(hookPath), 0755))
+		require.NoError(t, ioutil.WriteFile(hookPath, []byte(script), 0755))
+	}
+}
+
+func newTestHooks(t *testing.T) (*Hooks, string, func()) {
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	testRepo.GlRepository = "project-1"
+
+	h, err := New(testRepo, testUser)
+	require.NoError(t, err)
+
+	return h, testRepoPath, cleanupFn
+}
+
+func TestPaths(t *testing.T) {
+	cleanupShell := testhelper.SetupGitlabShellHooks(t, map[string]string{"pre-receive": "#!/bin/sh\n"})
+	defer cleanupShell()
+
+	_, testRepoPath, cleanupFn := newTestHooks(t)
+	defer cleanupFn()
+
+	writeCustomHooks(t, testRepoPath, map[string]string{
+		"pre-receive":            "#!/bin/sh\n",
+		"pre-receive.d/2-second": "#!/bin/sh\n",
+		"pre-receive.d/1-first":  "#!/bin/sh\n",
+		"pre-receive.d/backup~":  "#!/bin/sh\n",
+		"update.d/1-first":       "#!/bin/sh\n",
+	})
+	require.NoError(t, os.Chmod(path.Join(testRepoPath, "custom_hooks", "update.d", "1-first"), 0644))
+
+	paths, err := Paths(testRepoPath, PreReceive)
+	require.NoError(t, err)
+	require.Len(t, paths, 4)
+	require.True(t, strings.HasSuffix(paths[0], "/hooks/pre-receive"), paths[0])
+	require.Equal(t, []string{
+		path.Join(testRepoPath, "custom_hooks", "pre-receive"),
+		path.Join(testRepoPath, "custom_hooks", "pre-receive.d", "1-first"),
+		path.Join(testRepoPath, "custom_hooks", "pre-receive.d", "2-second"),
+	}, paths[1:])
+
+	// Hooks that can't be executed are skipped
+	paths, err = Paths(testRepoPath, Update)
+	require.NoError(t, err)
+	require.Empty(t, paths)
+}
+
+func TestSuccessfulHooks(t *testing.T) {
+	outputDir, err := ioutil.TempDir("", "gitaly-hooks")
+	require.NoError(t, err)
+	defer os.RemoveAll(outputDir)
+
+	// Each hook records its arguments, its input and its environment
+	script := "#!/bin/sh\necho \"$GL_ID $GL_REPOSITORY $GL_PROTOCOL $*\" >> " + outputDir + "/$(basename $0)\ncat >> " + outputDir + "/$(basename $0)\n"
+	cleanupShell := testhelper.SetupGitlabShellHooks(t, map[string]string{PreReceive: script, Update: script, PostReceive: script})
+	defer cleanupShell()
+
+	h, testRepoPath, cleanupFn := newTestHooks(t)
+	defer cleanupFn()
+
+	writeCustomHooks(t, testRepoPath, map[string]string{"pre-receive.d/custom": script})
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	changes := []Change{
+		{Reference: "refs/heads/master", OldRev: strings.Repeat("1", 40), NewRev: strings.Repeat("2", 40)},
+		{Reference: "refs/heads/feature", OldRev: strings.Repeat("0", 40), NewRev: strings.Repeat("3", 40)},
+	}
+	input := strings.Repeat("1", 40) + " " + strings.Repeat("2", 40) + " refs/heads/master\n" +
+		strings.Repeat("0", 40) + " " + strings.Repeat("3", 40) + " refs/heads/feature\n"
+
+	require.NoError(t, h.PreReceive(ctx, changes...))
+	require.NoError(t, h.Update(ctx, changes[0]))
+	require.NoError(t, h.PostReceive(ctx, changes...))
+
+	expectedOutputs := map[string]string{
+		"pre-receive":  "user-123 project-1 web \n" + input,
+		"custom":       "user-123 project-1 web \n" + input,
+		"update":       "user-123 project-1 web refs/heads/master " + strings.Repeat("1", 40) + " " + strings.Repeat("2", 40) + "\n",
+		"post-receive": "user-123 project-1 web \n" + input,
+	}
+	for name, expected := range expectedOutputs {
+		output, err := ioutil.ReadFile(path.Join(outputDir, name))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(output), name)
+	}
+}
+
+func TestFailedHooks(t *testing.T) {
+	cleanupShell := testhelper.SetupGitlabShellHooks(t, map[string]string{
+		PreReceive: "#!/bin/sh\necho 'GitLab: You are not allowed to push'\nexit 1\n",
+		Update:     "#!/bin/sh\necho 'some output'\necho 'GitLab: Branch is protected' >&2\nexit 1\n",
+	})
+	defer cleanupShell()
+
+	h, testRepoPath, cleanupFn := newTestHooks(t)
+	defer cleanupFn()
+
+	// Custom hooks don't run once a hook failed
+	writeCustomHooks(t, testRepoPath, map[string]string{"pre-receive": "#!/bin/sh\ntouch " + testRepoPath + "/custom-hook-ran\n"})
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	change := Change{Reference: "refs/heads/master", OldRev: strings.Repeat("1", 40), NewRev: strings.Repeat("2", 40)}
+
+	err := h.PreReceive(ctx, change)
+	require.Equal(t, &Error{Hook: PreReceive, Stdout: "GitLab: You are not allowed to push\n"}, err)
+	require.Equal(t, "GitLab: You are not allowed to push\n", err.(*Error).Message())
+	_, statErr := os.Stat(path.Join(testRepoPath, "custom-hook-ran"))
+	require.True(t, os.IsNotExist(statErr))
+
+	err = h.Update(ctx, change)
+	require.Equal(t, &Error{Hook: Update, Stdout: "some output\n", Stderr: "GitLab: Branch is protected\n"}, err)
+	require.Equal(t, "GitLab: Branch is protected\n", err.(*Error).Message())
+}
+
+func TestHookTimeout(t *testing.T) {
+	cleanupShell := testhelper.SetupGitlabShellHooks(t, map[string]string{PreReceive: "#!/bin/sh\nexec sleep 10\n"})
+	defer cleanupShell()
+
+	h, _, cleanupFn := newTestHooks(t)
+	defer cleanupFn()
+
+	defer func(oldTimeout time.Duration) {
+		Timeout = oldTimeout
+	}(Timeout)
+	Timeout = 100 * time.Millisecond
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	start := time.Now()
+	err := h.PreReceive(ctx, Change{Reference: "refs/heads/master", OldRev: strings.Repeat("1", 40), NewRev: strings.Repeat("2", 40)})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out")
+	require.True(t, time.Since(start) < 5*time.Second)
+}
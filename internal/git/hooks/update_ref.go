@@ -0,0 +1,82 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+)
+
+// UpdateRefError means that the reference didn't point to the expected old
+// value anymore, most likely because of a concurrent push.
+type UpdateRefError struct {
+	Reference string
+}
+
+func (e *UpdateRefError) Error() string {
+	return fmt.Sprintf("could not update %s: reference changed", e.Reference)
+}
+
+// UpdateReference updates reference from oldrev to newrev like a push by
+// user would. Use git.NullSha as oldrev to create the reference, or as
+// newrev to delete it. The pre-receive and update hooks can reject the
+// update with an *Error. A failing post-receive hook is only logged since
+// the reference was already updated.
+func UpdateReference(ctx context.Context, repo *pb.Repository, user *pb.User, reference, newrev, oldrev string) error {
+	h, err := New(repo, user)
+	if err != nil {
+		return err
+	}
+	change := Change{Reference: reference, OldRev: oldrev, NewRev: newrev}
+
+	if err := h.PreReceive(ctx, change); err != nil {
+		return err
+	}
+
+	if err := h.Update(ctx, change); err != nil {
+		return err
+	}
+
+	args := []string{"--git-dir", h.repoPath, "update-ref", reference, newrev, oldrev}
+	if newrev == git.NullSha {
+		args = []string{"--git-dir", h.repoPath, "update-ref", "-d", reference, oldrev}
+	}
+
+	cmd, err := command.Git(ctx, args...)
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return &UpdateRefError{Reference: reference}
+	}
+
+	if err := h.PostReceive(ctx, change); err != nil {
+		grpc_logrus.Extract(ctx).WithError(err).Warn("post-receive hook failed")
+	}
+
+	return nil
+}
+
+// RPCError splits an error returned by UpdateReference for the RPC named
+// rpcName. A rejection by a hook is returned as is, for the RPC to report
+// in the PreReceiveError of its response. Any other error is returned as a
+// gRPC error: Aborted if the reference changed, Internal otherwise.
+func RPCError(rpcName string, err error) (*Error, error) {
+	switch err := err.(type) {
+	case nil:
+		return nil, nil
+	case *Error:
+		return err, nil
+	case *UpdateRefError:
+		return nil, grpc.Errorf(codes.Aborted, "%s: %v", rpcName, err)
+	default:
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+}
@@ -0,0 +1,82 @@
+package hooks
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+func TestUpdateReference(t *testing.T) {
+	cleanupShell := testhelper.SetupGitlabShellHooks(t, map[string]string{PreReceive: "#!/bin/sh\n", Update: "#!/bin/sh\n", PostReceive: "#!/bin/sh\nexit 1\n"})
+	defer cleanupShell()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	master := strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "rev-parse", "master")))
+	parent := strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "rev-parse", "master~1")))
+	resolve := func(reference string) string {
+		id, err := git.ResolveRevision(ctx, testRepoPath, reference)
+		require.NoError(t, err)
+		return id
+	}
+
+	// A failing post-receive hook doesn't fail the update
+	require.NoError(t, UpdateReference(ctx, testRepo, testUser, "refs/heads/new-branch", parent, git.NullSha))
+	require.Equal(t, parent, resolve("refs/heads/new-branch"))
+
+	err := UpdateReference(ctx, testRepo, testUser, "refs/heads/new-branch", master, master)
+	require.Equal(t, &UpdateRefError{Reference: "refs/heads/new-branch"}, err)
+	require.Equal(t, parent, resolve("refs/heads/new-branch"))
+
+	require.NoError(t, UpdateReference(ctx, testRepo, testUser, "refs/heads/new-branch", git.NullSha, parent))
+	require.Empty(t, resolve("refs/heads/new-branch"))
+}
+
+func TestUpdateReferenceRejectedByHook(t *testing.T) {
+	cleanupShell := testhelper.SetupGitlabShellHooks(t, map[string]string{Update: "#!/bin/sh\necho 'GitLab: Branch is protected' >&2\nexit 1\n"})
+	defer cleanupShell()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	master := strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "rev-parse", "master")))
+
+	err := UpdateReference(ctx, testRepo, testUser, "refs/heads/master", git.NullSha, master)
+	require.Equal(t, &Error{Hook: Update, Stderr: "GitLab: Branch is protected\n"}, err)
+
+	id, err := git.ResolveRevision(ctx, testRepoPath, "refs/heads/master")
+	require.NoError(t, err)
+	require.Equal(t, master, id)
+}
+
+func TestRPCError(t *testing.T) {
+	hookErr, err := RPCError("UserFFBranch", nil)
+	require.Nil(t, hookErr)
+	require.NoError(t, err)
+
+	rejection := &Error{Hook: PreReceive, Stderr: "GitLab: Branch is protected\n"}
+	hookErr, err = RPCError("UserFFBranch", rejection)
+	require.Equal(t, rejection, hookErr)
+	require.NoError(t, err)
+
+	hookErr, err = RPCError("UserFFBranch", &UpdateRefError{Reference: "refs/heads/master"})
+	require.Nil(t, hookErr)
+	testhelper.AssertGrpcError(t, err, codes.Aborted, "UserFFBranch: could not update refs/heads/master")
+
+	hookErr, err = RPCError("UserFFBranch", fmt.Errorf("exec failed"))
+	require.Nil(t, hookErr)
+	testhelper.AssertGrpcError(t, err, codes.Internal, "UserFFBranch: exec failed")
+}
@@ -0,0 +1,396 @@
+// Package index builds git trees in a temporary index file, so that
+// operations like merges can be done in a bare repository without a
+// worktree.
+package index
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+)
+
+const nullOid = "0000000000000000000000000000000000000000"
+
+// The modes of the files that can be merged line by line
+const (
+	// RegularFileMode is the mode of a regular file
+	RegularFileMode = "100644"
+	// ExecutableFileMode is the mode of an executable file
+	ExecutableFileMode = "100755"
+)
+
+// ErrNotMergeable means that a conflict can't be merged line by line, for
+// example because one of its sides is a binary file or was deleted.
+var ErrNotMergeable = errors.New("conflict can't be merged line by line")
+
+// Index is a git index file outside of the repository. Index files are
+// written by git on demand, so a new Index is empty.
+type Index struct {
+	repoPath string
+	tempDir  string
+	path     string
+}
+
+// Entry is a stage of a path in the index
+type Entry struct {
+	Mode string
+	Oid  string
+}
+
+// Conflict is a path that could not be merged. An entry is nil if the path
+// doesn't exist on that side of the merge.
+type Conflict struct {
+	Path     string
+	Ancestor *Entry
+	Ours     *Entry
+	Theirs   *Entry
+}
+
+// New creates an empty index for the repository at repoPath. The index
+// and the other files it needs are kept in tempDir, which is not cleaned up.
+func New(repoPath, tempDir string) *Index {
+	return &Index{
+		repoPath: repoPath,
+		tempDir:  tempDir,
+		path:     path.Join(tempDir, "index"),
+	}
+}
+
+// Env returns the environment that makes git commands use the index.
+func (idx *Index) Env() []string {
+	return []string{"GIT_INDEX_FILE=" + idx.path}
+}
+
+// Run runs a git command against the index and returns its standard
+// output. The error includes the standard error of the command.
+func (idx *Index) Run(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	args = append([]string{"--git-dir", idx.repoPath}, args...)
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), args...), stdin, &stdout, &stderr, idx.Env()...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return stdout.Bytes(), fmt.Errorf("git %s: %v: %s", args[2], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// ReadTree replaces the content of the index with the given tree.
+func (idx *Index) ReadTree(ctx context.Context, treeish string) error {
+	_, err := idx.Run(ctx, nil, "read-tree", treeish)
+	return err
+}
+
+// WriteTree writes the index as a tree object and returns its ID.
+func (idx *Index) WriteTree(ctx context.Context) (string, error) {
+	out, err := idx.Run(ctx, nil, "write-tree")
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// Entry returns the entry of path, or nil if the index has none.
+func (idx *Index) Entry(ctx context.Context, p string) (*Entry, error) {
+	entries, err := idx.list(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.path == p && e.stage == "0" {
+			return &e.Entry, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// IsDirectory returns true if the index has entries below the directory p.
+func (idx *Index) IsDirectory(ctx context.Context, p string) (bool, error) {
+	entries, err := idx.list(ctx, p)
+	if err != nil {
+		return false, err
+	}
+
+	for _, e := range entries {
+		if strings.HasPrefix(e.path, p+"/") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+type listEntry struct {
+	Entry
+	path  string
+	stage string
+}
+
+// list returns the entries of the index that match the pathspec p, taken
+// literally.
+func (idx *Index) list(ctx context.Context, p string) ([]listEntry, error) {
+	out, err := idx.Run(ctx, nil, "ls-files", "--stage", "-z", "--", ":(literal)"+p)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLsFiles(out)
+}
+
+// parseLsFiles parses the output of ls-files --stage -z
+func parseLsFiles(out []byte) ([]listEntry, error) {
+	var entries []listEntry
+
+	for _, line := range bytes.Split(out, []byte{0}) {
+		if len(line) == 0 {
+			continue
+		}
+
+		// <mode> SP <oid> SP <stage> TAB <path>
+		tab := bytes.IndexByte(line, '\t')
+		if tab < 0 {
+			return nil, fmt.Errorf("invalid ls-files line: %q", line)
+		}
+
+		fields := strings.Fields(string(line[:tab]))
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid ls-files line: %q", line)
+		}
+
+		entries = append(entries, listEntry{
+			Entry: Entry{Mode: fields[0], Oid: fields[1]},
+			path:  string(line[tab+1:]),
+			stage: fields[2],
+		})
+	}
+
+	return entries, nil
+}
+
+// Add adds path to the index, or replaces its entry.
+func (idx *Index) Add(ctx context.Context, p string, entry Entry) error {
+	info := fmt.Sprintf("%s %s\t%s\x00", entry.Mode, entry.Oid, p)
+	_, err := idx.Run(ctx, strings.NewReader(info), "update-index", "-z", "--index-info")
+	return err
+}
+
+// Remove removes path from the index.
+func (idx *Index) Remove(ctx context.Context, p string) error {
+	// Mode 0 removes the path. Unlike update-index --force-remove, this
+	// doesn't need a worktree.
+	return idx.Add(ctx, p, Entry{Mode: "0", Oid: nullOid})
+}
+
+// WriteBlob writes the content read from r as a blob and returns its ID.
+func (idx *Index) WriteBlob(ctx context.Context, r io.Reader) (string, error) {
+	out, err := idx.Run(ctx, r, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(out)), nil
+}
+
+// Merge does a three-way merge of the trees of ours and theirs into the
+// index, using base as their common ancestor. Paths changed on both sides
+// are merged line by line. The paths that still conflict are returned; the
+// index must not be written as a tree if there are any.
+func (idx *Index) Merge(ctx context.Context, base, ours, theirs string) ([]Conflict, error) {
+	if _, err := idx.Run(ctx, nil, "read-tree", "-i", "-m", "--aggressive", base, ours, theirs); err != nil {
+		return nil, err
+	}
+
+	unmerged, err := idx.unmergedPaths(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []Conflict
+	var resolved bytes.Buffer
+
+	for _, c := range unmerged {
+		entry, err := idx.resolve(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry == nil {
+			conflicts = append(conflicts, c)
+			continue
+		}
+
+		fmt.Fprintf(&resolved, "%s %s\t%s\x00", entry.Mode, entry.Oid, c.Path)
+	}
+
+	if resolved.Len() > 0 {
+		// Adding a path at stage 0 removes its unmerged stages
+		if _, err := idx.Run(ctx, &resolved, "update-index", "-z", "--index-info"); err != nil {
+			return nil, err
+		}
+	}
+
+	return conflicts, nil
+}
+
+// unmergedPaths lists the paths of the index that have unmerged stages.
+func (idx *Index) unmergedPaths(ctx context.Context) ([]Conflict, error) {
+	out, err := idx.Run(ctx, nil, "ls-files", "-u", "-z")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := parseLsFiles(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []Conflict
+	byPath := make(map[string]int)
+
+	for _, e := range entries {
+		i, ok := byPath[e.path]
+		if !ok {
+			conflicts = append(conflicts, Conflict{Path: e.path})
+			i = len(conflicts) - 1
+			byPath[e.path] = i
+		}
+
+		entry := &Entry{Mode: e.Mode, Oid: e.Oid}
+		switch e.stage {
+		case "1":
+			conflicts[i].Ancestor = entry
+		case "2":
+			conflicts[i].Ours = entry
+		case "3":
+			conflicts[i].Theirs = entry
+		}
+	}
+
+	return conflicts, nil
+}
+
+// resolve tries to merge a path that read-tree could not, and returns the
+// resulting entry or nil if it conflicts.
+func (idx *Index) resolve(ctx context.Context, c Conflict) (*Entry, error) {
+	merged, clean, err := idx.mergeFile(ctx, c, "ours", "theirs")
+	if err == ErrNotMergeable || err == nil && !clean {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	oid, err := idx.WriteBlob(ctx, bytes.NewReader(merged))
+	if err != nil {
+		return nil, err
+	}
+
+	mode, _ := c.MergedMode()
+	return &Entry{Mode: mode, Oid: oid}, nil
+}
+
+// MergedMode does a three-way merge of the file modes of a conflict. It
+// returns false if both sides changed the mode.
+func (c Conflict) MergedMode() (string, bool) {
+	switch {
+	case c.Ours == nil || c.Theirs == nil:
+		return "", false
+	case c.Ours.Mode == c.Theirs.Mode:
+		return c.Ours.Mode, true
+	case c.Ancestor != nil && c.Ancestor.Mode == c.Ours.Mode:
+		return c.Theirs.Mode, true
+	case c.Ancestor != nil && c.Ancestor.Mode == c.Theirs.Mode:
+		return c.Ours.Mode, true
+	default:
+		return "", false
+	}
+}
+
+func isRegularFile(mode string) bool {
+	return mode == RegularFileMode || mode == ExecutableFileMode
+}
+
+// MergeFile merges the content of a conflict line by line and returns the
+// result. The conflicting sections are surrounded by conflict markers
+// labelled with ourLabel and theirLabel.
+func (idx *Index) MergeFile(ctx context.Context, c Conflict, ourLabel, theirLabel string) ([]byte, error) {
+	merged, _, err := idx.mergeFile(ctx, c, ourLabel, theirLabel)
+	return merged, err
+}
+
+// mergeFile merges the content of a path line by line with git merge-file
+// and reports whether there were no conflicts.
+func (idx *Index) mergeFile(ctx context.Context, c Conflict, ourLabel, theirLabel string) ([]byte, bool, error) {
+	// Deleted on one side, modified on the other
+	if c.Ours == nil || c.Theirs == nil {
+		return nil, false, ErrNotMergeable
+	}
+
+	if _, ok := c.MergedMode(); !ok || !isRegularFile(c.Ours.Mode) || !isRegularFile(c.Theirs.Mode) {
+		return nil, false, ErrNotMergeable
+	}
+
+	var files []string
+	for i, entry := range []*Entry{c.Ours, c.Ancestor, c.Theirs} {
+		var content []byte
+		if entry != nil {
+			var err error
+			if content, err = idx.Run(ctx, nil, "cat-file", "blob", entry.Oid); err != nil {
+				return nil, false, err
+			}
+		}
+
+		if isBinary(content) {
+			return nil, false, ErrNotMergeable
+		}
+
+		file := path.Join(idx.tempDir, fmt.Sprintf("merge-file-%d", i))
+		if err := ioutil.WriteFile(file, content, 0600); err != nil {
+			return nil, false, err
+		}
+		files = append(files, file)
+	}
+
+	var stdout bytes.Buffer
+	args := append([]string{"--git-dir", idx.repoPath, "merge-file", "-p", "-L", ourLabel, "-L", "base", "-L", theirLabel}, files...)
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), args...), nil, &stdout, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = cmd.Wait()
+	if err == nil {
+		return stdout.Bytes(), true, nil
+	}
+
+	// A positive exit status is the number of conflicts
+	if status, ok := command.ExitStatus(err); ok && status > 0 && status < 128 {
+		return stdout.Bytes(), false, nil
+	}
+
+	return nil, false, fmt.Errorf("git merge-file: %v", err)
+}
+
+// isBinary uses the same heuristic as git: a file is binary if it has a NUL
+// byte in its first 8000 bytes.
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+
+	return bytes.IndexByte(content, 0) >= 0
+}
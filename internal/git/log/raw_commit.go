@@ -0,0 +1,144 @@
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+)
+
+var trailerKeyRegex = regexp.MustCompile(`\A[A-Za-z0-9-]+\z`)
+
+// ParseRawCommit parses the content of a commit object, as printed by git
+// cat-file. The commit has the same fields as the commits read with
+// GetCommit.
+func ParseRawCommit(id string, raw []byte) (*pb.GitCommit, error) {
+	header, message := splitRawObject(raw)
+
+	commit := &pb.GitCommit{Id: id, Body: message, Subject: messageSubject(message)}
+
+	for _, line := range bytes.Split(header, []byte("\n")) {
+		field, value := splitHeaderLine(line)
+
+		switch field {
+		case "parent":
+			commit.ParentIds = append(commit.ParentIds, string(value))
+		case "author":
+			commit.Author = ParseCommitAuthor(value)
+		case "committer":
+			commit.Committer = ParseCommitAuthor(value)
+		}
+	}
+
+	if commit.Author == nil || commit.Committer == nil {
+		return nil, fmt.Errorf("invalid commit %s: missing author or committer", id)
+	}
+
+	return commit, nil
+}
+
+// splitRawObject splits the content of a commit or tag object into its
+// header and its message, which are separated by an empty line.
+func splitRawObject(raw []byte) ([]byte, []byte) {
+	if bytes.HasPrefix(raw, []byte("\n")) {
+		return nil, raw[1:]
+	}
+
+	if i := bytes.Index(raw, []byte("\n\n")); i >= 0 {
+		return raw[:i], raw[i+2:]
+	}
+
+	return bytes.TrimSuffix(raw, []byte("\n")), nil
+}
+
+func splitHeaderLine(line []byte) (string, []byte) {
+	i := bytes.IndexByte(line, ' ')
+	if i < 0 {
+		return string(line), nil
+	}
+
+	return string(line[:i]), line[i+1:]
+}
+
+// messageSubject returns the subject of a message like git log --format=%s
+// does: the lines of the first paragraph joined with spaces.
+func messageSubject(message []byte) []byte {
+	var lines [][]byte
+
+	for _, line := range bytes.Split(message, []byte("\n")) {
+		line = bytes.TrimRight(line, " \t\n\v\f\r")
+		if len(line) > 0 {
+			lines = append(lines, line)
+		} else if len(lines) > 0 {
+			break
+		}
+	}
+
+	return bytes.Join(lines, []byte(" "))
+}
+
+// ParseCommitAuthor parses the value of an author, committer or tagger
+// header, which looks like: Jane Doe <jane@example.com> 1500000000 +0200
+func ParseCommitAuthor(value []byte) *pb.CommitAuthor {
+	author := &pb.CommitAuthor{}
+
+	emailStart := bytes.IndexByte(value, '<')
+	emailEnd := bytes.LastIndexByte(value, '>')
+	if emailStart < 0 || emailEnd < emailStart {
+		author.Name = value
+		return author
+	}
+
+	author.Name = bytes.TrimSpace(value[:emailStart])
+	author.Email = value[emailStart+1 : emailEnd]
+
+	var seconds string
+	if fields := bytes.Fields(value[emailEnd+1:]); len(fields) > 0 {
+		seconds = string(fields[0])
+	}
+	author.Date = &timestamp.Timestamp{Seconds: git.SafeTimeUnix(seconds).Unix()}
+
+	return author
+}
+
+// ParseCommitTrailers returns the trailers of a commit message, like
+// Signed-off-by: Jane Doe <jane@example.com>. As with git
+// interpret-trailers, they are the lines of the last paragraph of the
+// message, which can't be the subject, and every line of that paragraph
+// must be a trailer or the continuation of one.
+func ParseCommitTrailers(message []byte) []*pb.CommitTrailer {
+	message = bytes.TrimRight(message, " \t\r\n")
+
+	i := bytes.LastIndex(message, []byte("\n\n"))
+	if i < 0 {
+		return nil
+	}
+
+	var trailers []*pb.CommitTrailer
+	for _, line := range bytes.Split(message[i+2:], []byte("\n")) {
+		line = bytes.TrimRight(line, " \t\r")
+
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			if len(trailers) == 0 {
+				return nil
+			}
+
+			last := trailers[len(trailers)-1]
+			last.Value = bytes.Join([][]byte{last.Value, bytes.TrimSpace(line)}, []byte(" "))
+			continue
+		}
+
+		sep := bytes.IndexByte(line, ':')
+		if sep < 0 || !trailerKeyRegex.Match(line[:sep]) {
+			return nil
+		}
+
+		trailers = append(trailers, &pb.CommitTrailer{Key: line[:sep], Value: bytes.TrimSpace(line[sep+1:])})
+	}
+
+	return trailers
+}
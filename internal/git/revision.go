@@ -0,0 +1,79 @@
+package git
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+)
+
+// NullSha is the object ID git uses for a reference that doesn't exist
+const NullSha = "0000000000000000000000000000000000000000"
+
+// ResolveRevision returns the ID of the object revision points to, or an
+// empty string if there is none.
+func ResolveRevision(ctx context.Context, repoPath, revision string) (string, error) {
+	var stdout bytes.Buffer
+
+	args := []string{"--git-dir", repoPath, "rev-parse", "--quiet", "--verify", revision}
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), args...), nil, &stdout, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := command.ExitStatus(err); ok {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// ResolveCommit returns the ID of the commit revision points to, or an
+// empty string if there is none.
+func ResolveCommit(ctx context.Context, repoPath, revision string) (string, error) {
+	return ResolveRevision(ctx, repoPath, revision+"^{commit}")
+}
+
+// MergeBase returns the best common ancestor of two commits, or an empty
+// string if they have none.
+func MergeBase(ctx context.Context, repoPath, commit1, commit2 string) (string, error) {
+	var stdout bytes.Buffer
+
+	args := []string{"--git-dir", repoPath, "merge-base", commit1, commit2}
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), args...), nil, &stdout, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if status, ok := command.ExitStatus(err); ok && status == 1 {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// IsAncestor returns true if ancestor can be reached from commit.
+func IsAncestor(ctx context.Context, repoPath, ancestor, commit string) (bool, error) {
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "merge-base", "--is-ancestor", ancestor, commit)
+	if err != nil {
+		return false, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if status, ok := command.ExitStatus(err); ok && status == 1 {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,222 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/config"
+	"gitlab.com/gitlab-org/gitaly/internal/rubyserver"
+)
+
+var (
+	// Interval is the time between two rounds of checks
+	Interval = 10 * time.Second
+
+	// Timeout is the maximum time a single check may take
+	Timeout = 5 * time.Second
+)
+
+const (
+	gitCheckName  = "git"
+	rubyCheckName = "gitaly-ruby"
+)
+
+// Result is the outcome of a single check
+type Result struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Reporter periodically checks the storages, the git binary and
+// gitaly-ruby. It serves the outcome through the gRPC health service and
+// as an HTTP readiness endpoint.
+//
+// A failing git binary or storage marks the whole server as not serving.
+// When gitaly-ruby is down only the services that depend on it are marked
+// as not serving.
+type Reporter struct {
+	ruby *rubyserver.Server
+
+	mu sync.RWMutex
+	// Maps the full name of each service to whether it depends on gitaly-ruby
+	services map[string]bool
+	results  []Result
+	// ready is true when the git binary and all storages are healthy
+	ready bool
+	// rubyHealthy is true when gitaly-ruby is healthy
+	rubyHealthy bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewReporter creates a Reporter for the given gitaly-ruby server. Nothing
+// is reported as serving until the first round of checks ran.
+func NewReporter(rubyServer *rubyserver.Server) *Reporter {
+	return &Reporter{
+		ruby:     rubyServer,
+		services: make(map[string]bool),
+		stop:     make(chan struct{}),
+	}
+}
+
+// SetServices sets the names of the services to report the status of, and
+// which of them depend on gitaly-ruby.
+func (r *Reporter) SetServices(services []string, rubyServices []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.services = make(map[string]bool)
+	for _, service := range services {
+		r.services[service] = false
+	}
+	for _, service := range rubyServices {
+		r.services[service] = true
+	}
+}
+
+// Start runs a first round of checks and then keeps checking every
+// Interval in the background until Stop is called.
+func (r *Reporter) Start() {
+	r.RunChecks()
+
+	go func() {
+		ticker := time.NewTicker(Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.RunChecks()
+			}
+		}
+	}()
+}
+
+// Stop stops the background checks.
+func (r *Reporter) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// RunChecks checks all dependencies once and updates the reported status.
+func (r *Reporter) RunChecks() {
+	ready := true
+	var results []Result
+
+	for _, storage := range config.Config.Storages {
+		_, err := CheckStorage(storage.Path)
+		result := newResult("storage:"+storage.Name, err)
+		ready = ready && result.Healthy
+		results = append(results, result)
+	}
+
+	gitResult := newResult(gitCheckName, checkGit())
+	ready = ready && gitResult.Healthy
+	results = append(results, gitResult)
+
+	rubyResult := newResult(rubyCheckName, r.ruby.CheckHealth(Timeout))
+	results = append(results, rubyResult)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	logChanges(r.results, results)
+
+	r.results = results
+	r.ready = ready
+	r.rubyHealthy = rubyResult.Healthy
+}
+
+// Check implements the Check RPC of the gRPC health service.
+func (r *Reporter) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	serving := r.ready
+	if in.Service != "" {
+		dependsOnRuby, ok := r.services[in.Service]
+		if !ok {
+			return nil, grpc.Errorf(codes.NotFound, "unknown service")
+		}
+
+		serving = serving && (r.rubyHealthy || !dependsOnRuby)
+	}
+
+	if serving {
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+	}
+
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+}
+
+type readinessResponse struct {
+	Ready  bool     `json:"ready"`
+	Checks []Result `json:"checks"`
+}
+
+// ServeHTTP serves the results of the last round of checks as JSON, with
+// status 503 if the server is not ready.
+func (r *Reporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	response := readinessResponse{Ready: r.ready, Checks: r.results}
+	r.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !response.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func newResult(name string, err error) Result {
+	if err != nil {
+		return Result{Name: name, Error: err.Error()}
+	}
+
+	return Result{Name: name, Healthy: true}
+}
+
+func logChanges(previous, current []Result) {
+	wasHealthy := make(map[string]bool)
+	for _, result := range previous {
+		wasHealthy[result.Name] = result.Healthy
+	}
+
+	for _, result := range current {
+		healthy, ok := wasHealthy[result.Name]
+		if ok && healthy == result.Healthy {
+			continue
+		}
+
+		logger := log.WithField("check", result.Name)
+		if result.Healthy {
+			logger.Info("health check passed")
+		} else {
+			logger.WithField("error", result.Error).Warn("health check failed")
+		}
+	}
+}
+
+func checkGit() error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	// Not using command.Git because it would log every spawn
+	return exec.CommandContext(ctx, command.GitPath(), "--version").Run()
+}
@@ -0,0 +1,130 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"gitlab.com/gitlab-org/gitaly/internal/config"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+func TestReporter(t *testing.T) {
+	defer func(oldStorages []config.Storage) {
+		config.Config.Storages = oldStorages
+	}(config.Config.Storages)
+
+	storagePath, err := ioutil.TempDir("", "gitaly-healthcheck")
+	require.NoError(t, err)
+	defer os.RemoveAll(storagePath)
+
+	config.Config.Storages = []config.Storage{{Name: "default", Path: storagePath}}
+
+	reporter := NewReporter(nil)
+	reporter.SetServices([]string{"gitaly.BlobService"}, []string{"gitaly.CommitService"})
+
+	// Nothing is serving before the first round of checks
+	requireStatus(t, reporter, "", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireReadiness(t, reporter, http.StatusServiceUnavailable)
+
+	reporter.RunChecks()
+
+	requireStatus(t, reporter, "", healthpb.HealthCheckResponse_SERVING)
+	requireStatus(t, reporter, "gitaly.BlobService", healthpb.HealthCheckResponse_SERVING)
+	// gitaly-ruby was not started
+	requireStatus(t, reporter, "gitaly.CommitService", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	response := requireReadiness(t, reporter, http.StatusOK)
+	require.True(t, response.Ready)
+	require.Equal(t, []Result{
+		{Name: "storage:default", Healthy: true},
+		{Name: "git", Healthy: true},
+		{Name: "gitaly-ruby", Error: "gitaly-ruby was not started"},
+	}, response.Checks)
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	_, err = reporter.Check(ctx, &healthpb.HealthCheckRequest{Service: "gitaly.UnknownService"})
+	testhelper.AssertGrpcError(t, err, codes.NotFound, "")
+
+	// Lose the storage
+	config.Config.Storages[0].Path = path.Join(storagePath, "missing")
+	reporter.RunChecks()
+
+	requireStatus(t, reporter, "", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus(t, reporter, "gitaly.BlobService", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	response = requireReadiness(t, reporter, http.StatusServiceUnavailable)
+	require.False(t, response.Ready)
+	require.Equal(t, "storage:default", response.Checks[0].Name)
+	require.False(t, response.Checks[0].Healthy)
+	require.NotEmpty(t, response.Checks[0].Error)
+}
+
+func TestCheckStorageTimeout(t *testing.T) {
+	defer func(oldTimeout time.Duration, oldProbe func(string) (StorageStatus, error)) {
+		Timeout = oldTimeout
+		probeStorage = oldProbe
+	}(Timeout, probeStorage)
+
+	hung := make(chan struct{})
+	var probeCount int32
+
+	Timeout = 10 * time.Millisecond
+	probeStorage = func(string) (StorageStatus, error) {
+		atomic.AddInt32(&probeCount, 1)
+		<-hung
+		return StorageStatus{Writeable: true}, nil
+	}
+
+	_, err := CheckStorage("/hung/storage")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out")
+
+	// The hung probe is reused instead of starting another one
+	_, err = CheckStorage("/hung/storage")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "still pending")
+	require.Equal(t, int32(1), atomic.LoadInt32(&probeCount))
+
+	// Once the filesystem returns, checks pass again
+	close(hung)
+	Timeout = time.Second
+
+	status, err := CheckStorage("/hung/storage")
+	require.NoError(t, err)
+	require.True(t, status.Writeable)
+}
+
+func requireStatus(t *testing.T, reporter *Reporter, service string, expected healthpb.HealthCheckResponse_ServingStatus) {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	resp, err := reporter.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+	require.Equal(t, expected, resp.Status, "status of service %q", service)
+}
+
+func requireReadiness(t *testing.T, reporter *Reporter, expectedCode int) *readinessResponse {
+	recorder := httptest.NewRecorder()
+	reporter.ServeHTTP(recorder, httptest.NewRequest("GET", "/readiness", nil))
+
+	require.Equal(t, expectedCode, recorder.Code)
+	require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	response := &readinessResponse{}
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(response))
+
+	return response
+}
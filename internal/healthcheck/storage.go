@@ -0,0 +1,116 @@
+package healthcheck
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// StorageStatus is the outcome of probing a readable storage
+type StorageStatus struct {
+	Writeable bool
+	FreeBytes uint64
+}
+
+type storageProbe struct {
+	done   chan struct{}
+	status StorageStatus
+	err    error
+}
+
+var (
+	probesMu sync.Mutex
+	// The probes that haven't returned yet, by storage path
+	probes = make(map[string]*storageProbe)
+)
+
+// CheckStorage probes the storage at storagePath and returns an error if it
+// isn't a readable directory or if the probe takes longer than Timeout.
+//
+// A hung filesystem can block a probe forever, so it runs in the background
+// and at most one probe per storage is in flight. Callers arriving while a
+// probe is running wait for that probe instead of starting another one.
+func CheckStorage(storagePath string) (StorageStatus, error) {
+	probesMu.Lock()
+	probe, pending := probes[storagePath]
+	if !pending {
+		probe = &storageProbe{done: make(chan struct{})}
+		probes[storagePath] = probe
+
+		go func() {
+			probe.status, probe.err = probeStorage(storagePath)
+
+			probesMu.Lock()
+			delete(probes, storagePath)
+			probesMu.Unlock()
+
+			close(probe.done)
+		}()
+	}
+	probesMu.Unlock()
+
+	timer := time.NewTimer(Timeout)
+	defer timer.Stop()
+
+	select {
+	case <-probe.done:
+		return probe.status, probe.err
+	case <-timer.C:
+		if pending {
+			return StorageStatus{}, fmt.Errorf("storage check: previous check still pending")
+		}
+		return StorageStatus{}, fmt.Errorf("storage check: timed out after %v", Timeout)
+	}
+}
+
+// probeStorage checks that the storage is a readable directory, and whether
+// it is writeable. It is a variable to make it easier to override in tests.
+var probeStorage = func(storagePath string) (StorageStatus, error) {
+	fi, err := os.Stat(storagePath)
+	if err != nil {
+		return StorageStatus{}, err
+	}
+
+	if !fi.IsDir() {
+		return StorageStatus{}, fmt.Errorf("not a directory: %s", storagePath)
+	}
+
+	dir, err := os.Open(storagePath)
+	if err != nil {
+		return StorageStatus{}, err
+	}
+	defer dir.Close()
+
+	// An empty storage is still readable
+	if _, err := dir.Readdirnames(1); err != nil && err != io.EOF {
+		return StorageStatus{}, err
+	}
+
+	return StorageStatus{
+		Writeable: storageWriteable(storagePath),
+		FreeBytes: storageFreeBytes(storagePath),
+	}, nil
+}
+
+func storageWriteable(storagePath string) bool {
+	f, err := ioutil.TempFile(storagePath, ".gitaly-write-check")
+	if err != nil {
+		return false
+	}
+	f.Close()
+
+	return os.Remove(f.Name()) == nil
+}
+
+func storageFreeBytes(storagePath string) uint64 {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(storagePath, &stat); err != nil {
+		return 0
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize)
+}
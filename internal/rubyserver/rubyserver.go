@@ -104,6 +104,25 @@ func Start() (*Server, error) {
 	return &Server{Process: p}, err
 }
 
+// CheckHealth returns an error if gitaly-ruby is not running or does not
+// accept connections on its socket within timeout.
+func (s *Server) CheckHealth(timeout time.Duration) error {
+	if s == nil || s.Process == nil {
+		return fmt.Errorf("gitaly-ruby was not started")
+	}
+
+	if s.Status().CircuitBreakerOpen {
+		return fmt.Errorf("gitaly-ruby circuit breaker is open")
+	}
+
+	conn, err := net.DialTimeout("unix", socketPath(), timeout)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
 // CommitServiceClient returns a CommitServiceClient instance that is
 // configured to connect to the running Ruby server. This assumes Start()
 // has been called already.
@@ -7,6 +7,7 @@ import (
 
 	"gitlab.com/gitlab-org/gitaly/auth"
 	"gitlab.com/gitlab-org/gitaly/internal/config"
+	"gitlab.com/gitlab-org/gitaly/internal/healthcheck"
 	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
 
 	"github.com/stretchr/testify/assert"
@@ -138,7 +139,7 @@ func healthCheck(conn *grpc.ClientConn) error {
 }
 
 func runServer(t *testing.T) *grpc.Server {
-	srv := New(nil)
+	srv := New(nil, healthcheck.NewReporter(nil))
 
 	listener, err := net.Listen("unix", serverSocketPath)
 	require.NoError(t, err)
@@ -3,6 +3,7 @@ package server
 import (
 	log "github.com/Sirupsen/logrus"
 
+	"gitlab.com/gitlab-org/gitaly/internal/healthcheck"
 	"gitlab.com/gitlab-org/gitaly/internal/helper/fieldextractors"
 	"gitlab.com/gitlab-org/gitaly/internal/middleware/cancelhandler"
 	"gitlab.com/gitlab-org/gitaly/internal/middleware/objectdirhandler"
@@ -21,7 +22,8 @@ import (
 )
 
 // New returns a GRPC server with all Gitaly services and interceptors set up.
-func New(rubyServer *rubyserver.Server) *grpc.Server {
+// The health service reports the status checked by healthReporter.
+func New(rubyServer *rubyserver.Server, healthReporter *healthcheck.Reporter) *grpc.Server {
 	logrusEntry := log.NewEntry(log.StandardLogger())
 	grpc_logrus.ReplaceGrpcLogger(logrusEntry)
 
@@ -56,7 +58,7 @@ func New(rubyServer *rubyserver.Server) *grpc.Server {
 		)),
 	)
 
-	service.RegisterAll(server, rubyServer)
+	service.RegisterAll(server, rubyServer, healthReporter)
 	reflection.Register(server)
 
 	grpc_prometheus.Register(server)
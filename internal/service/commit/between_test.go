@@ -182,6 +182,28 @@ func TestSuccessfulCommitsBetween(t *testing.T) {
 	}
 }
 
+func TestCommitsBetweenEmptyRange(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c, err := client.CommitsBetween(ctx, &pb.CommitsBetweenRequest{Repository: testRepo, From: []byte("master"), To: []byte("master")})
+	require.NoError(t, err)
+
+	// An empty range is still answered with a single, empty, message
+	resp, err := c.Recv()
+	require.NoError(t, err)
+	require.Empty(t, resp.GetCommits())
+
+	_, err = c.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
 func TestFailedCommitsBetweenRequest(t *testing.T) {
 	server := startTestServices(t)
 	defer server.Stop()
@@ -0,0 +1,344 @@
+package commit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/catfile"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Blame streams the blame of a file parsed from git blame -p, with the line
+// ranges grouped by commit. Unlike RawBlame, git errors like a missing path
+// are reported.
+func (s *server) Blame(in *pb.BlameRequest, stream pb.CommitService_BlameServer) error {
+	if err := validateBlameRequest(in); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "Blame: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	args := []string{"--git-dir", repoPath, "blame", "-p"}
+
+	if in.GetIgnoreWhitespace() {
+		args = append(args, "-w")
+	}
+	if in.GetDetectMoves() {
+		args = append(args, "-M")
+	}
+	if in.GetDetectCopies() {
+		args = append(args, "-C")
+	}
+
+	if start, end := in.GetStartLine(), in.GetEndLine(); start > 0 || end > 0 {
+		if start == 0 {
+			start = 1
+		}
+
+		lineRange := fmt.Sprintf("%d,", start)
+		if end > 0 {
+			lineRange += strconv.Itoa(int(end))
+		}
+		args = append(args, "-L", lineRange)
+	}
+
+	// The ignore revs file comes from the repository, and is given to git
+	// blame on its standard input
+	var stdin io.Reader
+	if ignoreRevsPath := in.GetIgnoreRevsPath(); len(ignoreRevsPath) > 0 {
+		ignoreRevs, err := readBlob(ctx, repoPath, string(in.GetRevision())+":"+string(ignoreRevsPath))
+		if err != nil {
+			return grpc.Errorf(codes.Internal, "Blame: %v", err)
+		}
+		if ignoreRevs == nil {
+			return grpc.Errorf(codes.InvalidArgument, "Blame: ignore revs file not found: %q", ignoreRevsPath)
+		}
+
+		stdin = bytes.NewReader(ignoreRevs)
+		args = append(args, "--ignore-revs-file", "/dev/stdin")
+	}
+
+	args = append(args, string(in.GetRevision()), "--", string(in.GetPath()))
+
+	var stderr bytes.Buffer
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), args...), stdin, nil, &stderr)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "Blame: cmd: %v", err)
+	}
+
+	groups, err := parseBlame(cmd)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "Blame: parse: %v", err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := command.ExitStatus(err); ok {
+			return grpc.Errorf(codes.InvalidArgument, "Blame: %s", strings.TrimSpace(stderr.String()))
+		}
+		return grpc.Errorf(codes.Internal, "Blame: %v", err)
+	}
+
+	return sendBlameGroups(stream, groups)
+}
+
+func validateBlameRequest(in *pb.BlameRequest) error {
+	if err := git.ValidateRevision(in.GetRevision()); err != nil {
+		return fmt.Errorf("revision: %v", err)
+	}
+
+	if len(in.GetPath()) == 0 {
+		return fmt.Errorf("empty Path")
+	}
+
+	start, end := in.GetStartLine(), in.GetEndLine()
+	if start < 0 || end < 0 {
+		return fmt.Errorf("negative line number")
+	}
+	if start > 0 && end > 0 && end < start {
+		return fmt.Errorf("end line before start line")
+	}
+
+	return nil
+}
+
+// readBlob returns the content of the blob revision points to, or nil if
+// there is none
+func readBlob(ctx context.Context, repoPath, revision string) ([]byte, error) {
+	var content []byte
+
+	err := catfile.CatFile(ctx, repoPath, func(stdin io.Writer, stdout *bufio.Reader) error {
+		info, objectContent, err := catfile.ReadObject(stdin, stdout, revision)
+		if err != nil {
+			return err
+		}
+
+		if info.Type == "blob" {
+			content = objectContent
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+// blameOrigin is the path of the blamed file as of a commit
+type blameOrigin struct {
+	path             []byte
+	previousCommitID string
+	previousPath     []byte
+}
+
+// parseBlame parses the output of git blame -p. Each range of lines starts
+// with a header which has the number of lines of the range. Details of the
+// commit follow the header the first time the commit is seen, and its path
+// follows either when the commit is first seen or when the file had several
+// paths in the commit.
+func parseBlame(reader io.Reader) ([]*pb.BlameResponse_Group, error) {
+	var groups []*pb.BlameResponse_Group
+	groupsByID := make(map[string]*pb.BlameResponse_Group)
+	origins := make(map[string]*blameOrigin)
+
+	var group *pb.BlameResponse_Group
+	var currentRange *pb.BlameResponse_Range
+	var previousCommitID string
+	var previousPath []byte
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		// Lines of the file are prefixed with a tab, and end a range or a
+		// line header
+		if bytes.HasPrefix(line, []byte{'\t'}) {
+			continue
+		}
+
+		key, value := splitBlameHeaderLine(line)
+		commit := group.GetCommit()
+
+		// Details of a commit can only follow a line header, whose first
+		// field is a commit ID
+		if commit == nil && len(key) != 40 {
+			return nil, fmt.Errorf("unexpected line: %q", line)
+		}
+
+		switch key {
+		case "author":
+			commit.Author.Name = value
+		case "author-mail":
+			commit.Author.Email = trimEmail(value)
+		case "author-time":
+			commit.Author.Date = &timestamp.Timestamp{Seconds: git.SafeTimeUnix(string(value)).Unix()}
+		case "committer":
+			commit.Committer.Name = value
+		case "committer-mail":
+			commit.Committer.Email = trimEmail(value)
+		case "committer-time":
+			commit.Committer.Date = &timestamp.Timestamp{Seconds: git.SafeTimeUnix(string(value)).Unix()}
+		case "summary":
+			commit.Summary = value
+		case "boundary":
+			commit.Boundary = true
+		case "author-tz", "committer-tz":
+			// CommitAuthor has no time zone
+		case "previous":
+			fields := bytes.SplitN(value, []byte{' '}, 2)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid previous line: %q", line)
+			}
+
+			previousCommitID = string(fields[0])
+			previousPath = unquoteBlamePath(fields[1])
+		case "filename":
+			// The previous line, if any, comes right before the filename
+			origins[commit.Id] = &blameOrigin{path: unquoteBlamePath(value), previousCommitID: previousCommitID, previousPath: previousPath}
+			previousCommitID, previousPath = "", nil
+
+			setBlameRangeOrigin(currentRange, origins[commit.Id])
+		default:
+			header, err := parseBlameRangeHeader(line)
+			if err != nil {
+				return nil, err
+			}
+
+			// Only the header of the first line of a range has its number
+			// of lines
+			if header.lineCount == 0 {
+				continue
+			}
+
+			group = groupsByID[header.commitID]
+			if group == nil {
+				group = &pb.BlameResponse_Group{
+					Commit: &pb.BlameResponse_Commit{Id: header.commitID, Author: &pb.CommitAuthor{}, Committer: &pb.CommitAuthor{}},
+				}
+				groupsByID[header.commitID] = group
+				groups = append(groups, group)
+			}
+
+			currentRange = &pb.BlameResponse_Range{
+				FinalLine:    header.finalLine,
+				OriginalLine: header.originalLine,
+				LineCount:    header.lineCount,
+			}
+			setBlameRangeOrigin(currentRange, origins[header.commitID])
+			group.Ranges = append(group.Ranges, currentRange)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+type blameRangeHeader struct {
+	commitID     string
+	originalLine int32
+	finalLine    int32
+	lineCount    int32
+}
+
+// parseBlameRangeHeader parses a line header, which looks like
+// <commit ID> <original line> <final line> [<number of lines>]
+func parseBlameRangeHeader(line []byte) (*blameRangeHeader, error) {
+	fields := strings.Fields(string(line))
+	if len(fields) != 3 && len(fields) != 4 {
+		return nil, fmt.Errorf("invalid line header: %q", line)
+	}
+
+	numbers := make([]int32, 3)
+	for i, field := range fields[1:] {
+		number, err := strconv.ParseInt(field, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line header: %q", line)
+		}
+		numbers[i] = int32(number)
+	}
+
+	return &blameRangeHeader{commitID: fields[0], originalLine: numbers[0], finalLine: numbers[1], lineCount: numbers[2]}, nil
+}
+
+func splitBlameHeaderLine(line []byte) (string, []byte) {
+	parts := bytes.SplitN(line, []byte{' '}, 2)
+	if len(parts) == 1 {
+		return string(parts[0]), nil
+	}
+
+	return string(parts[0]), parts[1]
+}
+
+func setBlameRangeOrigin(blameRange *pb.BlameResponse_Range, origin *blameOrigin) {
+	if blameRange == nil || origin == nil {
+		return
+	}
+
+	blameRange.OriginalPath = origin.path
+	blameRange.PreviousCommitId = origin.previousCommitID
+	blameRange.PreviousPath = origin.previousPath
+}
+
+func trimEmail(value []byte) []byte {
+	return bytes.TrimSuffix(bytes.TrimPrefix(value, []byte{'<'}), []byte{'>'})
+}
+
+// unquoteBlamePath returns the path git blame printed, which is quoted like
+// a C string when it has unusual characters
+func unquoteBlamePath(path []byte) []byte {
+	if !bytes.HasPrefix(path, []byte{'"'}) {
+		return path
+	}
+
+	unquoted, err := strconv.Unquote(string(path))
+	if err != nil {
+		return path
+	}
+
+	return []byte(unquoted)
+}
+
+// sendBlameGroups sends the groups in batches, which are flushed once they
+// are larger than maxMsgSize
+func sendBlameGroups(stream pb.CommitService_BlameServer, groups []*pb.BlameResponse_Group) error {
+	var batchGroups []*pb.BlameResponse_Group
+	batch := &batchSender{send: func() error {
+		err := stream.Send(&pb.BlameResponse{Groups: batchGroups})
+		batchGroups = nil
+		return err
+	}}
+
+	for _, group := range groups {
+		batchGroups = append(batchGroups, group)
+		if err := batch.add(proto.Size(group)); err != nil {
+			return err
+		}
+	}
+
+	return batch.flush()
+}
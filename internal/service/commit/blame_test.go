@@ -0,0 +1,258 @@
+package commit
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func getBlame(t *testing.T, client pb.CommitServiceClient, request *pb.BlameRequest) ([]*pb.BlameResponse_Group, error) {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.Blame(ctx, request)
+	require.NoError(t, err)
+
+	var groups []*pb.BlameResponse_Group
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, response.GetGroups()...)
+	}
+
+	return groups, nil
+}
+
+// blameRanges formats the groups as commit IDs followed by their ranges:
+// final line, original line, number of lines and original path
+func blameRanges(groups []*pb.BlameResponse_Group) []string {
+	var ranges []string
+	for _, group := range groups {
+		for _, blameRange := range group.GetRanges() {
+			ranges = append(ranges, fmt.Sprintf("%s %d %d %d %s", group.GetCommit().GetId(),
+				blameRange.GetFinalLine(), blameRange.GetOriginalLine(), blameRange.GetLineCount(), blameRange.GetOriginalPath()))
+		}
+	}
+
+	return ranges
+}
+
+func TestSuccessfulBlame(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	// git blame only detects moved and copied lines that are long enough
+	line1 := "first line of the file, long enough to be detected\n"
+	line2 := "second line of the file, long enough to be detected\n"
+	line3 := "third line of the file, long enough to be detected\n"
+	line4 := "fourth line of the file, long enough to be detected\n"
+	changedLine1 := "first line  of the file,  long enough to be detected\n"
+	changedLine2 := "second line of the file, changed by the second commit\n"
+
+	c1 := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Add blame.txt", Parents: []string{"master"}, Files: map[string]string{"blame.txt": line1 + line2 + line3 + line4}})
+	c2 := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Change line 2", Parents: []string{c1}, Files: map[string]string{"blame.txt": line1 + changedLine2 + line3 + line4}})
+	c3 := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Change whitespace", Parents: []string{c2}, Files: map[string]string{"blame.txt": changedLine1 + changedLine2 + line3 + line4}})
+	c4 := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Ignore c2", Parents: []string{c3}, Files: map[string]string{".git-blame-ignore-revs": c2 + "\n"}})
+	c5 := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Move lines 3 and 4 to other.txt", Parents: []string{c4}, Files: map[string]string{
+		"blame.txt": changedLine1 + changedLine2,
+		"other.txt": line3 + line4,
+	}})
+	c6 := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Swap lines", Parents: []string{c5}, Files: map[string]string{"blame.txt": changedLine2 + changedLine1}})
+
+	testCases := []struct {
+		desc    string
+		request *pb.BlameRequest
+		ranges  []string
+	}{
+		{
+			desc:    "whole file",
+			request: &pb.BlameRequest{Revision: []byte(c4), Path: []byte("blame.txt")},
+			ranges: []string{
+				c3 + " 1 1 1 blame.txt",
+				c2 + " 2 2 1 blame.txt",
+				c1 + " 3 3 2 blame.txt",
+			},
+		},
+		{
+			desc:    "line range",
+			request: &pb.BlameRequest{Revision: []byte(c4), Path: []byte("blame.txt"), StartLine: 2, EndLine: 3},
+			ranges: []string{
+				c2 + " 2 2 1 blame.txt",
+				c1 + " 3 3 1 blame.txt",
+			},
+		},
+		{
+			desc:    "start line only",
+			request: &pb.BlameRequest{Revision: []byte(c4), Path: []byte("blame.txt"), StartLine: 3},
+			ranges: []string{
+				c1 + " 3 3 2 blame.txt",
+			},
+		},
+		{
+			desc:    "ignoring whitespace",
+			request: &pb.BlameRequest{Revision: []byte(c4), Path: []byte("blame.txt"), IgnoreWhitespace: true},
+			ranges: []string{
+				c1 + " 1 1 1 blame.txt",
+				c1 + " 3 3 2 blame.txt",
+				c2 + " 2 2 1 blame.txt",
+			},
+		},
+		{
+			desc:    "ignore revs file",
+			request: &pb.BlameRequest{Revision: []byte(c4), Path: []byte("blame.txt"), IgnoreRevsPath: []byte(".git-blame-ignore-revs")},
+			ranges: []string{
+				c3 + " 1 1 1 blame.txt",
+				c1 + " 2 2 1 blame.txt",
+				c1 + " 3 3 2 blame.txt",
+			},
+		},
+		{
+			desc:    "without copy detection",
+			request: &pb.BlameRequest{Revision: []byte(c5), Path: []byte("other.txt")},
+			ranges: []string{
+				c5 + " 1 1 2 other.txt",
+			},
+		},
+		{
+			desc:    "copy detection",
+			request: &pb.BlameRequest{Revision: []byte(c5), Path: []byte("other.txt"), DetectCopies: true},
+			ranges: []string{
+				c1 + " 1 3 2 blame.txt",
+			},
+		},
+		{
+			desc:    "without move detection",
+			request: &pb.BlameRequest{Revision: []byte(c6), Path: []byte("blame.txt")},
+			ranges: []string{
+				c2 + " 1 2 1 blame.txt",
+				c6 + " 2 2 1 blame.txt",
+			},
+		},
+		{
+			desc:    "move detection",
+			request: &pb.BlameRequest{Revision: []byte(c6), Path: []byte("blame.txt"), DetectMoves: true},
+			ranges: []string{
+				c2 + " 1 2 1 blame.txt",
+				c3 + " 2 1 1 blame.txt",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.request.Repository = testRepo
+
+			groups, err := getBlame(t, client, tc.request)
+			require.NoError(t, err)
+			require.Equal(t, tc.ranges, blameRanges(groups))
+		})
+	}
+}
+
+func TestBlameCommitDetails(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	parent := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Add blame.txt", Parents: []string{"master"}, Files: map[string]string{"blame.txt": "first\n"}})
+	commit := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Change blame.txt\n\nWith a body", Parents: []string{parent}, Files: map[string]string{"blame.txt": "first\nsecond\n"}})
+
+	groups, err := getBlame(t, client, &pb.BlameRequest{Repository: testRepo, Revision: []byte(commit), Path: []byte("blame.txt")})
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+
+	blamed := groups[1]
+	require.Equal(t, commit, blamed.GetCommit().GetId())
+	require.Equal(t, "Change blame.txt", string(blamed.GetCommit().GetSummary()))
+	require.Equal(t, "Scrooge McDuck", string(blamed.GetCommit().GetAuthor().GetName()))
+	require.Equal(t, "scrooge@mcduck.com", string(blamed.GetCommit().GetAuthor().GetEmail()))
+	require.NotNil(t, blamed.GetCommit().GetAuthor().GetDate())
+	require.Equal(t, "Scrooge McDuck", string(blamed.GetCommit().GetCommitter().GetName()))
+	require.Equal(t, "scrooge@mcduck.com", string(blamed.GetCommit().GetCommitter().GetEmail()))
+	require.False(t, blamed.GetCommit().GetBoundary())
+
+	require.Len(t, blamed.GetRanges(), 1)
+	require.Equal(t, parent, blamed.GetRanges()[0].GetPreviousCommitId())
+	require.Equal(t, "blame.txt", string(blamed.GetRanges()[0].GetPreviousPath()))
+}
+
+func TestFailedBlame(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testCases := []struct {
+		desc    string
+		request *pb.BlameRequest
+	}{
+		{
+			desc:    "invalid repository",
+			request: &pb.BlameRequest{Repository: &pb.Repository{StorageName: "fake", RelativePath: "path"}, Revision: []byte("master"), Path: []byte("README.md")},
+		},
+		{
+			desc:    "empty revision",
+			request: &pb.BlameRequest{Repository: testRepo, Path: []byte("README.md")},
+		},
+		{
+			desc:    "revision starting with -",
+			request: &pb.BlameRequest{Repository: testRepo, Revision: []byte("--all"), Path: []byte("README.md")},
+		},
+		{
+			desc:    "empty path",
+			request: &pb.BlameRequest{Repository: testRepo, Revision: []byte("master")},
+		},
+		{
+			desc:    "missing path",
+			request: &pb.BlameRequest{Repository: testRepo, Revision: []byte("master"), Path: []byte("does-not-exist")},
+		},
+		{
+			desc:    "negative line",
+			request: &pb.BlameRequest{Repository: testRepo, Revision: []byte("master"), Path: []byte("README.md"), StartLine: -1},
+		},
+		{
+			desc:    "end line before start line",
+			request: &pb.BlameRequest{Repository: testRepo, Revision: []byte("master"), Path: []byte("README.md"), StartLine: 2, EndLine: 1},
+		},
+		{
+			desc:    "start line after the end of the file",
+			request: &pb.BlameRequest{Repository: testRepo, Revision: []byte("master"), Path: []byte("README.md"), StartLine: 100000},
+		},
+		{
+			desc:    "missing ignore revs file",
+			request: &pb.BlameRequest{Repository: testRepo, Revision: []byte("master"), Path: []byte("README.md"), IgnoreRevsPath: []byte("does-not-exist")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := getBlame(t, client, tc.request)
+			testhelper.AssertGrpcError(t, err, codes.InvalidArgument, "")
+		})
+	}
+}
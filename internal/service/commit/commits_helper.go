@@ -7,6 +7,7 @@ import (
 
 	pb "gitlab.com/gitlab-org/gitaly-proto/go"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 )
 
@@ -23,31 +24,38 @@ func sendCommits(ctx context.Context, sender commitsSender, repo *pb.Repository,
 	logParser := log.NewLogParser(cmd)
 
 	var commits []*pb.GitCommit
-	commitsSize := 0
+	sent := false
+	batch := &batchSender{send: func() error {
+		err := sender.Send(commits)
+		commits = nil
+		sent = true
+		return err
+	}}
 
 	for logParser.Parse() {
 		commit := logParser.Commit()
-		commitsSize += commitSize(commit)
-
-		if commitsSize >= maxMsgSize {
-			if err := sender.Send(commits); err != nil {
-				return err
-			}
-			commits = nil
-			commitsSize = 0
-		}
 
 		commits = append(commits, commit)
+		if err := batch.add(proto.Size(commit)); err != nil {
+			return err
+		}
 	}
 
 	if err := logParser.Err(); err != nil {
 		return err
 	}
 
-	if err := sender.Send(commits); err != nil {
+	if err := batch.flush(); err != nil {
 		return err
 	}
 
+	// The callers always get a message, even for an empty range
+	if !sent {
+		if err := sender.Send(commits); err != nil {
+			return err
+		}
+	}
+
 	if err := cmd.Wait(); err != nil {
 		// We expect this error to be caused by non-existing references. In that
 		// case, we just log the error and send no commits to the `sender`.
@@ -57,9 +65,36 @@ func sendCommits(ctx context.Context, sender commitsSender, repo *pb.Repository,
 	return nil
 }
 
-func commitSize(commit *pb.GitCommit) int {
-	return len(commit.Id) + len(commit.Subject) + len(commit.Body) +
-		len(commit.Author.Name) + len(commit.Author.Email) + len(commit.Committer.Name) + len(commit.Committer.Email) +
-		8 + 8 + // Author and Committer timestamps are int64
-		len(commit.ParentIds)*40
+// batchSender sends the items of a stream in batches, which are sent once
+// they are larger than maxMsgSize. The caller keeps the items of the
+// current batch, and sends and clears them in send.
+type batchSender struct {
+	send  func() error
+	items int
+	size  int
+}
+
+// add counts an item of the given size in the batch, and sends the batch
+// if it is full
+func (b *batchSender) add(size int) error {
+	b.items++
+	b.size += size
+
+	if b.size > maxMsgSize {
+		return b.flush()
+	}
+
+	return nil
+}
+
+// flush sends the batch, unless it is empty
+func (b *batchSender) flush() error {
+	if b.items == 0 {
+		return nil
+	}
+
+	b.items = 0
+	b.size = 0
+
+	return b.send()
 }
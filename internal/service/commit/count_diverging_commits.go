@@ -0,0 +1,160 @@
+package commit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// CountDivergingCommits streams how many commits each revision is ahead
+// and behind the base revision. Revisions that don't exist are skipped.
+func (s *server) CountDivergingCommits(in *pb.CountDivergingCommitsRequest, stream pb.CommitService_CountDivergingCommitsServer) error {
+	if err := validateCountDivergingCommitsRequest(in); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "CountDivergingCommits: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+
+	baseID, err := git.ResolveCommit(ctx, repoPath, string(in.GetBase()))
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "CountDivergingCommits: %v", err)
+	}
+	if baseID == "" {
+		return grpc.Errorf(codes.InvalidArgument, "CountDivergingCommits: base not found: %s", in.GetBase())
+	}
+
+	for _, revision := range in.GetRevisions() {
+		revisionID, err := git.ResolveCommit(ctx, repoPath, string(revision))
+		if err != nil {
+			return grpc.Errorf(codes.Internal, "CountDivergingCommits: %v", err)
+		}
+		if revisionID == "" {
+			continue
+		}
+
+		response, err := countDivergingCommits(ctx, repoPath, baseID, revisionID, in.GetMaxCount())
+		if err != nil {
+			return err
+		}
+		response.Revision = revision
+
+		if err := stream.Send(response); err != nil {
+			return grpc.Errorf(codes.Unavailable, "CountDivergingCommits: send: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// countDivergingCommits counts the commits revisionID is ahead and behind
+// baseID. Without maxCount, both sides are counted by a single rev-list.
+//
+// rev-list --max-count would cap the ahead and behind counts combined, so
+// with maxCount each side is counted by its own rev-list, which stops one
+// commit after maxCount so that we know if there were more.
+func countDivergingCommits(ctx context.Context, repoPath, baseID, revisionID string, maxCount int32) (*pb.CountDivergingCommitsResponse, error) {
+	if maxCount == 0 {
+		// The left side is base, so it has the commits revision is behind by
+		counts, err := revListCount(ctx, repoPath, "--left-right", fmt.Sprintf("%s...%s", baseID, revisionID))
+		if err != nil {
+			return nil, err
+		}
+		if len(counts) != 2 {
+			return nil, grpc.Errorf(codes.Internal, "CountDivergingCommits: invalid rev-list output: %v", counts)
+		}
+
+		return &pb.CountDivergingCommitsResponse{AheadCount: counts[1], BehindCount: counts[0]}, nil
+	}
+
+	maxCountArg := fmt.Sprintf("--max-count=%d", int64(maxCount)+1)
+
+	ahead, err := revListCount(ctx, repoPath, maxCountArg, fmt.Sprintf("%s..%s", baseID, revisionID))
+	if err != nil {
+		return nil, err
+	}
+
+	behind, err := revListCount(ctx, repoPath, maxCountArg, fmt.Sprintf("%s..%s", revisionID, baseID))
+	if err != nil {
+		return nil, err
+	}
+
+	response := &pb.CountDivergingCommitsResponse{AheadCount: ahead[0], BehindCount: behind[0]}
+	if response.AheadCount > maxCount {
+		response.AheadCount = maxCount
+		response.MaxCountExceeded = true
+	}
+	if response.BehindCount > maxCount {
+		response.BehindCount = maxCount
+		response.MaxCountExceeded = true
+	}
+
+	return response, nil
+}
+
+// revListCount runs rev-list --count with args and returns the counts it
+// printed. Both revisions of the range must exist.
+func revListCount(ctx context.Context, repoPath string, args ...string) ([]int32, error) {
+	cmdArgs := append([]string{"--git-dir", repoPath, "rev-list", "--count"}, args...)
+	cmd, err := command.Git(ctx, append(cmdArgs, "--")...)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "CountDivergingCommits: cmd: %v", err)
+	}
+
+	output, err := ioutil.ReadAll(cmd)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "CountDivergingCommits: read: %v", err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, grpc.Errorf(codes.Internal, "CountDivergingCommits: rev-list: %v", err)
+	}
+
+	var counts []int32
+	for _, field := range strings.Fields(string(output)) {
+		count, err := strconv.ParseInt(field, 10, 32)
+		if err != nil {
+			return nil, grpc.Errorf(codes.Internal, "CountDivergingCommits: parse count: %v", err)
+		}
+		counts = append(counts, int32(count))
+	}
+
+	if len(counts) == 0 {
+		return nil, grpc.Errorf(codes.Internal, "CountDivergingCommits: empty rev-list output")
+	}
+
+	return counts, nil
+}
+
+func validateCountDivergingCommitsRequest(in *pb.CountDivergingCommitsRequest) error {
+	if err := git.ValidateRevision(in.GetBase()); err != nil {
+		return fmt.Errorf("base: %v", err)
+	}
+
+	for _, revision := range in.GetRevisions() {
+		if err := git.ValidateRevision(revision); err != nil {
+			return fmt.Errorf("revision: %v", err)
+		}
+	}
+
+	if in.GetMaxCount() < 0 {
+		return fmt.Errorf("negative MaxCount")
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package commit
+
+import (
+	"io"
+	"testing"
+
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func getDivergingCommitCounts(t *testing.T, client pb.CommitServiceClient, request *pb.CountDivergingCommitsRequest) ([]*pb.CountDivergingCommitsResponse, error) {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.CountDivergingCommits(ctx, request)
+	require.NoError(t, err)
+
+	var responses []*pb.CountDivergingCommitsResponse
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		responses = append(responses, response)
+	}
+
+	return responses, nil
+}
+
+func TestSuccessfulCountDivergingCommitsRequest(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ahead := "master"
+	for i := 0; i < 2; i++ {
+		ahead = testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Parents: []string{ahead}})
+	}
+	diverged := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Parents: []string{"master~1"}})
+
+	request := &pb.CountDivergingCommitsRequest{
+		Repository: testRepo,
+		Base:       []byte("master"),
+		// Missing revisions are skipped
+		Revisions: [][]byte{[]byte(ahead), []byte("master~2"), []byte("does-not-exist"), []byte(diverged), []byte("master")},
+	}
+
+	responses, err := getDivergingCommitCounts(t, client, request)
+	require.NoError(t, err)
+
+	expected := []*pb.CountDivergingCommitsResponse{
+		{Revision: []byte(ahead), AheadCount: 2},
+		{Revision: []byte("master~2"), BehindCount: 2},
+		{Revision: []byte(diverged), AheadCount: 1, BehindCount: 1},
+		{Revision: []byte("master")},
+	}
+	require.Equal(t, expected, responses)
+}
+
+func TestCountDivergingCommitsMaxCount(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ahead := "master"
+	for i := 0; i < 3; i++ {
+		ahead = testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Parents: []string{ahead}})
+	}
+	// master is one of the commits ahead of diverged
+	diverged := "master~1"
+	for i := 0; i < 2; i++ {
+		diverged = testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Parents: []string{diverged}})
+	}
+
+	testCases := []struct {
+		desc     string
+		base     string
+		maxCount int32
+		expected *pb.CountDivergingCommitsResponse
+	}{
+		{
+			desc:     "below the limit",
+			base:     "master",
+			maxCount: 3,
+			expected: &pb.CountDivergingCommitsResponse{Revision: []byte(ahead), AheadCount: 3},
+		},
+		{
+			desc:     "above the limit",
+			base:     "master",
+			maxCount: 2,
+			expected: &pb.CountDivergingCommitsResponse{Revision: []byte(ahead), AheadCount: 2, MaxCountExceeded: true},
+		},
+		{
+			desc:     "both sides below the limit",
+			base:     diverged,
+			maxCount: 4,
+			expected: &pb.CountDivergingCommitsResponse{Revision: []byte(ahead), AheadCount: 4, BehindCount: 2},
+		},
+		{
+			desc:     "one side above the limit",
+			base:     diverged,
+			maxCount: 3,
+			expected: &pb.CountDivergingCommitsResponse{Revision: []byte(ahead), AheadCount: 3, BehindCount: 2, MaxCountExceeded: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			request := &pb.CountDivergingCommitsRequest{
+				Repository: testRepo,
+				Base:       []byte(tc.base),
+				Revisions:  [][]byte{[]byte(ahead)},
+				MaxCount:   tc.maxCount,
+			}
+
+			responses, err := getDivergingCommitCounts(t, client, request)
+			require.NoError(t, err)
+			require.Equal(t, []*pb.CountDivergingCommitsResponse{tc.expected}, responses)
+		})
+	}
+}
+
+func TestFailedCountDivergingCommitsRequest(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testCases := []struct {
+		desc    string
+		request *pb.CountDivergingCommitsRequest
+	}{
+		{
+			desc:    "empty base",
+			request: &pb.CountDivergingCommitsRequest{Repository: testRepo, Revisions: [][]byte{[]byte("master")}},
+		},
+		{
+			desc:    "revision starting with -",
+			request: &pb.CountDivergingCommitsRequest{Repository: testRepo, Base: []byte("master"), Revisions: [][]byte{[]byte("--all")}},
+		},
+		{
+			desc:    "negative max count",
+			request: &pb.CountDivergingCommitsRequest{Repository: testRepo, Base: []byte("master"), MaxCount: -1},
+		},
+		{
+			desc:    "missing base",
+			request: &pb.CountDivergingCommitsRequest{Repository: testRepo, Base: []byte("does-not-exist"), Revisions: [][]byte{[]byte("master")}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := getDivergingCommitCounts(t, client, tc.request)
+			testhelper.AssertGrpcError(t, err, codes.InvalidArgument, "")
+		})
+	}
+}
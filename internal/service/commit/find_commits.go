@@ -1,8 +1,10 @@
 package commit
 
 import (
+	"fmt"
+
+	"gitlab.com/gitlab-org/gitaly/internal/git"
 	"gitlab.com/gitlab-org/gitaly/internal/helper"
-	"gitlab.com/gitlab-org/gitaly/internal/rubyserver"
 
 	pb "gitlab.com/gitlab-org/gitaly-proto/go"
 
@@ -10,11 +12,13 @@ import (
 	"google.golang.org/grpc/codes"
 )
 
+type findCommitsSender struct {
+	stream pb.CommitService_FindCommitsServer
+}
+
 func (s *server) FindCommits(req *pb.FindCommitsRequest, stream pb.CommitService_FindCommitsServer) error {
 	ctx := stream.Context()
 
-	// Use Gitaly's default branch lookup function because that is already
-	// migrated.
 	if revision := req.Revision; len(revision) == 0 {
 		repoPath, err := helper.GetRepoPath(req.Repository)
 		if err != nil {
@@ -27,26 +31,110 @@ func (s *server) FindCommits(req *pb.FindCommitsRequest, stream pb.CommitService
 		}
 	}
 
-	client, err := s.CommitServiceClient(ctx)
-	if err != nil {
-		return err
+	if err := git.ValidateRevision(req.GetRevision()); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "FindCommits: revision: %v", err)
 	}
 
-	clientCtx, err := rubyserver.SetHeaders(ctx, req.GetRepository())
-	if err != nil {
-		return err
+	if req.GetFollow() && len(req.GetPaths()) != 1 {
+		return grpc.Errorf(codes.InvalidArgument, "FindCommits: Follow requires exactly one path")
 	}
 
-	rubyStream, err := client.FindCommits(clientCtx, req)
-	if err != nil {
-		return err
+	// gitlab_git treated a limit of 0 as no limit when it walked the history
+	// itself, but passed it on to git log when one of these options was set
+	if req.GetLimit() == 0 && (len(req.GetPaths()) > 0 || req.GetDisableWalk() || req.GetSkipMerges() || req.GetAfter() != nil || req.GetBefore() != nil) {
+		return nil
 	}
 
-	return rubyserver.Proxy(func() error {
-		resp, err := rubyStream.Recv()
-		if err != nil {
-			return err
+	var sender commitsSender = &findCommitsSender{stream}
+	limit, offset := int(req.GetLimit()), int(req.GetOffset())
+	var args []string
+
+	// --skip doesn't play well with git log --follow, so the commits are
+	// skipped as they are sent
+	if req.GetFollow() {
+		args = append(args, "--follow")
+
+		if limit > 0 {
+			limit += offset
 		}
-		return stream.Send(resp)
-	})
+		sender = &skippingCommitsSender{sender: sender, skip: offset}
+	} else if offset > 0 {
+		args = append(args, fmt.Sprintf("--skip=%d", offset))
+	}
+
+	if limit > 0 {
+		args = append(args, fmt.Sprintf("--max-count=%d", limit))
+	}
+
+	var paths []string
+	for _, path := range req.GetPaths() {
+		paths = append(paths, string(path))
+	}
+
+	args = append(args, findCommitsFilterArgs(req)...)
+	if err := sendCommits(ctx, sender, req.GetRepository(), []string{string(req.GetRevision())}, paths, args...); err != nil {
+		return grpc.Errorf(codes.Internal, "FindCommits: %v", err)
+	}
+
+	return nil
+}
+
+func findCommitsFilterArgs(req *pb.FindCommitsRequest) []string {
+	var args []string
+
+	if req.GetSkipMerges() {
+		args = append(args, "--no-merges")
+	}
+	if req.GetFirstParent() {
+		args = append(args, "--first-parent")
+	}
+
+	if after := req.GetAfter(); after != nil {
+		args = append(args, "--after="+timestampToRFC3339(after.Seconds))
+	}
+	if before := req.GetBefore(); before != nil {
+		args = append(args, "--before="+timestampToRFC3339(before.Seconds))
+	}
+
+	if author := req.GetAuthor(); len(author) > 0 {
+		args = append(args, "--author="+string(author))
+	}
+	if committer := req.GetCommitter(); len(committer) > 0 {
+		args = append(args, "--committer="+string(committer))
+	}
+	if messageRegex := req.GetMessageRegex(); len(messageRegex) > 0 {
+		args = append(args, "--grep="+string(messageRegex))
+	}
+
+	switch req.GetOrder() {
+	case pb.FindCommitsRequest_TOPO:
+		args = append(args, "--topo-order")
+	case pb.FindCommitsRequest_DATE:
+		args = append(args, "--date-order")
+	}
+
+	return args
+}
+
+func (sender *findCommitsSender) Send(commits []*pb.GitCommit) error {
+	return sender.stream.Send(&pb.FindCommitsResponse{Commits: commits})
+}
+
+// skippingCommitsSender drops the first commits it is given, and sends
+// nothing until there are commits left
+type skippingCommitsSender struct {
+	sender commitsSender
+	skip   int
+}
+
+func (sender *skippingCommitsSender) Send(commits []*pb.GitCommit) error {
+	if sender.skip >= len(commits) {
+		sender.skip -= len(commits)
+		return nil
+	}
+
+	commits = commits[sender.skip:]
+	sender.skip = 0
+
+	return sender.sender.Send(commits)
 }
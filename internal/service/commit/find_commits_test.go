@@ -3,13 +3,16 @@ package commit
 import (
 	"context"
 	"io"
+	"strings"
 	"testing"
+	"time"
 
 	pb "gitlab.com/gitlab-org/gitaly-proto/go"
 	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
 
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
 )
 
 func TestFindCommitsFields(t *testing.T) {
@@ -183,6 +186,18 @@ func TestSuccessfulFindCommitsRequest(t *testing.T) {
 				"913c66a37b4a45b9769037c55c2d238bd0942d2e",
 			},
 		},
+		{
+			desc: "following renames with offset",
+			request: &pb.FindCommitsRequest{
+				Repository: testRepo,
+				Revision:   []byte("94bb47ca1297b7b3731ff2a36923640991e9236f"),
+				Paths:      [][]byte{[]byte("CHANGELOG.md")},
+				Follow:     true,
+				Offset:     1,
+				Limit:      1,
+			},
+			ids: []string{"5f923865dde3436854e9ceb9cdb7815618d4e849"},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -215,3 +230,160 @@ func TestSuccessfulFindCommitsRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestFindCommitsFilters(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	// All these commits are older than master, so that the orders differ
+	a1 := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "a1", Parents: []string{"master"}, AuthorName: "Donald Duck", Date: time.Unix(1400000100, 0)})
+	b1 := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "b1", Parents: []string{"master"}, AuthorName: "Daisy Duck", Date: time.Unix(1400000200, 0)})
+	a2 := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "a2 fixes a bug", Parents: []string{a1}, AuthorName: "Donald Duck", Date: time.Unix(1400000300, 0)})
+	b2 := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "b2", Parents: []string{b1}, AuthorName: "Daisy Duck", Date: time.Unix(1400000400, 0)})
+	merge := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Merge b2", Parents: []string{a2, b2}, AuthorName: "Donald Duck", Date: time.Unix(1400000500, 0)})
+	master := strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "rev-parse", "master")))
+
+	testCases := []struct {
+		desc    string
+		request *pb.FindCommitsRequest
+		ids     []string
+	}{
+		{
+			desc:    "default order",
+			request: &pb.FindCommitsRequest{Limit: 5},
+			ids:     []string{merge, b2, a2, b1, master},
+		},
+		{
+			desc:    "date order",
+			request: &pb.FindCommitsRequest{Limit: 6, Order: pb.FindCommitsRequest_DATE},
+			ids:     []string{merge, b2, a2, b1, a1, master},
+		},
+		{
+			desc:    "topological order",
+			request: &pb.FindCommitsRequest{Limit: 6, Order: pb.FindCommitsRequest_TOPO},
+			ids:     []string{merge, b2, b1, a2, a1, master},
+		},
+		{
+			desc:    "offset",
+			request: &pb.FindCommitsRequest{Limit: 2, Offset: 1},
+			ids:     []string{b2, a2},
+		},
+		{
+			desc:    "author",
+			request: &pb.FindCommitsRequest{Limit: 10, Author: []byte("Donald")},
+			ids:     []string{merge, a2, a1},
+		},
+		{
+			desc:    "committer",
+			request: &pb.FindCommitsRequest{Limit: 10, Committer: []byte("scrooge@mcduck.com")},
+			ids:     []string{merge, b2, a2, b1, a1},
+		},
+		{
+			desc:    "message regex",
+			request: &pb.FindCommitsRequest{Limit: 10, MessageRegex: []byte("fixes .* bug")},
+			ids:     []string{a2},
+		},
+		{
+			desc:    "first parent",
+			request: &pb.FindCommitsRequest{Limit: 3, FirstParent: true},
+			ids:     []string{merge, a2, a1},
+		},
+		{
+			desc:    "first parent and author",
+			request: &pb.FindCommitsRequest{Limit: 10, FirstParent: true, Author: []byte("Daisy")},
+			ids:     nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			tc.request.Repository = testRepo
+			tc.request.Revision = []byte(merge)
+
+			stream, err := client.FindCommits(ctx, tc.request)
+			require.NoError(t, err)
+
+			var ids []string
+			for err == nil {
+				var resp *pb.FindCommitsResponse
+				resp, err = stream.Recv()
+				for _, c := range resp.GetCommits() {
+					ids = append(ids, c.Id)
+				}
+			}
+			require.Equal(t, io.EOF, err)
+
+			require.Equal(t, tc.ids, ids)
+		})
+	}
+}
+
+func TestFailedFindCommitsRequest(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testCases := []struct {
+		desc    string
+		request *pb.FindCommitsRequest
+	}{
+		{
+			desc:    "revision starting with -",
+			request: &pb.FindCommitsRequest{Repository: testRepo, Revision: []byte("--all")},
+		},
+		{
+			desc:    "follow without a path",
+			request: &pb.FindCommitsRequest{Repository: testRepo, Revision: []byte("master"), Follow: true, Limit: 10},
+		},
+		{
+			desc:    "follow with several paths",
+			request: &pb.FindCommitsRequest{Repository: testRepo, Revision: []byte("master"), Paths: [][]byte{[]byte("README.md"), []byte("CHANGELOG")}, Follow: true, Limit: 10},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			stream, err := client.FindCommits(ctx, tc.request)
+			require.NoError(t, err)
+
+			_, err = stream.Recv()
+			testhelper.AssertGrpcError(t, err, codes.InvalidArgument, "")
+		})
+	}
+}
+
+type recordingCommitsSender struct {
+	batches [][]*pb.GitCommit
+}
+
+func (sender *recordingCommitsSender) Send(commits []*pb.GitCommit) error {
+	sender.batches = append(sender.batches, commits)
+	return nil
+}
+
+func TestSkippingCommitsSender(t *testing.T) {
+	commits := []*pb.GitCommit{{Id: "1"}, {Id: "2"}, {Id: "3"}}
+	recorder := &recordingCommitsSender{}
+	sender := &skippingCommitsSender{sender: recorder, skip: 4}
+
+	require.NoError(t, sender.Send(commits[:2]))
+	require.NoError(t, sender.Send(commits[2:]))
+	require.Empty(t, recorder.batches, "nothing must be sent while commits are skipped")
+
+	require.NoError(t, sender.Send(commits))
+	require.Equal(t, [][]*pb.GitCommit{commits[1:]}, recorder.batches)
+}
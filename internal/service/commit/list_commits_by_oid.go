@@ -0,0 +1,88 @@
+package commit
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitaly/internal/git/catfile"
+	"gitlab.com/gitlab-org/gitaly/internal/git/log"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+type commitsByOidSender struct {
+	stream pb.CommitService_ListCommitsByOidServer
+}
+
+// ListCommitsByOid streams the commits with the given IDs, which are all
+// read by a single cat-file process. IDs that don't resolve to a commit are
+// skipped.
+func (s *server) ListCommitsByOid(in *pb.ListCommitsByOidRequest, stream pb.CommitService_ListCommitsByOidServer) error {
+	for _, oid := range in.GetOid() {
+		// cat-file reads one object name per line
+		if strings.Contains(oid, "\n") {
+			return grpc.Errorf(codes.InvalidArgument, "ListCommitsByOid: invalid oid: %q", oid)
+		}
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return err
+	}
+
+	sender := &commitsByOidSender{stream}
+
+	err = catfile.CatFile(stream.Context(), repoPath, func(stdin io.Writer, stdout *bufio.Reader) error {
+		return sendCommitsByOid(sender, stdin, stdout, in.GetOid())
+	})
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "ListCommitsByOid: %v", err)
+	}
+
+	return nil
+}
+
+// sendCommitsByOid sends the commits in batches, which are flushed once they
+// are larger than maxMsgSize
+func sendCommitsByOid(sender commitsSender, stdin io.Writer, stdout *bufio.Reader, oids []string) error {
+	var commits []*pb.GitCommit
+	batch := &batchSender{send: func() error {
+		err := sender.Send(commits)
+		commits = nil
+		return err
+	}}
+
+	for _, oid := range oids {
+		info, content, err := catfile.ReadObject(stdin, stdout, oid+"^{commit}")
+		if err != nil {
+			return err
+		}
+
+		if info.Oid == "" {
+			continue
+		}
+
+		commit, err := log.ParseRawCommit(info.Oid, content)
+		if err != nil {
+			return err
+		}
+		commit.Trailers = log.ParseCommitTrailers(commit.Body)
+
+		commits = append(commits, commit)
+		if err := batch.add(proto.Size(commit)); err != nil {
+			return err
+		}
+	}
+
+	return batch.flush()
+}
+
+func (sender *commitsByOidSender) Send(commits []*pb.GitCommit) error {
+	return sender.stream.Send(&pb.ListCommitsByOidResponse{Commits: commits})
+}
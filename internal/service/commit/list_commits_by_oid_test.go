@@ -0,0 +1,135 @@
+package commit
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"gitlab.com/gitlab-org/gitaly/internal/git/log"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func listCommitsByOid(t *testing.T, client pb.CommitServiceClient, request *pb.ListCommitsByOidRequest) ([]*pb.ListCommitsByOidResponse, error) {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.ListCommitsByOid(ctx, request)
+	require.NoError(t, err)
+
+	var responses []*pb.ListCommitsByOidResponse
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		responses = append(responses, response)
+	}
+
+	return responses, nil
+}
+
+func TestSuccessfulListCommitsByOidRequest(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	withTrailers := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Add trailers\n\nA body\n\nSigned-off-by: Scrooge McDuck <scrooge@mcduck.com>\nHelped-by: Donald Duck\n  and his nephews\n", Parents: []string{"master"}})
+	notTrailers := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Not trailers\n\nThe last paragraph\nSigned-off-by: Scrooge McDuck <scrooge@mcduck.com>\n", Parents: []string{"master"}})
+	subjectOnly := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Fixes: the subject isn't a trailer\n", Parents: []string{"master"}})
+	masterID := strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "rev-parse", "master")))
+	blobID := strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "rev-parse", "master:README.md")))
+
+	expected := []*pb.GitCommit{}
+	for _, id := range []string{withTrailers, notTrailers, subjectOnly, masterID} {
+		commit, err := log.GetCommit(ctx, testRepo, id, "")
+		require.NoError(t, err)
+		expected = append(expected, commit)
+	}
+	expected[0].Trailers = []*pb.CommitTrailer{
+		{Key: []byte("Signed-off-by"), Value: []byte("Scrooge McDuck <scrooge@mcduck.com>")},
+		{Key: []byte("Helped-by"), Value: []byte("Donald Duck and his nephews")},
+	}
+
+	request := &pb.ListCommitsByOidRequest{
+		Repository: testRepo,
+		// Missing objects and objects that aren't commits are skipped
+		Oid: []string{withTrailers, strings.Repeat("1", 40), notTrailers, blobID, subjectOnly, "master"},
+	}
+
+	responses, err := listCommitsByOid(t, client, request)
+	require.NoError(t, err)
+
+	var commits []*pb.GitCommit
+	for _, response := range responses {
+		commits = append(commits, response.GetCommits()...)
+	}
+	require.Equal(t, expected, commits)
+}
+
+func TestListCommitsByOidBatches(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	defer func(oldMaxMsgSize int) {
+		maxMsgSize = oldMaxMsgSize
+	}(maxMsgSize)
+	maxMsgSize = 1
+
+	request := &pb.ListCommitsByOidRequest{Repository: testRepo, Oid: []string{"master", "master~1", "master~2"}}
+	responses, err := listCommitsByOid(t, client, request)
+	require.NoError(t, err)
+
+	require.Len(t, responses, 3)
+	for _, response := range responses {
+		require.Len(t, response.GetCommits(), 1)
+	}
+}
+
+func TestFailedListCommitsByOidRequest(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testCases := []struct {
+		desc    string
+		request *pb.ListCommitsByOidRequest
+	}{
+		{
+			desc:    "invalid repository",
+			request: &pb.ListCommitsByOidRequest{Repository: &pb.Repository{StorageName: "fake", RelativePath: "repo"}},
+		},
+		{
+			desc:    "oid with a newline",
+			request: &pb.ListCommitsByOidRequest{Repository: testRepo, Oid: []string{"master\nmaster~1"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := listCommitsByOid(t, client, tc.request)
+			testhelper.AssertGrpcError(t, err, codes.InvalidArgument, "")
+		})
+	}
+}
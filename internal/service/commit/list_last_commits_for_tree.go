@@ -0,0 +1,554 @@
+package commit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/catfile"
+	"gitlab.com/gitlab-org/gitaly/internal/git/log"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Maximum number of paths whose last commit is cached, over all commits
+const lastCommitsCacheMaxPaths = 100000
+
+// The last commit of a path as of a given commit never changes, so the
+// results are cached by repository and commit ID
+var lastCommitsCache = struct {
+	sync.Mutex
+	entries map[lastCommitsCacheKey]map[string]*pb.GitCommit
+	// The number of paths in all the entries
+	paths int
+}{entries: make(map[lastCommitsCacheKey]map[string]*pb.GitCommit)}
+
+type lastCommitsCacheKey struct {
+	repoPath string
+	commitID string
+}
+
+type commitForTree struct {
+	path   string
+	commit *pb.GitCommit
+}
+
+// ListLastCommitsForTree streams the last commit of each entry of a
+// directory, in the order of the tree. The history is walked by a single git
+// log for all the entries of the requested page.
+func (s *server) ListLastCommitsForTree(in *pb.ListLastCommitsForTreeRequest, stream pb.CommitService_ListLastCommitsForTreeServer) error {
+	if err := validateListLastCommitsForTreeRequest(in); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "ListLastCommitsForTree: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+
+	commitID, err := resolveCommitID(ctx, repoPath, in.GetRevision())
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "ListLastCommitsForTree: %v", err)
+	}
+	if commitID == "" {
+		return grpc.Errorf(codes.InvalidArgument, "ListLastCommitsForTree: revision not found: %q", in.GetRevision())
+	}
+
+	dir := strings.Trim(string(in.GetPath()), "/")
+
+	paths, err := treeEntryPaths(ctx, repoPath, commitID, dir)
+	if err != nil {
+		if _, ok := command.ExitStatus(err); ok {
+			return grpc.Errorf(codes.InvalidArgument, "ListLastCommitsForTree: not a directory: %q", dir)
+		}
+		return grpc.Errorf(codes.Internal, "ListLastCommitsForTree: %v", err)
+	}
+
+	paths = paginatePaths(paths, int(in.GetOffset()), int(in.GetLimit()))
+
+	commits, err := lastCommitsForPaths(ctx, repoPath, commitID, dir, paths)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "ListLastCommitsForTree: %v", err)
+	}
+
+	var entries []commitForTree
+	for _, path := range paths {
+		// Paths that can't be found in the history are skipped
+		if commit := commits[path]; commit != nil {
+			entries = append(entries, commitForTree{path: path, commit: commit})
+		}
+	}
+
+	return sendCommitsForTree(stream, entries)
+}
+
+func validateListLastCommitsForTreeRequest(in *pb.ListLastCommitsForTreeRequest) error {
+	if err := git.ValidateRevision([]byte(in.GetRevision())); err != nil {
+		return fmt.Errorf("revision: %v", err)
+	}
+	if in.GetOffset() < 0 {
+		return fmt.Errorf("negative offset")
+	}
+	if in.GetLimit() < 0 {
+		return fmt.Errorf("negative limit")
+	}
+
+	return nil
+}
+
+// resolveCommitID returns the ID of the commit revision points to, or an
+// empty string if there is none
+func resolveCommitID(ctx context.Context, repoPath, revision string) (string, error) {
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+
+	output, err := ioutil.ReadAll(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := command.ExitStatus(err); ok {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// treeEntryPaths returns the paths of the entries of dir, relative to the
+// root of the repository
+func treeEntryPaths(ctx context.Context, repoPath, commitID, dir string) ([]string, error) {
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "ls-tree", "-z", commitID+":"+dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	reader := bufio.NewReader(cmd)
+	for {
+		line, err := reader.ReadBytes('\x00')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		// Each line is "<mode> <type> <oid>\t<name>"
+		if tab := bytes.IndexByte(line, '\t'); tab >= 0 {
+			paths = append(paths, path.Join(dir, string(bytes.TrimSuffix(line[tab+1:], []byte{'\x00'}))))
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+func paginatePaths(paths []string, offset, limit int) []string {
+	if offset >= len(paths) {
+		return nil
+	}
+	paths = paths[offset:]
+
+	if limit > 0 && limit < len(paths) {
+		paths = paths[:limit]
+	}
+
+	return paths
+}
+
+// lastCommitsForPaths returns the last commit of each path of dir as of
+// commitID, from the cache or from the history
+func lastCommitsForPaths(ctx context.Context, repoPath, commitID, dir string, paths []string) (map[string]*pb.GitCommit, error) {
+	key := lastCommitsCacheKey{repoPath: repoPath, commitID: commitID}
+
+	commits := make(map[string]*pb.GitCommit)
+	var missingPaths []string
+
+	lastCommitsCache.Lock()
+	cached := lastCommitsCache.entries[key]
+	for _, path := range paths {
+		if commit := cached[path]; commit != nil {
+			commits[path] = commit
+		} else {
+			missingPaths = append(missingPaths, path)
+		}
+	}
+	lastCommitsCache.Unlock()
+
+	if len(missingPaths) == 0 {
+		return commits, nil
+	}
+
+	var found map[string]*pb.GitCommit
+	err := catfile.CatFile(ctx, repoPath, func(stdin io.Writer, stdout *bufio.Reader) error {
+		walk := &lastCommitsWalk{stdin: stdin, stdout: stdout, dir: dir, entries: make(map[string]map[string]string)}
+
+		commitIDs, err := walk.lastCommitIDs(ctx, repoPath, commitID, missingPaths)
+		if err != nil {
+			return err
+		}
+
+		found, err = readCommits(stdin, stdout, commitIDs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for path, commit := range found {
+		commits[path] = commit
+	}
+	cacheLastCommits(key, found)
+
+	return commits, nil
+}
+
+// lastCommitsWalk reads the entries of dir in the merge commits met while
+// walking the history
+type lastCommitsWalk struct {
+	stdin  io.Writer
+	stdout *bufio.Reader
+	dir    string
+	// The mode and object ID of the entries of dir, by commit ID
+	entries map[string]map[string]string
+}
+
+// walkedCommit is a commit listed by git log, with its parents and the
+// wanted paths it changed
+type walkedCommit struct {
+	id      string
+	parents []string
+	changed map[string]bool
+}
+
+// lastCommitIDs walks the history once to find the last commit of each
+// path, as git log -1 -- <path> would. Paths are entries of the same
+// directory, so a change to dir/sub/file is attributed to dir/sub.
+//
+// git log would simplify the history for all the paths at once, following
+// a merge down a parent only if it is TREESAME to it for every path. So
+// the full history is listed, and each path follows its own simplified
+// history: a merge is followed down the first parent it is TREESAME to for
+// the path, and is the last commit of the path if there is none.
+func (w *lastCommitsWalk) lastCommitIDs(ctx context.Context, repoPath, commitID string, paths []string) (map[string]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wanted := make(map[string]bool)
+	for _, path := range paths {
+		wanted[path] = true
+	}
+
+	// --sparse lists the commits which don't change any path too, so that
+	// the parents of each commit are known
+	args := []string{"--git-dir", repoPath, "--literal-pathspecs", "log", "--full-history", "--sparse", "--no-renames",
+		"--name-only", "-z", "--format=%x01%H %P", commitID, "--"}
+	args = append(args, paths...)
+
+	cmd, err := command.Git(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	commitIDs := make(map[string]string)
+	// The paths whose simplified history continues at each commit
+	pending := map[string][]string{commitID: paths}
+
+	var current *walkedCommit
+	reader := bufio.NewReader(cmd)
+
+	for len(pending) > 0 {
+		token, err := reader.ReadBytes('\x00')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		token = bytes.TrimPrefix(bytes.TrimSuffix(token, []byte{'\x00'}), []byte{'\n'})
+		switch {
+		case bytes.HasPrefix(token, []byte{'\x01'}):
+			if err := w.follow(current, pending, commitIDs); err != nil {
+				return nil, err
+			}
+
+			fields := strings.Fields(string(token[1:]))
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("invalid commit line: %q", token)
+			}
+			current = &walkedCommit{id: fields[0], parents: fields[1:], changed: make(map[string]bool)}
+		case len(token) > 0 && current != nil:
+			if path := wantedPath(wanted, string(token)); path != "" {
+				current.changed[path] = true
+			}
+		}
+
+		if err == io.EOF {
+			if err := w.follow(current, pending, commitIDs); err != nil {
+				return nil, err
+			}
+			if err := cmd.Wait(); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	// Once all the paths are found, the rest of the history is of no
+	// interest and git log is killed by canceling ctx.
+	//
+	// git log lists commits by date, so with clock skew a commit can come
+	// before one of its children, and the paths following it are left
+	for _, paths := range pending {
+		for _, path := range paths {
+			id, err := lastCommitID(ctx, repoPath, commitID, path)
+			if err != nil {
+				return nil, err
+			}
+			if id != "" {
+				commitIDs[path] = id
+			}
+		}
+	}
+
+	return commitIDs, nil
+}
+
+// follow moves the paths whose simplified history continues at commit to
+// its parent, or records commit as their last commit if it changed them
+func (w *lastCommitsWalk) follow(commit *walkedCommit, pending map[string][]string, commitIDs map[string]string) error {
+	if commit == nil {
+		return nil
+	}
+
+	paths, ok := pending[commit.id]
+	if !ok {
+		return nil
+	}
+	delete(pending, commit.id)
+
+	if len(commit.parents) < 2 {
+		for _, path := range paths {
+			// Root commits change all their paths
+			if commit.changed[path] {
+				commitIDs[path] = commit.id
+			} else if len(commit.parents) == 1 {
+				pending[commit.parents[0]] = append(pending[commit.parents[0]], path)
+			}
+		}
+
+		return nil
+	}
+
+	entries, err := w.dirEntries(commit.id)
+	if err != nil {
+		return err
+	}
+
+	parentEntries := make([]map[string]string, len(commit.parents))
+	for i, parent := range commit.parents {
+		if parentEntries[i], err = w.dirEntries(parent); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range paths {
+		treesameParent := ""
+		for i, parent := range commit.parents {
+			if parentEntries[i][path] == entries[path] {
+				treesameParent = parent
+				break
+			}
+		}
+
+		if treesameParent == "" {
+			commitIDs[path] = commit.id
+		} else {
+			pending[treesameParent] = append(pending[treesameParent], path)
+		}
+	}
+
+	return nil
+}
+
+// dirEntries returns the mode and object ID of the entries of dir as of
+// commitID, by path. There are none if dir isn't a tree.
+func (w *lastCommitsWalk) dirEntries(commitID string) (map[string]string, error) {
+	if entries, ok := w.entries[commitID]; ok {
+		return entries, nil
+	}
+
+	info, err := getTreeInfo(commitID, w.dir, w.stdin, w.stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]string)
+	if info.Type == "tree" {
+		treeEntries, err := extractEntryInfoFromTreeData(w.stdout, commitID, "", w.dir, info)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range treeEntries {
+			entries[string(entry.Path)] = fmt.Sprintf("%o %s", entry.Mode, entry.Oid)
+		}
+	} else if info.Oid != "" {
+		// The content is followed by a newline
+		if _, err := w.stdout.Discard(int(info.Size) + 1); err != nil {
+			return nil, err
+		}
+	}
+
+	w.entries[commitID] = entries
+	return entries, nil
+}
+
+// lastCommitID returns the ID of the last commit of path as of commitID
+func lastCommitID(ctx context.Context, repoPath, commitID, path string) (string, error) {
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "--literal-pathspecs", "log", "-1", "--format=%H", commitID, "--", path)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := ioutil.ReadAll(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// wantedPath returns the wanted path that changedPath is, or is in
+func wantedPath(wanted map[string]bool, changedPath string) string {
+	for path := changedPath; ; {
+		if wanted[path] {
+			return path
+		}
+
+		slash := strings.LastIndexByte(path, '/')
+		if slash < 0 {
+			return ""
+		}
+		path = path[:slash]
+	}
+}
+
+// readCommits reads the commits of the given paths
+func readCommits(stdin io.Writer, stdout *bufio.Reader, commitIDs map[string]string) (map[string]*pb.GitCommit, error) {
+	commits := make(map[string]*pb.GitCommit)
+	byID := make(map[string]*pb.GitCommit)
+
+	for path, id := range commitIDs {
+		if commit := byID[id]; commit != nil {
+			commits[path] = commit
+			continue
+		}
+
+		info, content, err := catfile.ReadObject(stdin, stdout, id)
+		if err != nil {
+			return nil, err
+		}
+		if info.Type != "commit" {
+			return nil, fmt.Errorf("not a commit: %q", id)
+		}
+
+		commit, err := log.ParseRawCommit(info.Oid, content)
+		if err != nil {
+			return nil, err
+		}
+
+		byID[id] = commit
+		commits[path] = commit
+	}
+
+	return commits, nil
+}
+
+func cacheLastCommits(key lastCommitsCacheKey, commits map[string]*pb.GitCommit) {
+	lastCommitsCache.Lock()
+	defer lastCommitsCache.Unlock()
+
+	entry := lastCommitsCache.entries[key]
+	newPaths := 0
+	for path := range commits {
+		if entry[path] == nil {
+			newPaths++
+		}
+	}
+
+	// The last commits of a huge directory aren't worth evicting everything
+	if len(entry)+newPaths > lastCommitsCacheMaxPaths {
+		return
+	}
+
+	// Evict arbitrary entries to bound memory usage
+	for evictedKey, evicted := range lastCommitsCache.entries {
+		if lastCommitsCache.paths+newPaths <= lastCommitsCacheMaxPaths {
+			break
+		}
+		if evictedKey == key {
+			continue
+		}
+		delete(lastCommitsCache.entries, evictedKey)
+		lastCommitsCache.paths -= len(evicted)
+	}
+
+	if entry == nil {
+		entry = make(map[string]*pb.GitCommit)
+	}
+	lastCommitsCache.entries[key] = entry
+
+	for path, commit := range commits {
+		entry[path] = commit
+	}
+	lastCommitsCache.paths += newPaths
+}
+
+// sendCommitsForTree sends the entries in batches, which are flushed once
+// they are larger than maxMsgSize
+func sendCommitsForTree(stream pb.CommitService_ListLastCommitsForTreeServer, entries []commitForTree) error {
+	var commits []*pb.ListLastCommitsForTreeResponse_CommitForTree
+	batch := &batchSender{send: func() error {
+		err := stream.Send(&pb.ListLastCommitsForTreeResponse{Commits: commits})
+		commits = nil
+		return err
+	}}
+
+	for _, entry := range entries {
+		commit := &pb.ListLastCommitsForTreeResponse_CommitForTree{Path: []byte(entry.path), Commit: entry.commit}
+
+		commits = append(commits, commit)
+		if err := batch.add(proto.Size(commit)); err != nil {
+			return err
+		}
+	}
+
+	return batch.flush()
+}
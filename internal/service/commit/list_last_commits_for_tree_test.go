@@ -0,0 +1,295 @@
+package commit
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"testing"
+
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func listLastCommitsForTree(t *testing.T, client pb.CommitServiceClient, request *pb.ListLastCommitsForTreeRequest) ([]*pb.ListLastCommitsForTreeResponse_CommitForTree, error) {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.ListLastCommitsForTree(ctx, request)
+	require.NoError(t, err)
+
+	var commits []*pb.ListLastCommitsForTreeResponse_CommitForTree
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		commits = append(commits, response.GetCommits()...)
+	}
+
+	return commits, nil
+}
+
+// lastCommitsForTree returns the paths of the entries of dir and the IDs of
+// their last commits, found by one git log per entry
+func lastCommitsForTree(t *testing.T, repoPath, revision, dir string) [][2]string {
+	output := testhelper.MustRunCommand(t, nil, "git", "--git-dir", repoPath, "ls-tree", "-z", "--name-only", revision+":"+dir)
+
+	var commits [][2]string
+	for _, name := range strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00") {
+		entryPath := path.Join(dir, name)
+		id := testhelper.MustRunCommand(t, nil, "git", "--git-dir", repoPath, "log", "-1", "--format=%H", revision, "--", entryPath)
+		commits = append(commits, [2]string{entryPath, strings.TrimSpace(string(id))})
+	}
+
+	return commits
+}
+
+func TestSuccessfulListLastCommitsForTree(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testRepoPath, err := helper.GetRepoPath(testRepo)
+	require.NoError(t, err)
+
+	rootCommits := lastCommitsForTree(t, testRepoPath, "master", "")
+	require.True(t, len(rootCommits) >= 3, "expected at least 3 entries in the root directory")
+
+	testCases := []struct {
+		desc    string
+		request *pb.ListLastCommitsForTreeRequest
+		// Paths and the IDs of their last commits
+		commits [][2]string
+	}{
+		{
+			desc:    "root directory",
+			request: &pb.ListLastCommitsForTreeRequest{Revision: "master"},
+			commits: rootCommits,
+		},
+		{
+			desc:    "older revision",
+			request: &pb.ListLastCommitsForTreeRequest{Revision: "master~1"},
+			commits: lastCommitsForTree(t, testRepoPath, "master~1", ""),
+		},
+		{
+			desc:    "offset and limit",
+			request: &pb.ListLastCommitsForTreeRequest{Revision: "master", Offset: 1, Limit: 2},
+			commits: rootCommits[1:3],
+		},
+		{
+			desc:    "offset past the last entry",
+			request: &pb.ListLastCommitsForTreeRequest{Revision: "master", Offset: int32(len(rootCommits))},
+		},
+		{
+			desc:    "subdirectory",
+			request: &pb.ListLastCommitsForTreeRequest{Revision: "master", Path: []byte("files/")},
+			commits: lastCommitsForTree(t, testRepoPath, "master", "files"),
+		},
+		{
+			desc:    "nested subdirectory",
+			request: &pb.ListLastCommitsForTreeRequest{Revision: "master", Path: []byte("files/ruby")},
+			commits: lastCommitsForTree(t, testRepoPath, "master", "files/ruby"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.request.Repository = testRepo
+
+			commits, err := listLastCommitsForTree(t, client, tc.request)
+			require.NoError(t, err)
+
+			var pathsAndIDs [][2]string
+			for _, commit := range commits {
+				pathsAndIDs = append(pathsAndIDs, [2]string{string(commit.GetPath()), commit.GetCommit().GetId()})
+			}
+			require.Equal(t, tc.commits, pathsAndIDs)
+		})
+	}
+}
+
+func TestListLastCommitsForTreeMerges(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	// x and y change a.txt concurrently and are merged both ways, keeping
+	// a different side each time
+	x := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Change a.txt on x", Parents: []string{"master"}, Files: map[string]string{"a.txt": "x\n"}})
+	y := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Change a.txt on y", Parents: []string{"master"}, Files: map[string]string{"a.txt": "y\n"}})
+	mergeX := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Merge y into x", Parents: []string{x, y}})
+	mergeY := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Merge x into y", Parents: []string{y, x}})
+
+	// The final merge keeps a.txt from mergeY, and b.txt from the first
+	// parent, so it differs from both its parents over a.txt and b.txt
+	z := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Change a.txt and b.txt on z", Parents: []string{mergeX}, Files: map[string]string{"a.txt": "z\n", "b.txt": "z\n"}})
+	tip := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Merge mergeY into z", Parents: []string{z, mergeY}, Files: map[string]string{"a.txt": "y\n"}})
+
+	// An evil merge changes a.txt compared to both its parents
+	evil := testhelper.WriteCommit(t, testRepoPath, testhelper.CommitOptions{Message: "Evil merge", Parents: []string{z, mergeY}, Files: map[string]string{"a.txt": "evil\n"}})
+
+	for _, revision := range []string{tip, evil} {
+		expected := lastCommitsForTree(t, testRepoPath, revision, "")
+
+		commits, err := listLastCommitsForTree(t, client, &pb.ListLastCommitsForTreeRequest{Repository: testRepo, Revision: revision})
+		require.NoError(t, err)
+
+		var pathsAndIDs [][2]string
+		for _, commit := range commits {
+			pathsAndIDs = append(pathsAndIDs, [2]string{string(commit.GetPath()), commit.GetCommit().GetId()})
+		}
+		require.Equal(t, expected, pathsAndIDs)
+	}
+
+	// a.txt comes from y through mergeY, even though z changed it more
+	// recently on the side of the first parent
+	require.Contains(t, lastCommitsForTree(t, testRepoPath, tip, ""), [2]string{"a.txt", y})
+	require.Contains(t, lastCommitsForTree(t, testRepoPath, evil, ""), [2]string{"a.txt", evil})
+}
+
+func TestListLastCommitsForTreeCache(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testRepoPath, err := helper.GetRepoPath(testRepo)
+	require.NoError(t, err)
+
+	request := &pb.ListLastCommitsForTreeRequest{Repository: testRepo, Revision: "master", Path: []byte("files/ruby")}
+	commits, err := listLastCommitsForTree(t, client, request)
+	require.NoError(t, err)
+	require.NotEmpty(t, commits)
+	cachedPath := string(commits[0].GetPath())
+
+	// Results are cached by commit ID, whatever the revision was
+	commitID := strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "rev-parse", "master")))
+	key := lastCommitsCacheKey{repoPath: testRepoPath, commitID: commitID}
+
+	lastCommitsCache.Lock()
+	cachedCommit := lastCommitsCache.entries[key][cachedPath]
+	if cachedCommit != nil {
+		lastCommitsCache.entries[key][cachedPath] = &pb.GitCommit{Id: "fake", Author: cachedCommit.Author, Committer: cachedCommit.Committer}
+	}
+	lastCommitsCache.Unlock()
+	require.NotNil(t, cachedCommit)
+
+	commits, err = listLastCommitsForTree(t, client, request)
+	require.NoError(t, err)
+	require.Equal(t, "fake", commits[0].GetCommit().GetId())
+}
+
+func TestCacheLastCommitsBoundsPaths(t *testing.T) {
+	lastCommitsCache.Lock()
+	savedEntries, savedPaths := lastCommitsCache.entries, lastCommitsCache.paths
+	lastCommitsCache.entries, lastCommitsCache.paths = make(map[lastCommitsCacheKey]map[string]*pb.GitCommit), 0
+	lastCommitsCache.Unlock()
+	defer func() {
+		lastCommitsCache.Lock()
+		lastCommitsCache.entries, lastCommitsCache.paths = savedEntries, savedPaths
+		lastCommitsCache.Unlock()
+	}()
+
+	commitsOfPaths := func(n int) map[string]*pb.GitCommit {
+		commits := make(map[string]*pb.GitCommit)
+		for i := 0; i < n; i++ {
+			commits[fmt.Sprintf("file-%d", i)] = &pb.GitCommit{Id: "fake"}
+		}
+		return commits
+	}
+	cachedPaths := func() int {
+		lastCommitsCache.Lock()
+		defer lastCommitsCache.Unlock()
+
+		paths := 0
+		for _, entry := range lastCommitsCache.entries {
+			paths += len(entry)
+		}
+		require.Equal(t, paths, lastCommitsCache.paths)
+		return paths
+	}
+
+	half := lastCommitsCacheMaxPaths / 2
+	cacheLastCommits(lastCommitsCacheKey{commitID: "1"}, commitsOfPaths(half))
+	cacheLastCommits(lastCommitsCacheKey{commitID: "2"}, commitsOfPaths(half))
+	require.Equal(t, 2*half, cachedPaths())
+
+	// Caching the same paths again doesn't count them twice
+	cacheLastCommits(lastCommitsCacheKey{commitID: "2"}, commitsOfPaths(half))
+	require.Equal(t, 2*half, cachedPaths())
+
+	cacheLastCommits(lastCommitsCacheKey{commitID: "3"}, commitsOfPaths(10))
+	require.True(t, cachedPaths() <= lastCommitsCacheMaxPaths)
+	require.Len(t, lastCommitsCache.entries[lastCommitsCacheKey{commitID: "3"}], 10)
+
+	cacheLastCommits(lastCommitsCacheKey{commitID: "4"}, commitsOfPaths(lastCommitsCacheMaxPaths+1))
+	require.NotContains(t, lastCommitsCache.entries, lastCommitsCacheKey{commitID: "4"})
+	require.True(t, cachedPaths() <= lastCommitsCacheMaxPaths)
+}
+
+func TestFailedListLastCommitsForTree(t *testing.T) {
+	server := startTestServices(t)
+	defer server.Stop()
+
+	client, conn := newCommitServiceClient(t, serverSocketPath)
+	defer conn.Close()
+
+	testCases := []struct {
+		desc    string
+		request *pb.ListLastCommitsForTreeRequest
+	}{
+		{
+			desc:    "empty revision",
+			request: &pb.ListLastCommitsForTreeRequest{Repository: testRepo},
+		},
+		{
+			desc:    "revision starting with -",
+			request: &pb.ListLastCommitsForTreeRequest{Repository: testRepo, Revision: "--all"},
+		},
+		{
+			desc:    "missing revision",
+			request: &pb.ListLastCommitsForTreeRequest{Repository: testRepo, Revision: "does-not-exist"},
+		},
+		{
+			desc:    "missing path",
+			request: &pb.ListLastCommitsForTreeRequest{Repository: testRepo, Revision: "master", Path: []byte("does-not-exist")},
+		},
+		{
+			desc:    "path of a file",
+			request: &pb.ListLastCommitsForTreeRequest{Repository: testRepo, Revision: "master", Path: []byte("README.md")},
+		},
+		{
+			desc:    "negative offset",
+			request: &pb.ListLastCommitsForTreeRequest{Repository: testRepo, Revision: "master", Offset: -1},
+		},
+		{
+			desc:    "negative limit",
+			request: &pb.ListLastCommitsForTreeRequest{Repository: testRepo, Revision: "master", Limit: -1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := listLastCommitsForTree(t, client, tc.request)
+			testhelper.AssertGrpcError(t, err, codes.InvalidArgument, "")
+		})
+	}
+}
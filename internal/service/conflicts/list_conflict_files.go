@@ -0,0 +1,116 @@
+package conflicts
+
+import (
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+func (s *server) ListConflictFiles(in *pb.ListConflictFilesRequest, stream pb.ConflictsService_ListConflictFilesServer) error {
+	if err := validateListConflictFilesRequest(in); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "ListConflictFiles: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return err
+	}
+
+	m, err := mergeCommits(stream.Context(), "ListConflictFiles", in.GetRepository(), repoPath, in.GetOurCommitOid(), in.GetTheirCommitOid())
+	if err != nil {
+		return err
+	}
+
+	sender := &conflictFilesSender{stream: stream}
+	for _, file := range m.files {
+		ourMode, err := strconv.ParseInt(file.Ours.Mode, 8, 32)
+		if err != nil {
+			return grpc.Errorf(codes.Internal, "ListConflictFiles: %v", err)
+		}
+
+		header := &pb.ConflictFileHeader{
+			Path:     []byte(file.Path),
+			OurOid:   file.Ours.Oid,
+			TheirOid: file.Theirs.Oid,
+			OurMode:  int32(ourMode),
+		}
+		if file.Ancestor != nil {
+			header.AncestorOid = file.Ancestor.Oid
+		}
+
+		if err := sender.add(&pb.ConflictFile{Header: header}, len(file.Path)+3*len(file.Ours.Oid)+4); err != nil {
+			return grpc.Errorf(codes.Unavailable, "ListConflictFiles: send: %v", err)
+		}
+
+		for content := file.content; len(content) > 0; {
+			chunk := content
+			if len(chunk) > maxMsgSize {
+				chunk = chunk[:maxMsgSize]
+			}
+			content = content[len(chunk):]
+
+			if err := sender.add(&pb.ConflictFile{Content: chunk}, len(chunk)); err != nil {
+				return grpc.Errorf(codes.Unavailable, "ListConflictFiles: send: %v", err)
+			}
+		}
+	}
+
+	if err := sender.flush(); err != nil {
+		return grpc.Errorf(codes.Unavailable, "ListConflictFiles: send: %v", err)
+	}
+
+	return nil
+}
+
+func validateListConflictFilesRequest(in *pb.ListConflictFilesRequest) error {
+	if in.GetOurCommitOid() == "" {
+		return fmt.Errorf("empty our commit OID")
+	}
+
+	if in.GetTheirCommitOid() == "" {
+		return fmt.Errorf("empty their commit OID")
+	}
+
+	return nil
+}
+
+// conflictFilesSender batches conflict files into messages of about
+// maxMsgSize bytes.
+type conflictFilesSender struct {
+	stream pb.ConflictsService_ListConflictFilesServer
+	files  []*pb.ConflictFile
+	size   int
+}
+
+func (s *conflictFilesSender) add(file *pb.ConflictFile, size int) error {
+	if s.size+size > maxMsgSize {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	}
+
+	s.files = append(s.files, file)
+	s.size += size
+
+	return nil
+}
+
+func (s *conflictFilesSender) flush() error {
+	if len(s.files) == 0 {
+		return nil
+	}
+
+	if err := s.stream.Send(&pb.ListConflictFilesResponse{Files: s.files}); err != nil {
+		return err
+	}
+
+	s.files = nil
+	s.size = 0
+
+	return nil
+}
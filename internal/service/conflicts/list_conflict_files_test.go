@@ -0,0 +1,185 @@
+package conflicts
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+type listedFile struct {
+	header  *pb.ConflictFileHeader
+	content string
+}
+
+// listConflictFiles returns the conflict files with their content put back
+// together, and the number of messages they were sent in.
+func listConflictFiles(t *testing.T, client pb.ConflictsServiceClient, request *pb.ListConflictFilesRequest) ([]listedFile, int, error) {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.ListConflictFiles(ctx, request)
+	require.NoError(t, err)
+
+	var files []listedFile
+	messages := 0
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			return files, messages, nil
+		} else if err != nil {
+			return nil, messages, err
+		}
+		messages++
+
+		for _, file := range response.GetFiles() {
+			if file.GetHeader() != nil {
+				files = append(files, listedFile{header: file.GetHeader()})
+			}
+			files[len(files)-1].content += string(file.GetContent())
+		}
+	}
+}
+
+func TestSuccessfulListConflictFilesRequest(t *testing.T) {
+	server := runConflictsServer(t)
+	defer server.Stop()
+
+	client, conn := newConflictsClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupConflicts(t, testRepoPath)
+
+	expectedFiles := []listedFile{
+		{
+			header: &pb.ConflictFileHeader{
+				Path:        []byte("README.md"),
+				AncestorOid: testhelper.RevParse(t, testRepoPath, setup.base+":README.md"),
+				OurOid:      testhelper.RevParse(t, testRepoPath, setup.ours+":README.md"),
+				TheirOid:    testhelper.RevParse(t, testRepoPath, setup.theirs+":README.md"),
+				OurMode:     0100644,
+			},
+			content: "<<<<<<< README.md\nOur conflicts\n=======\nTheir conflicts\n>>>>>>> README.md\n",
+		},
+		{
+			header: &pb.ConflictFileHeader{
+				Path:        []byte("numbers.txt"),
+				AncestorOid: testhelper.RevParse(t, testRepoPath, setup.base+":numbers.txt"),
+				OurOid:      testhelper.RevParse(t, testRepoPath, setup.ours+":numbers.txt"),
+				TheirOid:    testhelper.RevParse(t, testRepoPath, setup.theirs+":numbers.txt"),
+				OurMode:     0100644,
+			},
+			content: "<<<<<<< numbers.txt\none\n=======\nuno\n>>>>>>> numbers.txt\n2\n3\n4\n5\n6\n7\n8\nnueve\n",
+		},
+	}
+
+	request := &pb.ListConflictFilesRequest{
+		Repository:     testRepo,
+		OurCommitOid:   ourBranch,
+		TheirCommitOid: setup.theirs,
+	}
+
+	files, messages, err := listConflictFiles(t, client, request)
+	require.NoError(t, err)
+	require.Equal(t, expectedFiles, files)
+	require.Equal(t, 1, messages)
+
+	defer func(oldSize int) {
+		maxMsgSize = oldSize
+	}(maxMsgSize)
+	maxMsgSize = 10
+
+	files, messages, err = listConflictFiles(t, client, request)
+	require.NoError(t, err)
+	require.Equal(t, expectedFiles, files)
+	require.True(t, messages > 2, "expected the files to be split over several messages, got %d", messages)
+}
+
+func TestSuccessfulListConflictFilesRequestWithoutConflicts(t *testing.T) {
+	server := runConflictsServer(t)
+	defer server.Stop()
+
+	client, conn := newConflictsClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupConflicts(t, testRepoPath)
+	clean := testhelper.CreateCommit(t, testRepoPath, "gitaly-conflicts-clean", setup.base, map[string]string{"clean.txt": "clean\n"})
+
+	files, _, err := listConflictFiles(t, client, &pb.ListConflictFilesRequest{
+		Repository:     testRepo,
+		OurCommitOid:   setup.ours,
+		TheirCommitOid: clean,
+	})
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestFailedListConflictFilesRequest(t *testing.T) {
+	server := runConflictsServer(t)
+	defer server.Stop()
+
+	client, conn := newConflictsClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupConflicts(t, testRepoPath)
+	binary := testhelper.CreateCommit(t, testRepoPath, "gitaly-conflicts-binary", setup.base, map[string]string{"numbers.txt": "1\x002\n"})
+	unrelated := testhelper.CreateCommit(t, testRepoPath, "gitaly-conflicts-unrelated", "", map[string]string{"numbers.txt": "1\n"})
+
+	testCases := []struct {
+		desc    string
+		request *pb.ListConflictFilesRequest
+		code    codes.Code
+	}{
+		{
+			desc:    "empty our commit",
+			request: &pb.ListConflictFilesRequest{Repository: testRepo, TheirCommitOid: setup.theirs},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty their commit",
+			request: &pb.ListConflictFilesRequest{Repository: testRepo, OurCommitOid: setup.ours},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "non-existing our commit",
+			request: &pb.ListConflictFilesRequest{Repository: testRepo, OurCommitOid: strings.Repeat("1", 40), TheirCommitOid: setup.theirs},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "non-existing their commit",
+			request: &pb.ListConflictFilesRequest{Repository: testRepo, OurCommitOid: setup.ours, TheirCommitOid: strings.Repeat("1", 40)},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "no merge base",
+			request: &pb.ListConflictFilesRequest{Repository: testRepo, OurCommitOid: setup.ours, TheirCommitOid: unrelated},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "binary conflict",
+			request: &pb.ListConflictFilesRequest{Repository: testRepo, OurCommitOid: setup.ours, TheirCommitOid: binary},
+			code:    codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, _, err := listConflictFiles(t, client, tc.request)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package conflicts
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/index"
+	"gitlab.com/gitlab-org/gitaly/internal/git/log"
+	"gitlab.com/gitlab-org/gitaly/internal/tempdir"
+)
+
+// conflictFile is a conflicting path with its content merged line by line,
+// conflict markers included.
+type conflictFile struct {
+	index.Conflict
+	content []byte
+}
+
+// merge is the result of merging their commit into our commit in an index.
+// Only the conflicts are left in the index.
+type merge struct {
+	idx       *index.Index
+	ourCommit string
+	// The commit merged into ours
+	theirCommit string
+	files       []conflictFile
+}
+
+// mergeCommits merges theirs into ours. The conflicts must be resolvable by
+// editing the conflicting files, otherwise an error is returned. Errors are
+// prefixed with rpcName.
+func mergeCommits(ctx context.Context, rpcName string, repo *pb.Repository, repoPath, ours, theirs string) (*merge, error) {
+	ourCommit, err := log.GetCommit(ctx, repo, ours, "")
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+	if ourCommit == nil {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "%s: our commit not found", rpcName)
+	}
+
+	theirCommit, err := log.GetCommit(ctx, repo, theirs, "")
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+	if theirCommit == nil {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "%s: their commit not found", rpcName)
+	}
+
+	base, err := git.MergeBase(ctx, repoPath, ourCommit.Id, theirCommit.Id)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+	if base == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "%s: no merge base", rpcName)
+	}
+
+	tempDir, err := tempdir.New(ctx, repo)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+
+	m := &merge{
+		idx:         index.New(repoPath, tempDir),
+		ourCommit:   ourCommit.Id,
+		theirCommit: theirCommit.Id,
+	}
+
+	conflicts, err := m.idx.Merge(ctx, base, m.ourCommit, m.theirCommit)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+
+	for _, conflict := range conflicts {
+		content, err := m.idx.MergeFile(ctx, conflict, conflict.Path, conflict.Path)
+		if err == index.ErrNotMergeable {
+			return nil, grpc.Errorf(codes.FailedPrecondition, "%s: %s: %v", rpcName, conflict.Path, err)
+		} else if err != nil {
+			return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+		}
+
+		m.files = append(m.files, conflictFile{Conflict: conflict, content: content})
+	}
+
+	return m, nil
+}
@@ -0,0 +1,259 @@
+package conflicts
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/hooks"
+	"gitlab.com/gitlab-org/gitaly/internal/git/index"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+// resolutionError is a problem with the resolutions sent by the client,
+// meant to be shown to the user.
+type resolutionError string
+
+func (e resolutionError) Error() string {
+	return string(e)
+}
+
+// ResolveConflicts merges their commit into the source branch, resolving
+// the conflicts with the files sent by the client.
+func (s *server) ResolveConflicts(stream pb.ConflictsService_ResolveConflictsServer) error {
+	firstRequest, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	header := firstRequest.GetHeader()
+	if err := validateResolveConflictsHeader(header); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "ResolveConflicts: %v", err)
+	}
+
+	ctx := stream.Context()
+	repo := header.GetRepository()
+	repoPath, err := helper.GetRepoPath(repo)
+	if err != nil {
+		return err
+	}
+
+	m, err := mergeCommits(ctx, "ResolveConflicts", repo, repoPath, header.GetOurCommitOid(), header.GetTheirCommitOid())
+	if err != nil {
+		return err
+	}
+
+	branch := "refs/heads/" + string(header.GetSourceBranch())
+	oldrev, err := git.ResolveCommit(ctx, repoPath, branch)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "ResolveConflicts: %v", err)
+	}
+	if oldrev != m.ourCommit {
+		return grpc.Errorf(codes.FailedPrecondition, "ResolveConflicts: source branch doesn't point to our commit")
+	}
+
+	resolutions, err := readResolutions(stream, firstRequest.GetFile())
+	if err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "ResolveConflicts: %v", err)
+	}
+
+	err = applyResolutions(ctx, m, resolutions)
+	if resErr, ok := err.(resolutionError); ok {
+		return stream.SendAndClose(&pb.ResolveConflictsResponse{ResolutionError: string(resErr)})
+	} else if err != nil {
+		return grpc.Errorf(codes.Internal, "ResolveConflicts: %v", err)
+	}
+
+	tree, err := m.idx.WriteTree(ctx)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "ResolveConflicts: %v", err)
+	}
+
+	user := git.UserSignature(header.GetUser())
+	commitID, err := git.CommitTree(ctx, repoPath, user, user, tree, header.GetCommitMessage(), m.ourCommit, m.theirCommit)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "ResolveConflicts: %v", err)
+	}
+
+	hookErr, err := hooks.RPCError("ResolveConflicts", hooks.UpdateReference(ctx, repo, header.GetUser(), branch, commitID, oldrev))
+	if err != nil {
+		return err
+	}
+	if hookErr != nil {
+		return stream.SendAndClose(&pb.ResolveConflictsResponse{PreReceiveError: hookErr.Message()})
+	}
+
+	return stream.SendAndClose(&pb.ResolveConflictsResponse{CommitId: commitID})
+}
+
+func validateResolveConflictsHeader(header *pb.ResolveConflictsRequestHeader) error {
+	if header == nil {
+		return fmt.Errorf("empty header")
+	}
+
+	if header.GetUser() == nil {
+		return fmt.Errorf("empty user")
+	}
+
+	if header.GetOurCommitOid() == "" {
+		return fmt.Errorf("empty our commit OID")
+	}
+
+	if header.GetTheirCommitOid() == "" {
+		return fmt.Errorf("empty their commit OID")
+	}
+
+	if len(header.GetSourceBranch()) == 0 {
+		return fmt.Errorf("empty source branch")
+	}
+
+	if len(header.GetCommitMessage()) == 0 {
+		return fmt.Errorf("empty commit message")
+	}
+
+	return nil
+}
+
+// resolution is a resolved file sent by the client
+type resolution struct {
+	header  *pb.ResolveConflictsFileHeader
+	content bytes.Buffer
+}
+
+// readResolutions reads the resolved files from the stream. The first file
+// may have been sent with the request header.
+func readResolutions(stream pb.ConflictsService_ResolveConflictsServer, first *pb.ResolveConflictsFile) ([]*resolution, error) {
+	var resolutions []*resolution
+
+	for file := first; ; {
+		if file != nil {
+			if header := file.GetHeader(); header != nil {
+				resolutions = append(resolutions, &resolution{header: header})
+			}
+
+			if len(file.GetContent()) > 0 {
+				if len(resolutions) == 0 {
+					return nil, fmt.Errorf("content sent before the file header")
+				}
+				resolutions[len(resolutions)-1].content.Write(file.GetContent())
+			}
+		}
+
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return resolutions, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if request.GetHeader() != nil {
+			return nil, fmt.Errorf("header sent more than once")
+		}
+		file = request.GetFile()
+	}
+}
+
+// applyResolutions replaces each conflicting file in the index of the merge
+// with its resolution.
+func applyResolutions(ctx context.Context, m *merge, resolutions []*resolution) error {
+	files := make(map[string]*conflictFile)
+	for i := range m.files {
+		files[m.files[i].Path] = &m.files[i]
+	}
+
+	resolved := make(map[string]bool)
+	for _, r := range resolutions {
+		path := string(r.header.GetPath())
+
+		file, ok := files[path]
+		if !ok {
+			return resolutionError(fmt.Sprintf("%s has no conflicts", path))
+		}
+		if resolved[path] {
+			return resolutionError(fmt.Sprintf("%s is resolved more than once", path))
+		}
+		resolved[path] = true
+
+		content := r.content.Bytes()
+		if sections := r.header.GetSections(); len(sections) > 0 {
+			var err error
+			if content, err = resolveSections(file.content, sections); err != nil {
+				return resolutionError(fmt.Sprintf("%s: %v", path, err))
+			}
+		} else if bytes.Equal(content, file.content) {
+			return resolutionError(fmt.Sprintf("Resolved content has no changes for %s", path))
+		}
+
+		oid, err := m.idx.WriteBlob(ctx, bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+
+		// Adding the path at stage 0 removes the conflict
+		mode, _ := file.MergedMode()
+		if err := m.idx.Add(ctx, path, index.Entry{Mode: mode, Oid: oid}); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range m.files {
+		if !resolved[file.Path] {
+			return resolutionError(fmt.Sprintf("Missing resolution for %s", file.Path))
+		}
+	}
+
+	return nil
+}
+
+var (
+	ourMarker       = []byte("<<<<<<< ")
+	separatorMarker = []byte("=======")
+	theirMarker     = []byte(">>>>>>> ")
+)
+
+// resolveSections replaces each conflicting section of content, as
+// delimited by the conflict markers of git merge-file, with one of its
+// sides.
+func resolveSections(content []byte, sides []pb.ResolveConflictsFileHeader_Side) ([]byte, error) {
+	const (
+		outside = iota
+		inOurs
+		inTheirs
+	)
+
+	var resolved bytes.Buffer
+	state := outside
+	section := -1
+
+	keep := func(side pb.ResolveConflictsFileHeader_Side) bool {
+		return section < len(sides) && sides[section] == side
+	}
+
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		switch {
+		case state == outside && bytes.HasPrefix(line, ourMarker):
+			state = inOurs
+			section++
+		case state == inOurs && bytes.Equal(bytes.TrimSuffix(line, []byte("\n")), separatorMarker):
+			state = inTheirs
+		case state == inTheirs && bytes.HasPrefix(line, theirMarker):
+			state = outside
+		case state == outside,
+			state == inOurs && keep(pb.ResolveConflictsFileHeader_OURS),
+			state == inTheirs && keep(pb.ResolveConflictsFileHeader_THEIRS):
+			resolved.Write(line)
+		}
+	}
+
+	if sections := section + 1; sections != len(sides) {
+		return nil, fmt.Errorf("%d resolutions for %d conflicting sections", len(sides), sections)
+	}
+
+	return resolved.Bytes(), nil
+}
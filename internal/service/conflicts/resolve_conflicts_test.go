@@ -0,0 +1,284 @@
+package conflicts
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+var (
+	ours   = pb.ResolveConflictsFileHeader_OURS
+	theirs = pb.ResolveConflictsFileHeader_THEIRS
+)
+
+type resolvedFile struct {
+	path     string
+	sections []pb.ResolveConflictsFileHeader_Side
+	content  string
+}
+
+// resolveConflicts sends the header with the first file, and the content of
+// each file in chunks of a few bytes.
+func resolveConflicts(t *testing.T, client pb.ConflictsServiceClient, header *pb.ResolveConflictsRequestHeader, files []resolvedFile) (*pb.ResolveConflictsResponse, error) {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.ResolveConflicts(ctx)
+	require.NoError(t, err)
+
+	request := &pb.ResolveConflictsRequest{Header: header}
+	for _, file := range files {
+		fileHeader := &pb.ResolveConflictsFileHeader{Path: []byte(file.path), Sections: file.sections}
+		request.File = &pb.ResolveConflictsFile{Header: fileHeader}
+		require.NoError(t, stream.Send(request))
+		request = &pb.ResolveConflictsRequest{}
+
+		for content := file.content; len(content) > 0; {
+			n := 5
+			if len(content) < n {
+				n = len(content)
+			}
+			require.NoError(t, stream.Send(&pb.ResolveConflictsRequest{File: &pb.ResolveConflictsFile{Content: []byte(content[:n])}}))
+			content = content[n:]
+		}
+	}
+
+	if header != nil && len(files) == 0 {
+		require.NoError(t, stream.Send(request))
+	}
+
+	return stream.CloseAndRecv()
+}
+
+func TestSuccessfulResolveConflictsRequest(t *testing.T) {
+	server := runConflictsServer(t)
+	defer server.Stop()
+
+	client, conn := newConflictsClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupConflicts(t, testRepoPath)
+
+	header := &pb.ResolveConflictsRequestHeader{
+		Repository:     testRepo,
+		User:           testUser,
+		OurCommitOid:   setup.ours,
+		TheirCommitOid: setup.theirs,
+		SourceBranch:   []byte(ourBranch),
+		CommitMessage:  []byte("Resolve conflicts"),
+	}
+	files := []resolvedFile{
+		{path: "README.md", content: "Resolved conflicts\n"},
+		{path: "numbers.txt", sections: []pb.ResolveConflictsFileHeader_Side{theirs}},
+	}
+
+	response, err := resolveConflicts(t, client, header, files)
+	require.NoError(t, err)
+	require.Empty(t, response.ResolutionError)
+
+	commitID := response.CommitId
+	require.Equal(t, commitID, testhelper.RevParse(t, testRepoPath, ourBranch))
+	require.Equal(t, setup.ours, testhelper.RevParse(t, testRepoPath, commitID+"^1"))
+	require.Equal(t, setup.theirs, testhelper.RevParse(t, testRepoPath, commitID+"^2"))
+
+	require.Equal(t, "Resolved conflicts\n", testhelper.ShowFile(t, testRepoPath, commitID, "README.md"))
+	require.Equal(t, "uno\n2\n3\n4\n5\n6\n7\n8\nnueve\n", testhelper.ShowFile(t, testRepoPath, commitID, "numbers.txt"))
+	require.Equal(t, "theirs\n", testhelper.ShowFile(t, testRepoPath, commitID, "theirs.txt"))
+
+	commitInfo := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "log", "-1", "--format=%an <%ae>%n%cn <%ce>%n%B", commitID))
+	require.Equal(t, "Jane Doe <janedoe@example.com>\nJane Doe <janedoe@example.com>\nResolve conflicts\n", commitInfo)
+}
+
+func TestResolveSections(t *testing.T) {
+	content := []byte("<<<<<<< a\none\n=======\nuno\n>>>>>>> a\n2\n<<<<<<< a\nthree\n=======\ntres\n>>>>>>> a\n")
+
+	resolved, err := resolveSections(content, []pb.ResolveConflictsFileHeader_Side{ours, theirs})
+	require.NoError(t, err)
+	require.Equal(t, "one\n2\ntres\n", string(resolved))
+
+	_, err = resolveSections(content, []pb.ResolveConflictsFileHeader_Side{ours})
+	require.Error(t, err)
+
+	_, err = resolveSections(content, []pb.ResolveConflictsFileHeader_Side{ours, ours, ours})
+	require.Error(t, err)
+}
+
+func TestFailedResolveConflictsRequestDueToResolutionError(t *testing.T) {
+	server := runConflictsServer(t)
+	defer server.Stop()
+
+	client, conn := newConflictsClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupConflicts(t, testRepoPath)
+
+	header := &pb.ResolveConflictsRequestHeader{
+		Repository:     testRepo,
+		User:           testUser,
+		OurCommitOid:   setup.ours,
+		TheirCommitOid: setup.theirs,
+		SourceBranch:   []byte(ourBranch),
+		CommitMessage:  []byte("Resolve conflicts"),
+	}
+	readmeResolution := resolvedFile{path: "README.md", sections: []pb.ResolveConflictsFileHeader_Side{ours}}
+
+	testCases := []struct {
+		desc          string
+		files         []resolvedFile
+		expectedError string
+	}{
+		{
+			desc:          "missing file",
+			files:         []resolvedFile{readmeResolution},
+			expectedError: "Missing resolution for numbers.txt",
+		},
+		{
+			desc:          "file without conflicts",
+			files:         []resolvedFile{readmeResolution, {path: "theirs.txt", content: "ours\n"}},
+			expectedError: "theirs.txt has no conflicts",
+		},
+		{
+			desc:          "file resolved twice",
+			files:         []resolvedFile{readmeResolution, readmeResolution},
+			expectedError: "README.md is resolved more than once",
+		},
+		{
+			desc: "unchanged content",
+			files: []resolvedFile{
+				readmeResolution,
+				{path: "numbers.txt", content: "<<<<<<< numbers.txt\none\n=======\nuno\n>>>>>>> numbers.txt\n2\n3\n4\n5\n6\n7\n8\nnueve\n"},
+			},
+			expectedError: "Resolved content has no changes for numbers.txt",
+		},
+		{
+			desc: "too many sections",
+			files: []resolvedFile{
+				readmeResolution,
+				{path: "numbers.txt", sections: []pb.ResolveConflictsFileHeader_Side{ours, theirs}},
+			},
+			expectedError: "numbers.txt: 2 resolutions for 1 conflicting sections",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			response, err := resolveConflicts(t, client, header, tc.files)
+			require.NoError(t, err)
+			require.Empty(t, response.CommitId)
+			require.Equal(t, tc.expectedError, response.ResolutionError)
+			require.Equal(t, setup.ours, testhelper.RevParse(t, testRepoPath, ourBranch))
+		})
+	}
+}
+
+func TestFailedResolveConflictsRequestDueToValidations(t *testing.T) {
+	server := runConflictsServer(t)
+	defer server.Stop()
+
+	client, conn := newConflictsClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupConflicts(t, testRepoPath)
+	message := []byte("Resolve conflicts")
+	branch := []byte(ourBranch)
+
+	testCases := []struct {
+		desc   string
+		header *pb.ResolveConflictsRequestHeader
+		code   codes.Code
+	}{
+		{
+			desc:   "empty user",
+			header: &pb.ResolveConflictsRequestHeader{Repository: testRepo, OurCommitOid: setup.ours, TheirCommitOid: setup.theirs, SourceBranch: branch, CommitMessage: message},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "empty our commit",
+			header: &pb.ResolveConflictsRequestHeader{Repository: testRepo, User: testUser, TheirCommitOid: setup.theirs, SourceBranch: branch, CommitMessage: message},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "empty their commit",
+			header: &pb.ResolveConflictsRequestHeader{Repository: testRepo, User: testUser, OurCommitOid: setup.ours, SourceBranch: branch, CommitMessage: message},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "empty source branch",
+			header: &pb.ResolveConflictsRequestHeader{Repository: testRepo, User: testUser, OurCommitOid: setup.ours, TheirCommitOid: setup.theirs, CommitMessage: message},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "empty commit message",
+			header: &pb.ResolveConflictsRequestHeader{Repository: testRepo, User: testUser, OurCommitOid: setup.ours, TheirCommitOid: setup.theirs, SourceBranch: branch},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "non-existing their commit",
+			header: &pb.ResolveConflictsRequestHeader{Repository: testRepo, User: testUser, OurCommitOid: setup.ours, TheirCommitOid: strings.Repeat("1", 40), SourceBranch: branch, CommitMessage: message},
+			code:   codes.FailedPrecondition,
+		},
+		{
+			desc:   "source branch has moved",
+			header: &pb.ResolveConflictsRequestHeader{Repository: testRepo, User: testUser, OurCommitOid: setup.ours, TheirCommitOid: setup.theirs, SourceBranch: []byte(theirBranch), CommitMessage: message},
+			code:   codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := resolveConflicts(t, client, tc.header, nil)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+
+	t.Run("empty header", func(t *testing.T) {
+		_, err := resolveConflicts(t, client, nil, []resolvedFile{{path: "README.md", content: "Resolved\n"}})
+		testhelper.AssertGrpcError(t, err, codes.InvalidArgument, "")
+	})
+}
+
+func TestFailedResolveConflictsRequestDueToHooks(t *testing.T) {
+	server := runConflictsServer(t)
+	defer server.Stop()
+
+	client, conn := newConflictsClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupConflicts(t, testRepoPath)
+
+	cleanupHooks := testhelper.SetupGitlabShellHooks(t, map[string]string{"pre-receive": "#!/bin/sh\necho 'GitLab: You are not allowed to push' >&2\nexit 1\n"})
+	defer cleanupHooks()
+
+	response, err := resolveConflicts(t, client, &pb.ResolveConflictsRequestHeader{
+		Repository:     testRepo,
+		User:           testUser,
+		OurCommitOid:   setup.ours,
+		TheirCommitOid: setup.theirs,
+		SourceBranch:   []byte(ourBranch),
+		CommitMessage:  []byte("Resolve conflicts"),
+	}, []resolvedFile{
+		{path: "README.md", sections: []pb.ResolveConflictsFileHeader_Side{ours}},
+		{path: "numbers.txt", sections: []pb.ResolveConflictsFileHeader_Side{theirs}},
+	})
+	require.NoError(t, err)
+	require.Empty(t, response.CommitId)
+	require.Equal(t, "GitLab: You are not allowed to push\n", response.PreReceiveError)
+	require.Equal(t, setup.ours, testhelper.RevParse(t, testRepoPath, ourBranch))
+}
@@ -0,0 +1,12 @@
+package conflicts
+
+import pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+type server struct{}
+
+var maxMsgSize = 1024 * 128 // 128 KiB
+
+// NewServer creates a new instance of a grpc ConflictsServiceServer
+func NewServer() pb.ConflictsServiceServer {
+	return &server{}
+}
@@ -0,0 +1,77 @@
+package conflicts
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+var (
+	serverSocketPath = testhelper.GetTemporaryGitalySocketFileName()
+	testUser         = &pb.User{GlId: "user-123", Name: []byte("Jane Doe"), Email: []byte("janedoe@example.com")}
+)
+
+func runConflictsServer(t *testing.T) *grpc.Server {
+	server := testhelper.NewTestGrpcServer(t, nil, nil)
+	listener, err := net.Listen("unix", serverSocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pb.RegisterConflictsServiceServer(server, NewServer())
+	reflection.Register(server)
+
+	go server.Serve(listener)
+
+	return server
+}
+
+func newConflictsClient(t *testing.T) (pb.ConflictsServiceClient, *grpc.ClientConn) {
+	connOpts := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, _ time.Duration) (net.Conn, error) {
+			return net.Dial("unix", addr)
+		}),
+	}
+	conn, err := grpc.Dial(serverSocketPath, connOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pb.NewConflictsServiceClient(conn), conn
+}
+
+// conflictsSetup are two branches forked from a common base, with
+// conflicting changes to two files and other changes that merge cleanly.
+type conflictsSetup struct {
+	base, ours, theirs string
+}
+
+const (
+	ourBranch   = "gitaly-conflicts-ours"
+	theirBranch = "gitaly-conflicts-theirs"
+)
+
+func setupConflicts(t *testing.T, repoPath string) conflictsSetup {
+	var setup conflictsSetup
+	setup.base = testhelper.CreateCommit(t, repoPath, "gitaly-conflicts-base", "", map[string]string{
+		"numbers.txt": "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+		"README.md":   "Conflicts\n",
+	})
+	setup.ours = testhelper.CreateCommit(t, repoPath, ourBranch, setup.base, map[string]string{
+		"numbers.txt": "one\n2\n3\n4\n5\n6\n7\n8\n9\n",
+		"README.md":   "Our conflicts\n",
+	})
+	setup.theirs = testhelper.CreateCommit(t, repoPath, theirBranch, setup.base, map[string]string{
+		"numbers.txt": "uno\n2\n3\n4\n5\n6\n7\n8\nnueve\n",
+		"README.md":   "Their conflicts\n",
+		"theirs.txt":  "theirs\n",
+	})
+
+	return setup
+}
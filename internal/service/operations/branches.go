@@ -0,0 +1,12 @@
+package operations
+
+import (
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+
+	"golang.org/x/net/context"
+)
+
+func (s *server) UserCreateBranch(ctx context.Context, req *pb.UserCreateBranchRequest) (*pb.UserCreateBranchResponse, error) {
+	return nil, helper.Unimplemented
+}
@@ -0,0 +1,244 @@
+package operations
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/hooks"
+	"gitlab.com/gitlab-org/gitaly/internal/git/index"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+	"gitlab.com/gitlab-org/gitaly/internal/tempdir"
+)
+
+// The tree git uses as the parent of root commits
+const emptyTreeID = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+func (s *server) UserCherryPick(ctx context.Context, in *pb.UserCherryPickRequest) (*pb.UserCherryPickResponse, error) {
+	result, err := applyCommit(ctx, "UserCherryPick", applyCommitRequest{
+		repo:            in.GetRepository(),
+		user:            in.GetUser(),
+		commitID:        in.GetCommitId(),
+		branchName:      in.GetBranchName(),
+		message:         in.GetMessage(),
+		startBranchName: in.GetStartBranchName(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.UserCherryPickResponse{
+		BranchUpdate:    result.branchUpdate,
+		CreateTreeError: result.createTreeError,
+		PreReceiveError: result.preReceiveError,
+	}, nil
+}
+
+func (s *server) UserRevert(ctx context.Context, in *pb.UserRevertRequest) (*pb.UserRevertResponse, error) {
+	result, err := applyCommit(ctx, "UserRevert", applyCommitRequest{
+		repo:            in.GetRepository(),
+		user:            in.GetUser(),
+		commitID:        in.GetCommitId(),
+		branchName:      in.GetBranchName(),
+		message:         in.GetMessage(),
+		startBranchName: in.GetStartBranchName(),
+		revert:          true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.UserRevertResponse{
+		BranchUpdate:    result.branchUpdate,
+		CreateTreeError: result.createTreeError,
+		PreReceiveError: result.preReceiveError,
+	}, nil
+}
+
+type applyCommitRequest struct {
+	repo            *pb.Repository
+	user            *pb.User
+	commitID        string
+	branchName      []byte
+	message         []byte
+	startBranchName []byte
+	// Apply the inverse of the changes of the commit
+	revert bool
+}
+
+type applyCommitResult struct {
+	branchUpdate    *pb.OperationBranchUpdate
+	createTreeError *pb.CreateTreeError
+	preReceiveError string
+}
+
+// applyCommit applies the changes of a commit, or their inverse, onto a
+// branch with a new commit. Errors are prefixed with rpcName.
+func applyCommit(ctx context.Context, rpcName string, req applyCommitRequest) (*applyCommitResult, error) {
+	if err := validateApplyCommitRequest(req); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s: %v", rpcName, err)
+	}
+
+	repoPath, err := helper.GetRepoPath(req.repo)
+	if err != nil {
+		return nil, err
+	}
+
+	branch := "refs/heads/" + string(req.branchName)
+	oldrev, startCommit, err := resolveBranch(ctx, repoPath, branch, req.startBranchName)
+	if err == errStartBranchNotFound {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "%s: %v", rpcName, err)
+	} else if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+	if startCommit == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "%s: branch not found", rpcName)
+	}
+
+	commitID, err := git.ResolveCommit(ctx, repoPath, req.commitID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+	if commitID == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "%s: commit not found", rpcName)
+	}
+
+	info, err := readCommitInfo(ctx, repoPath, commitID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+
+	tempDir, err := tempdir.New(ctx, req.repo)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+
+	// A cherry-pick merges the commit into the branch with the parent of
+	// the commit as base. A revert does the same with the two swapped.
+	base, theirs := info.firstParent, commitID
+	if req.revert {
+		base, theirs = commitID, info.firstParent
+	}
+
+	idx := index.New(repoPath, tempDir)
+	conflicts, err := idx.Merge(ctx, base, startCommit, theirs)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+
+	if len(conflicts) > 0 {
+		return &applyCommitResult{createTreeError: conflictError(conflicts)}, nil
+	}
+
+	tree, err := idx.WriteTree(ctx)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+
+	startTree, err := git.ResolveRevision(ctx, repoPath, startCommit+"^{tree}")
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+	if tree == startTree {
+		return &applyCommitResult{createTreeError: &pb.CreateTreeError{Code: pb.CreateTreeError_EMPTY}}, nil
+	}
+
+	// Like git cherry-pick, keep the original author of cherry-picked commits
+	committer := git.UserSignature(req.user)
+	author := committer
+	if !req.revert {
+		author = info.author
+	}
+
+	newCommitID, err := git.CommitTree(ctx, repoPath, author, committer, tree, req.message, startCommit)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "%s: %v", rpcName, err)
+	}
+
+	hookErr, err := hooks.RPCError(rpcName, hooks.UpdateReference(ctx, req.repo, req.user, branch, newCommitID, oldrev))
+	if err != nil {
+		return nil, err
+	}
+	if hookErr != nil {
+		return &applyCommitResult{preReceiveError: hookErr.Message()}, nil
+	}
+
+	return &applyCommitResult{
+		branchUpdate: &pb.OperationBranchUpdate{
+			CommitId:      newCommitID,
+			BranchCreated: oldrev == git.NullSha,
+		},
+	}, nil
+}
+
+func conflictError(conflicts []index.Conflict) *pb.CreateTreeError {
+	treeError := &pb.CreateTreeError{Code: pb.CreateTreeError_CONFLICT}
+	for _, conflict := range conflicts {
+		treeError.ConflictPaths = append(treeError.ConflictPaths, []byte(conflict.Path))
+	}
+
+	return treeError
+}
+
+func validateApplyCommitRequest(req applyCommitRequest) error {
+	if req.user == nil {
+		return fmt.Errorf("empty user")
+	}
+
+	if req.commitID == "" {
+		return fmt.Errorf("empty commit ID")
+	}
+
+	if len(req.branchName) == 0 {
+		return fmt.Errorf("empty branch name")
+	}
+
+	if len(req.message) == 0 {
+		return fmt.Errorf("empty message")
+	}
+
+	return nil
+}
+
+type commitInfo struct {
+	// The empty tree for root commits
+	firstParent string
+	author      git.Signature
+}
+
+func readCommitInfo(ctx context.Context, repoPath, commitID string) (*commitInfo, error) {
+	var stdout bytes.Buffer
+
+	args := []string{"--git-dir", repoPath, "log", "-1", "--date=raw", "--format=%P%x00%an%x00%ae%x00%ad", commitID}
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), args...), nil, &stdout, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("log: %v", err)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\x00")
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("invalid commit info: %q", stdout.String())
+	}
+
+	info := &commitInfo{
+		firstParent: emptyTreeID,
+		author:      git.Signature{Name: []byte(fields[1]), Email: []byte(fields[2]), Date: fields[3]},
+	}
+	if parents := strings.Fields(fields[0]); len(parents) > 0 {
+		info.firstParent = parents[0]
+	}
+
+	return info, nil
+}
@@ -0,0 +1,313 @@
+package operations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+const applyCommitBranchName = "gitaly-apply-commit-test"
+
+type applyCommitSetup struct {
+	base, target, source, conflicting string
+}
+
+// setupApplyCommit creates a branch whose last commit changes the first line
+// of a file, a commit changing the last line on another branch and a commit
+// changing the first line differently.
+func setupApplyCommit(t *testing.T, repoPath string) applyCommitSetup {
+	lines := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+
+	var setup applyCommitSetup
+	setup.base = testhelper.CreateCommit(t, repoPath, "gitaly-apply-commit-base", "", map[string]string{"numbers.txt": lines})
+	setup.target = testhelper.CreateCommit(t, repoPath, applyCommitBranchName, setup.base, map[string]string{"numbers.txt": "one\n" + lines[2:]})
+	setup.source = testhelper.CreateCommit(t, repoPath, "gitaly-apply-commit-source", setup.base, map[string]string{
+		"numbers.txt": lines[:len(lines)-2] + "nine\n",
+		"source.txt":  "source",
+	})
+	setup.conflicting = testhelper.CreateCommit(t, repoPath, "gitaly-apply-commit-conflicting", setup.base, map[string]string{"numbers.txt": "uno\n" + lines[2:]})
+
+	return setup
+}
+
+func TestSuccessfulUserCherryPickRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	request := &pb.UserCherryPickRequest{
+		Repository: testRepo,
+		User:       testUser,
+		CommitId:   setup.source,
+		BranchName: []byte(applyCommitBranchName),
+		Message:    []byte("Cherry-pick source"),
+	}
+
+	response, err := client.UserCherryPick(ctx, request)
+	require.NoError(t, err)
+	require.Nil(t, response.CreateTreeError)
+	require.Empty(t, response.PreReceiveError)
+
+	commitID := response.BranchUpdate.CommitId
+	require.Equal(t, &pb.OperationBranchUpdate{CommitId: commitID}, response.BranchUpdate)
+	require.Equal(t, commitID, testhelper.RevParse(t, testRepoPath, applyCommitBranchName))
+	require.Equal(t, setup.target, testhelper.RevParse(t, testRepoPath, commitID+"^"))
+
+	require.Equal(t, "one\n2\n3\n4\n5\n6\n7\n8\nnine\n", testhelper.ShowFile(t, testRepoPath, commitID, "numbers.txt"))
+	require.Equal(t, "source", testhelper.ShowFile(t, testRepoPath, commitID, "source.txt"))
+
+	format := "--format=%an <%ae> %ad%n%cn <%ce>%n%B"
+	sourceInfo := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "log", "-1", "--format=%an <%ae> %ad", setup.source))
+	commitInfo := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "log", "-1", format, commitID))
+	require.Equal(t, sourceInfo+"Jane Doe <janedoe@example.com>\nCherry-pick source\n", commitInfo)
+
+	// Applying the same changes again would create an empty commit
+	response, err = client.UserCherryPick(ctx, request)
+	require.NoError(t, err)
+	require.Nil(t, response.BranchUpdate)
+	require.Equal(t, &pb.CreateTreeError{Code: pb.CreateTreeError_EMPTY}, response.CreateTreeError)
+	require.Equal(t, commitID, testhelper.RevParse(t, testRepoPath, applyCommitBranchName))
+}
+
+func TestSuccessfulUserCherryPickRequestCreatingBranch(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.UserCherryPick(ctx, &pb.UserCherryPickRequest{
+		Repository:      testRepo,
+		User:            testUser,
+		CommitId:        setup.source,
+		BranchName:      []byte("gitaly-apply-commit-new"),
+		Message:         []byte("Cherry-pick source"),
+		StartBranchName: []byte(applyCommitBranchName),
+	})
+	require.NoError(t, err)
+
+	commitID := response.BranchUpdate.CommitId
+	require.Equal(t, &pb.OperationBranchUpdate{CommitId: commitID, BranchCreated: true}, response.BranchUpdate)
+	require.Equal(t, commitID, testhelper.RevParse(t, testRepoPath, "gitaly-apply-commit-new"))
+	require.Equal(t, setup.target, testhelper.RevParse(t, testRepoPath, commitID+"^"))
+	require.Equal(t, setup.target, testhelper.RevParse(t, testRepoPath, applyCommitBranchName))
+}
+
+func TestSuccessfulUserRevertRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	request := &pb.UserRevertRequest{
+		Repository: testRepo,
+		User:       testUser,
+		CommitId:   setup.target,
+		BranchName: []byte(applyCommitBranchName),
+		Message:    []byte("Revert target"),
+	}
+
+	response, err := client.UserRevert(ctx, request)
+	require.NoError(t, err)
+	require.Nil(t, response.CreateTreeError)
+	require.Empty(t, response.PreReceiveError)
+
+	commitID := response.BranchUpdate.CommitId
+	require.Equal(t, &pb.OperationBranchUpdate{CommitId: commitID}, response.BranchUpdate)
+	require.Equal(t, commitID, testhelper.RevParse(t, testRepoPath, applyCommitBranchName))
+	require.Equal(t, setup.target, testhelper.RevParse(t, testRepoPath, commitID+"^"))
+	require.Equal(t, testhelper.RevParse(t, testRepoPath, setup.base+"^{tree}"), testhelper.RevParse(t, testRepoPath, commitID+"^{tree}"))
+
+	commitInfo := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "log", "-1", "--format=%an <%ae>%n%cn <%ce>%n%B", commitID))
+	require.Equal(t, "Jane Doe <janedoe@example.com>\nJane Doe <janedoe@example.com>\nRevert target\n", commitInfo)
+
+	// The changes were reverted already
+	response, err = client.UserRevert(ctx, request)
+	require.NoError(t, err)
+	require.Nil(t, response.BranchUpdate)
+	require.Equal(t, &pb.CreateTreeError{Code: pb.CreateTreeError_EMPTY}, response.CreateTreeError)
+}
+
+func TestFailedApplyCommitRequestDueToConflict(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+	expectedError := &pb.CreateTreeError{Code: pb.CreateTreeError_CONFLICT, ConflictPaths: [][]byte{[]byte("numbers.txt")}}
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	cherryPickResponse, err := client.UserCherryPick(ctx, &pb.UserCherryPickRequest{
+		Repository: testRepo,
+		User:       testUser,
+		CommitId:   setup.conflicting,
+		BranchName: []byte(applyCommitBranchName),
+		Message:    []byte("Cherry-pick"),
+	})
+	require.NoError(t, err)
+	require.Nil(t, cherryPickResponse.BranchUpdate)
+	require.Equal(t, expectedError, cherryPickResponse.CreateTreeError)
+
+	// Reverting the first line of the base commit conflicts with its change
+	// on the branch
+	revertBase := testhelper.CreateCommit(t, testRepoPath, "gitaly-apply-commit-revert", setup.base, map[string]string{"numbers.txt": "uno\n2\n3\n4\n5\n6\n7\n8\n9\n"})
+	revertResponse, err := client.UserRevert(ctx, &pb.UserRevertRequest{
+		Repository: testRepo,
+		User:       testUser,
+		CommitId:   revertBase,
+		BranchName: []byte(applyCommitBranchName),
+		Message:    []byte("Revert"),
+	})
+	require.NoError(t, err)
+	require.Nil(t, revertResponse.BranchUpdate)
+	require.Equal(t, expectedError, revertResponse.CreateTreeError)
+
+	require.Equal(t, setup.target, testhelper.RevParse(t, testRepoPath, applyCommitBranchName))
+}
+
+func TestFailedApplyCommitRequestDueToHooks(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+
+	cleanupHooks := testhelper.SetupGitlabShellHooks(t, map[string]string{"pre-receive": "#!/bin/sh\necho 'GitLab: You are not allowed to push'\nexit 1\n"})
+	defer cleanupHooks()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	cherryPickResponse, err := client.UserCherryPick(ctx, &pb.UserCherryPickRequest{
+		Repository: testRepo,
+		User:       testUser,
+		CommitId:   setup.source,
+		BranchName: []byte(applyCommitBranchName),
+		Message:    []byte("Cherry-pick"),
+	})
+	require.NoError(t, err)
+	require.Nil(t, cherryPickResponse.BranchUpdate)
+	require.Contains(t, cherryPickResponse.PreReceiveError, "You are not allowed to push")
+
+	revertResponse, err := client.UserRevert(ctx, &pb.UserRevertRequest{
+		Repository: testRepo,
+		User:       testUser,
+		CommitId:   setup.target,
+		BranchName: []byte(applyCommitBranchName),
+		Message:    []byte("Revert"),
+	})
+	require.NoError(t, err)
+	require.Nil(t, revertResponse.BranchUpdate)
+	require.Contains(t, revertResponse.PreReceiveError, "You are not allowed to push")
+
+	require.Equal(t, setup.target, testhelper.RevParse(t, testRepoPath, applyCommitBranchName))
+}
+
+func TestFailedUserCherryPickRequestDueToValidations(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+
+	testCases := []struct {
+		desc    string
+		request *pb.UserCherryPickRequest
+		code    codes.Code
+	}{
+		{
+			desc:    "empty user",
+			request: &pb.UserCherryPickRequest{Repository: testRepo, CommitId: setup.source, BranchName: []byte(applyCommitBranchName), Message: []byte("Cherry-pick")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty commit",
+			request: &pb.UserCherryPickRequest{Repository: testRepo, User: testUser, BranchName: []byte(applyCommitBranchName), Message: []byte("Cherry-pick")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty branch name",
+			request: &pb.UserCherryPickRequest{Repository: testRepo, User: testUser, CommitId: setup.source, Message: []byte("Cherry-pick")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty message",
+			request: &pb.UserCherryPickRequest{Repository: testRepo, User: testUser, CommitId: setup.source, BranchName: []byte(applyCommitBranchName)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "non-existing commit",
+			request: &pb.UserCherryPickRequest{Repository: testRepo, User: testUser, CommitId: strings.Repeat("1", 40), BranchName: []byte(applyCommitBranchName), Message: []byte("Cherry-pick")},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "non-existing branch",
+			request: &pb.UserCherryPickRequest{Repository: testRepo, User: testUser, CommitId: setup.source, BranchName: []byte("does-not-exist"), Message: []byte("Cherry-pick")},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "non-existing start branch",
+			request: &pb.UserCherryPickRequest{Repository: testRepo, User: testUser, CommitId: setup.source, BranchName: []byte("does-not-exist"), Message: []byte("Cherry-pick"), StartBranchName: []byte("does-not-exist")},
+			code:    codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			_, err := client.UserCherryPick(ctx, tc.request)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+}
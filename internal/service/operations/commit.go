@@ -0,0 +1,39 @@
+package operations
+
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+)
+
+var errStartBranchNotFound = errors.New("start branch not found")
+
+// resolveBranch returns the current value of branch, or git.NullSha if it
+// doesn't exist, and the commit to build new commits of the branch on: the
+// branch itself or, for a new branch, startBranchName. The start commit is
+// empty for a new branch without a start branch.
+func resolveBranch(ctx context.Context, repoPath, branch string, startBranchName []byte) (string, string, error) {
+	oldrev, err := git.ResolveCommit(ctx, repoPath, branch)
+	if err != nil {
+		return "", "", err
+	}
+	if oldrev != "" {
+		return oldrev, oldrev, nil
+	}
+
+	if len(startBranchName) == 0 {
+		return git.NullSha, "", nil
+	}
+
+	startCommit, err := git.ResolveCommit(ctx, repoPath, "refs/heads/"+string(startBranchName))
+	if err != nil {
+		return "", "", err
+	}
+	if startCommit == "" {
+		return "", "", errStartBranchNotFound
+	}
+
+	return git.NullSha, startCommit, nil
+}
@@ -0,0 +1,415 @@
+package operations
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/hooks"
+	"gitlab.com/gitlab-org/gitaly/internal/git/index"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+	"gitlab.com/gitlab-org/gitaly/internal/tempdir"
+)
+
+// indexError means that an action can't be applied to the tree, e.g.
+// because the file to update doesn't exist. It is reported to the user.
+type indexError string
+
+func (e indexError) Error() string {
+	return string(e)
+}
+
+// UserCommitFiles creates a commit on a branch from a stream of actions on
+// its files. The tree is built in a temporary index so no worktree is
+// needed.
+func (s *server) UserCommitFiles(stream pb.OperationService_UserCommitFilesServer) error {
+	firstRequest, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	header := firstRequest.GetHeader()
+	if err := validateCommitFilesHeader(header); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "UserCommitFiles: %v", err)
+	}
+
+	ctx := stream.Context()
+	repo := header.GetRepository()
+	repoPath, err := helper.GetRepoPath(repo)
+	if err != nil {
+		return err
+	}
+
+	branch := "refs/heads/" + string(header.GetBranchName())
+	oldrev, parent, err := resolveBranch(ctx, repoPath, branch, header.GetStartBranchName())
+	if err == errStartBranchNotFound {
+		return grpc.Errorf(codes.FailedPrecondition, "UserCommitFiles: %v", err)
+	} else if err != nil {
+		return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+	}
+
+	repoCreated := false
+	if oldrev == git.NullSha {
+		if repoCreated, err = hasNoBranches(ctx, repoPath); err != nil {
+			return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+		}
+	}
+
+	tempDir, err := tempdir.New(ctx, repo)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+	}
+
+	idx := index.New(repoPath, tempDir)
+	if parent != "" {
+		if err := idx.ReadTree(ctx, parent); err != nil {
+			return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+		}
+	}
+
+	err = applyCommitFilesActions(ctx, stream, idx, tempDir)
+	switch err.(type) {
+	case nil:
+	case indexError:
+		return stream.SendAndClose(&pb.UserCommitFilesResponse{IndexError: err.Error()})
+	default:
+		return err
+	}
+
+	tree, err := idx.WriteTree(ctx)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+	}
+
+	committer := git.UserSignature(header.GetUser())
+	author := committer
+	if len(header.GetCommitAuthorName()) > 0 && len(header.GetCommitAuthorEmail()) > 0 {
+		author = git.Signature{Name: header.GetCommitAuthorName(), Email: header.GetCommitAuthorEmail()}
+	}
+
+	var parents []string
+	if parent != "" {
+		parents = append(parents, parent)
+	}
+
+	commitID, err := git.CommitTree(ctx, repoPath, author, committer, tree, header.GetCommitMessage(), parents...)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+	}
+
+	hookErr, err := hooks.RPCError("UserCommitFiles", hooks.UpdateReference(ctx, repo, header.GetUser(), branch, commitID, oldrev))
+	if err != nil {
+		return err
+	}
+	if hookErr != nil {
+		return stream.SendAndClose(&pb.UserCommitFilesResponse{PreReceiveError: hookErr.Message()})
+	}
+
+	return stream.SendAndClose(&pb.UserCommitFilesResponse{
+		BranchUpdate: &pb.OperationBranchUpdate{
+			CommitId:      commitID,
+			RepoCreated:   repoCreated,
+			BranchCreated: oldrev == git.NullSha,
+		},
+	})
+}
+
+func validateCommitFilesHeader(header *pb.UserCommitFilesRequestHeader) error {
+	if header == nil {
+		return fmt.Errorf("empty header")
+	}
+
+	if header.GetUser() == nil {
+		return fmt.Errorf("empty user")
+	}
+
+	if len(header.GetBranchName()) == 0 {
+		return fmt.Errorf("empty branch name")
+	}
+
+	if len(header.GetCommitMessage()) == 0 {
+		return fmt.Errorf("empty commit message")
+	}
+
+	return nil
+}
+
+func hasNoBranches(ctx context.Context, repoPath string) (bool, error) {
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "for-each-ref", "--count=1", "--format=%(refname)", "refs/heads/")
+	if err != nil {
+		return false, err
+	}
+
+	out, err := ioutil.ReadAll(cmd)
+	if err != nil {
+		return false, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return false, err
+	}
+
+	return len(out) == 0, nil
+}
+
+// applyCommitFilesActions reads the actions from the stream and applies
+// them to the index in order. The content of each action is buffered in a
+// file in tempDir until the next action starts.
+func applyCommitFilesActions(ctx context.Context, stream pb.OperationService_UserCommitFilesServer, idx *index.Index, tempDir string) error {
+	var header *pb.UserCommitFilesActionHeader
+	var content *os.File
+
+	contentPath := path.Join(tempDir, "action-content")
+	defer func() {
+		if content != nil {
+			content.Close()
+		}
+	}()
+
+	for {
+		request, err := stream.Recv()
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if err == io.EOF || request.GetAction().GetHeader() != nil {
+			if header != nil {
+				if _, err := content.Seek(0, io.SeekStart); err != nil {
+					return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+				}
+
+				if err := applyCommitFilesAction(ctx, idx, header, content); err != nil {
+					return err
+				}
+
+				content.Close()
+				content = nil
+			}
+
+			if err == io.EOF {
+				return nil
+			}
+
+			header = request.GetAction().GetHeader()
+			if content, err = os.Create(contentPath); err != nil {
+				return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+			}
+		}
+
+		if header == nil {
+			return grpc.Errorf(codes.InvalidArgument, "UserCommitFiles: expected an action header")
+		}
+
+		if _, err := content.Write(request.GetAction().GetContent()); err != nil {
+			return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+		}
+	}
+}
+
+func applyCommitFilesAction(ctx context.Context, idx *index.Index, header *pb.UserCommitFilesActionHeader, content io.Reader) error {
+	if !header.GetBase64Content() {
+		return applyAction(ctx, idx, header, content)
+	}
+
+	decoder := &base64Decoder{r: base64.NewDecoder(base64.StdEncoding, content)}
+	err := applyAction(ctx, idx, header, decoder)
+	if err != nil && decoder.err != nil {
+		return indexError(fmt.Sprintf("Invalid base64 content: %s", header.GetFilePath()))
+	}
+
+	return err
+}
+
+// base64Decoder keeps the error of the decoder, which would otherwise only
+// show up as a failing git command.
+type base64Decoder struct {
+	r   io.Reader
+	err error
+}
+
+func (d *base64Decoder) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		d.err = err
+	}
+
+	return n, err
+}
+
+func applyAction(ctx context.Context, idx *index.Index, header *pb.UserCommitFilesActionHeader, content io.Reader) error {
+	filePath, err := validateFilePath(header.GetFilePath())
+	if err != nil {
+		return err
+	}
+
+	switch header.GetAction() {
+	case pb.UserCommitFilesActionHeader_CREATE:
+		if err := checkPathIsFree(ctx, idx, filePath); err != nil {
+			return err
+		}
+
+		mode := index.RegularFileMode
+		if header.GetExecuteFilemode() {
+			mode = index.ExecutableFileMode
+		}
+
+		return addBlob(ctx, idx, filePath, mode, content)
+	case pb.UserCommitFilesActionHeader_UPDATE:
+		entry, err := existingEntry(ctx, idx, filePath)
+		if err != nil {
+			return err
+		}
+
+		return addBlob(ctx, idx, filePath, entry.Mode, content)
+	case pb.UserCommitFilesActionHeader_MOVE:
+		previousPath, err := validateFilePath(header.GetPreviousPath())
+		if err != nil {
+			return err
+		}
+
+		entry, err := existingEntry(ctx, idx, previousPath)
+		if err != nil {
+			return err
+		}
+
+		if err := idx.Remove(ctx, previousPath); err != nil {
+			return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+		}
+
+		if err := checkPathIsFree(ctx, idx, filePath); err != nil {
+			return err
+		}
+
+		if header.GetInferContent() {
+			return addEntry(ctx, idx, filePath, *entry)
+		}
+
+		return addBlob(ctx, idx, filePath, entry.Mode, content)
+	case pb.UserCommitFilesActionHeader_DELETE:
+		if _, err := existingEntry(ctx, idx, filePath); err != nil {
+			return err
+		}
+
+		if err := idx.Remove(ctx, filePath); err != nil {
+			return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+		}
+
+		return nil
+	case pb.UserCommitFilesActionHeader_CHMOD:
+		entry, err := existingEntry(ctx, idx, filePath)
+		if err != nil {
+			return err
+		}
+
+		entry.Mode = index.RegularFileMode
+		if header.GetExecuteFilemode() {
+			entry.Mode = index.ExecutableFileMode
+		}
+
+		return addEntry(ctx, idx, filePath, *entry)
+	default:
+		return grpc.Errorf(codes.InvalidArgument, "UserCommitFiles: unknown action %v", header.GetAction())
+	}
+}
+
+// validateFilePath returns the path without redundant slashes, or an
+// indexError if it is not a valid path for a file in a tree.
+func validateFilePath(filePath []byte) (string, error) {
+	p := strings.Trim(string(filePath), "/")
+	if p == "" {
+		return "", indexError("You must provide a file path")
+	}
+
+	for _, component := range strings.Split(p, "/") {
+		switch component {
+		case "":
+			return "", indexError(fmt.Sprintf("Invalid path: %s", filePath))
+		case ".", "..":
+			return "", indexError(fmt.Sprintf("Path cannot include directory traversal: %s", filePath))
+		}
+
+		if strings.ToLower(component) == ".git" {
+			return "", indexError(fmt.Sprintf("Invalid path: %s", filePath))
+		}
+	}
+
+	if strings.ContainsRune(p, 0) {
+		return "", indexError(fmt.Sprintf("Invalid path: %s", filePath))
+	}
+
+	return p, nil
+}
+
+func existingEntry(ctx context.Context, idx *index.Index, filePath string) (*index.Entry, error) {
+	entry, err := idx.Entry(ctx, filePath)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+	}
+
+	if entry == nil {
+		return nil, indexError(fmt.Sprintf("A file with this name doesn't exist: %s", filePath))
+	}
+
+	return entry, nil
+}
+
+// checkPathIsFree returns an indexError if a file can't be added at
+// filePath, because a file or directory exists there or one of its parent
+// directories is a file.
+func checkPathIsFree(ctx context.Context, idx *index.Index, filePath string) error {
+	entry, err := idx.Entry(ctx, filePath)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+	}
+	if entry != nil {
+		return indexError(fmt.Sprintf("A file with this name already exists: %s", filePath))
+	}
+
+	isDir, err := idx.IsDirectory(ctx, filePath)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+	}
+	if isDir {
+		return indexError(fmt.Sprintf("A directory with this name already exists: %s", filePath))
+	}
+
+	for dir := path.Dir(filePath); dir != "."; dir = path.Dir(dir) {
+		entry, err := idx.Entry(ctx, dir)
+		if err != nil {
+			return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+		}
+		if entry != nil {
+			return indexError(fmt.Sprintf("A file with this name already exists: %s", dir))
+		}
+	}
+
+	return nil
+}
+
+func addBlob(ctx context.Context, idx *index.Index, filePath, mode string, content io.Reader) error {
+	oid, err := idx.WriteBlob(ctx, content)
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+	}
+
+	return addEntry(ctx, idx, filePath, index.Entry{Mode: mode, Oid: oid})
+}
+
+func addEntry(ctx context.Context, idx *index.Index, filePath string, entry index.Entry) error {
+	if err := idx.Add(ctx, filePath, entry); err != nil {
+		return grpc.Errorf(codes.Internal, "UserCommitFiles: %v", err)
+	}
+
+	return nil
+}
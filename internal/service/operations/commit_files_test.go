@@ -0,0 +1,426 @@
+package operations
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+const commitFilesBranchName = "gitaly-commit-files-test"
+
+func commitFilesHeader(repo *pb.Repository, branchName string) *pb.UserCommitFilesRequest {
+	return &pb.UserCommitFilesRequest{
+		Header: &pb.UserCommitFilesRequestHeader{
+			Repository:    repo,
+			User:          testUser,
+			BranchName:    []byte(branchName),
+			CommitMessage: []byte("Commit files"),
+		},
+	}
+}
+
+func actionHeader(action pb.UserCommitFilesActionHeader_ActionType, filePath string) *pb.UserCommitFilesActionHeader {
+	return &pb.UserCommitFilesActionHeader{Action: action, FilePath: []byte(filePath)}
+}
+
+func actionRequests(header *pb.UserCommitFilesActionHeader, content ...string) []*pb.UserCommitFilesRequest {
+	requests := []*pb.UserCommitFilesRequest{
+		{Action: &pb.UserCommitFilesAction{Header: header}},
+	}
+
+	for _, chunk := range content {
+		requests = append(requests, &pb.UserCommitFilesRequest{Action: &pb.UserCommitFilesAction{Content: []byte(chunk)}})
+	}
+
+	return requests
+}
+
+func sendCommitFiles(t *testing.T, client pb.OperationServiceClient, requests []*pb.UserCommitFilesRequest) (*pb.UserCommitFilesResponse, error) {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.UserCommitFiles(ctx)
+	require.NoError(t, err)
+
+	for _, request := range requests {
+		// The server closes the stream early if a request is invalid
+		if err := stream.Send(request); err == io.EOF {
+			break
+		} else {
+			require.NoError(t, err)
+		}
+	}
+
+	return stream.CloseAndRecv()
+}
+
+// lsTree lists the mode and path of all files in the tree of revision
+func lsTree(t *testing.T, repoPath, revision string) string {
+	output := testhelper.MustRunCommand(t, nil, "git", "--git-dir", repoPath, "ls-tree", "-r", revision)
+
+	var files []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		// <mode> SP <type> SP <oid> TAB <path>
+		split := strings.SplitN(line, "\t", 2)
+		files = append(files, strings.Fields(split[0])[0]+" "+split[1])
+	}
+
+	return strings.Join(files, "\n") + "\n"
+}
+
+func TestSuccessfulUserCommitFilesRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	parent := testhelper.CreateCommit(t, testRepoPath, commitFilesBranchName, "", map[string]string{
+		"README.md":       "readme",
+		"CHANGELOG":       "changelog",
+		"docs/install.md": "install",
+		"docs/old.md":     "old",
+		"script.sh":       "#!/bin/sh",
+	})
+
+	moveHeader := actionHeader(pb.UserCommitFilesActionHeader_MOVE, "docs/moved.md")
+	moveHeader.PreviousPath = []byte("docs/old.md")
+	moveHeader.InferContent = true
+
+	moveWithContentHeader := actionHeader(pb.UserCommitFilesActionHeader_MOVE, "INSTALL.md")
+	moveWithContentHeader.PreviousPath = []byte("docs/install.md")
+
+	createExecutableHeader := actionHeader(pb.UserCommitFilesActionHeader_CREATE, "bin/run")
+	createExecutableHeader.ExecuteFilemode = true
+
+	chmodHeader := actionHeader(pb.UserCommitFilesActionHeader_CHMOD, "script.sh")
+	chmodHeader.ExecuteFilemode = true
+
+	base64Header := actionHeader(pb.UserCommitFilesActionHeader_CREATE, "encoded.txt")
+	base64Header.Base64Content = true
+
+	requests := []*pb.UserCommitFilesRequest{commitFilesHeader(testRepo, commitFilesBranchName)}
+	requests = append(requests, actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, "new/file.txt"), "split ", "across ", "messages")...)
+	requests = append(requests, actionRequests(actionHeader(pb.UserCommitFilesActionHeader_UPDATE, "README.md"), "updated readme")...)
+	requests = append(requests, actionRequests(actionHeader(pb.UserCommitFilesActionHeader_DELETE, "CHANGELOG"))...)
+	requests = append(requests, actionRequests(moveHeader)...)
+	requests = append(requests, actionRequests(moveWithContentHeader, "moved install")...)
+	requests = append(requests, actionRequests(createExecutableHeader, "#!/bin/sh")...)
+	requests = append(requests, actionRequests(chmodHeader)...)
+	requests = append(requests, actionRequests(base64Header, base64.StdEncoding.EncodeToString([]byte("decoded content")))...)
+
+	response, err := sendCommitFiles(t, client, requests)
+	require.NoError(t, err)
+	require.Empty(t, response.IndexError)
+	require.Empty(t, response.PreReceiveError)
+
+	commitID := response.BranchUpdate.CommitId
+	require.Equal(t, &pb.OperationBranchUpdate{CommitId: commitID}, response.BranchUpdate)
+	require.Equal(t, commitID, testhelper.RevParse(t, testRepoPath, commitFilesBranchName))
+	require.Equal(t, parent, testhelper.RevParse(t, testRepoPath, commitID+"^"))
+
+	expectedTree := strings.Join([]string{
+		"100644 INSTALL.md",
+		"100644 README.md",
+		"100755 bin/run",
+		"100644 docs/moved.md",
+		"100644 encoded.txt",
+		"100644 new/file.txt",
+		"100755 script.sh",
+	}, "\n") + "\n"
+	require.Equal(t, expectedTree, lsTree(t, testRepoPath, commitID))
+
+	expectedContents := map[string]string{
+		"INSTALL.md":    "moved install",
+		"README.md":     "updated readme",
+		"docs/moved.md": "old",
+		"encoded.txt":   "decoded content",
+		"new/file.txt":  "split across messages",
+		"script.sh":     "#!/bin/sh",
+	}
+	for filePath, content := range expectedContents {
+		blob := testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "show", commitID+":"+filePath)
+		require.Equal(t, content, string(blob), filePath)
+	}
+
+	commitInfo := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "log", "-1", "--format=%an <%ae>%n%cn <%ce>%n%B", commitID))
+	require.Equal(t, "Jane Doe <janedoe@example.com>\nJane Doe <janedoe@example.com>\nCommit files\n", commitInfo)
+}
+
+func TestSuccessfulUserCommitFilesRequestCreatingBranch(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	startCommit := testhelper.CreateCommit(t, testRepoPath, "gitaly-commit-files-start", "", map[string]string{"README.md": "readme"})
+
+	testCases := []struct {
+		desc            string
+		branchName      string
+		startBranchName string
+		expectedParent  string
+		expectedTree    string
+	}{
+		{
+			desc:            "from a start branch",
+			branchName:      "gitaly-commit-files-from-start",
+			startBranchName: "gitaly-commit-files-start",
+			expectedParent:  startCommit,
+			expectedTree:    "100644 README.md\n100644 new.txt\n",
+		},
+		{
+			desc:         "orphan branch",
+			branchName:   "gitaly-commit-files-orphan",
+			expectedTree: "100644 new.txt\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			header := commitFilesHeader(testRepo, tc.branchName)
+			header.Header.StartBranchName = []byte(tc.startBranchName)
+			header.Header.CommitAuthorName = []byte("Scrooge McDuck")
+			header.Header.CommitAuthorEmail = []byte("scrooge@mcduck.com")
+
+			requests := append([]*pb.UserCommitFilesRequest{header}, actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, "new.txt"), "new")...)
+			response, err := sendCommitFiles(t, client, requests)
+			require.NoError(t, err)
+
+			commitID := response.BranchUpdate.CommitId
+			require.Equal(t, &pb.OperationBranchUpdate{CommitId: commitID, BranchCreated: true}, response.BranchUpdate)
+			require.Equal(t, commitID, testhelper.RevParse(t, testRepoPath, tc.branchName))
+			require.Equal(t, tc.expectedTree, lsTree(t, testRepoPath, commitID))
+
+			parents := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "log", "-1", "--format=%P", commitID))
+			require.Equal(t, tc.expectedParent, strings.TrimSpace(parents))
+
+			authors := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "log", "-1", "--format=%an <%ae>%n%cn <%ce>", commitID))
+			require.Equal(t, "Scrooge McDuck <scrooge@mcduck.com>\nJane Doe <janedoe@example.com>\n", authors)
+		})
+	}
+}
+
+func TestSuccessfulUserCommitFilesRequestInEmptyRepository(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	repoPath, err := ioutil.TempDir(testhelper.GitlabTestStoragePath(), "empty-repo-")
+	require.NoError(t, err)
+	defer os.RemoveAll(repoPath)
+
+	testhelper.MustRunCommand(t, nil, "git", "init", "--bare", "--quiet", repoPath)
+	repo := &pb.Repository{StorageName: testhelper.TestRepository().StorageName, RelativePath: filepath.Base(repoPath)}
+
+	requests := append([]*pb.UserCommitFilesRequest{commitFilesHeader(repo, "master")}, actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, "README.md"), "readme")...)
+	response, err := sendCommitFiles(t, client, requests)
+	require.NoError(t, err)
+
+	commitID := response.BranchUpdate.CommitId
+	require.Equal(t, &pb.OperationBranchUpdate{CommitId: commitID, RepoCreated: true, BranchCreated: true}, response.BranchUpdate)
+	require.Equal(t, "100644 README.md\n", lsTree(t, repoPath, "master"))
+}
+
+func TestFailedUserCommitFilesRequestDueToIndexError(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	parent := testhelper.CreateCommit(t, testRepoPath, commitFilesBranchName, "", map[string]string{
+		"README.md":       "readme",
+		"docs/install.md": "install",
+	})
+
+	moveMissingHeader := actionHeader(pb.UserCommitFilesActionHeader_MOVE, "moved.md")
+	moveMissingHeader.PreviousPath = []byte("does-not-exist.md")
+
+	invalidBase64Header := actionHeader(pb.UserCommitFilesActionHeader_CREATE, "encoded.txt")
+	invalidBase64Header.Base64Content = true
+
+	testCases := []struct {
+		desc          string
+		requests      []*pb.UserCommitFilesRequest
+		expectedError string
+	}{
+		{
+			desc:          "create existing file",
+			requests:      actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, "README.md"), "readme"),
+			expectedError: "A file with this name already exists: README.md",
+		},
+		{
+			desc:          "create file over directory",
+			requests:      actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, "docs"), "docs"),
+			expectedError: "A directory with this name already exists: docs",
+		},
+		{
+			desc:          "create file below file",
+			requests:      actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, "README.md/file"), "file"),
+			expectedError: "A file with this name already exists: README.md",
+		},
+		{
+			desc:          "update missing file",
+			requests:      actionRequests(actionHeader(pb.UserCommitFilesActionHeader_UPDATE, "does-not-exist.md"), "content"),
+			expectedError: "A file with this name doesn't exist: does-not-exist.md",
+		},
+		{
+			desc:          "delete missing file",
+			requests:      actionRequests(actionHeader(pb.UserCommitFilesActionHeader_DELETE, "does-not-exist.md")),
+			expectedError: "A file with this name doesn't exist: does-not-exist.md",
+		},
+		{
+			desc:          "move missing file",
+			requests:      actionRequests(moveMissingHeader),
+			expectedError: "A file with this name doesn't exist: does-not-exist.md",
+		},
+		{
+			desc:          "directory traversal",
+			requests:      actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, "docs/../../file"), "content"),
+			expectedError: "Path cannot include directory traversal: docs/../../file",
+		},
+		{
+			desc:          "path in .git",
+			requests:      actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, ".git/config"), "content"),
+			expectedError: "Invalid path: .git/config",
+		},
+		{
+			desc:          "empty path",
+			requests:      actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, ""), "content"),
+			expectedError: "You must provide a file path",
+		},
+		{
+			desc:          "invalid base64",
+			requests:      actionRequests(invalidBase64Header, "not base64!"),
+			expectedError: "Invalid base64 content: encoded.txt",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			requests := append([]*pb.UserCommitFilesRequest{commitFilesHeader(testRepo, commitFilesBranchName)}, tc.requests...)
+			response, err := sendCommitFiles(t, client, requests)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedError, response.IndexError)
+			require.Nil(t, response.BranchUpdate)
+
+			require.Equal(t, parent, testhelper.RevParse(t, testRepoPath, commitFilesBranchName))
+		})
+	}
+}
+
+func TestFailedUserCommitFilesRequestDueToHooks(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	parent := testhelper.CreateCommit(t, testRepoPath, commitFilesBranchName, "", map[string]string{"README.md": "readme"})
+
+	cleanupHooks := testhelper.SetupGitlabShellHooks(t, map[string]string{"pre-receive": "#!/bin/sh\necho 'GitLab: You are not allowed to push'\nexit 1\n"})
+	defer cleanupHooks()
+
+	requests := append([]*pb.UserCommitFilesRequest{commitFilesHeader(testRepo, commitFilesBranchName)}, actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, "new.txt"), "new")...)
+	response, err := sendCommitFiles(t, client, requests)
+	require.NoError(t, err)
+	require.Nil(t, response.BranchUpdate)
+	require.Contains(t, response.PreReceiveError, "You are not allowed to push")
+
+	require.Equal(t, parent, testhelper.RevParse(t, testRepoPath, commitFilesBranchName))
+}
+
+func TestFailedUserCommitFilesRequestDueToValidations(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	testhelper.CreateCommit(t, testRepoPath, commitFilesBranchName, "", map[string]string{"README.md": "readme"})
+
+	noUser := commitFilesHeader(testRepo, commitFilesBranchName)
+	noUser.Header.User = nil
+
+	noMessage := commitFilesHeader(testRepo, commitFilesBranchName)
+	noMessage.Header.CommitMessage = nil
+
+	missingStartBranch := commitFilesHeader(testRepo, "gitaly-commit-files-new")
+	missingStartBranch.Header.StartBranchName = []byte("does-not-exist")
+
+	testCases := []struct {
+		desc     string
+		requests []*pb.UserCommitFilesRequest
+		code     codes.Code
+	}{
+		{
+			desc:     "no header",
+			requests: actionRequests(actionHeader(pb.UserCommitFilesActionHeader_CREATE, "new.txt"), "new"),
+			code:     codes.InvalidArgument,
+		},
+		{
+			desc:     "empty user",
+			requests: []*pb.UserCommitFilesRequest{noUser},
+			code:     codes.InvalidArgument,
+		},
+		{
+			desc:     "empty branch name",
+			requests: []*pb.UserCommitFilesRequest{commitFilesHeader(testRepo, "")},
+			code:     codes.InvalidArgument,
+		},
+		{
+			desc:     "empty commit message",
+			requests: []*pb.UserCommitFilesRequest{noMessage},
+			code:     codes.InvalidArgument,
+		},
+		{
+			desc: "content without action header",
+			requests: []*pb.UserCommitFilesRequest{
+				commitFilesHeader(testRepo, commitFilesBranchName),
+				{Action: &pb.UserCommitFilesAction{Content: []byte("content")}},
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			desc:     "non-existing start branch",
+			requests: []*pb.UserCommitFilesRequest{missingStartBranch},
+			code:     codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := sendCommitFiles(t, client, tc.requests)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+}
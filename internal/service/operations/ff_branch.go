@@ -0,0 +1,67 @@
+package operations
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/hooks"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+func (s *server) UserFFBranch(ctx context.Context, in *pb.UserFFBranchRequest) (*pb.UserFFBranchResponse, error) {
+	if err := validateFFBranchRequest(in); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "UserFFBranch: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	branch := "refs/heads/" + string(in.GetBranch())
+	revision, commitID, err := resolveMergeCommits(ctx, repoPath, branch, in.GetCommitId())
+	if err != nil {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserFFBranch: %v", err)
+	}
+
+	fastForward, err := git.IsAncestor(ctx, repoPath, revision, commitID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserFFBranch: %v", err)
+	}
+	if !fastForward {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserFFBranch: not fast forward")
+	}
+
+	hookErr, err := hooks.RPCError("UserFFBranch", hooks.UpdateReference(ctx, in.GetRepository(), in.GetUser(), branch, commitID, revision))
+	if err != nil {
+		return nil, err
+	}
+	if hookErr != nil {
+		return &pb.UserFFBranchResponse{PreReceiveError: hookErr.Message()}, nil
+	}
+
+	return &pb.UserFFBranchResponse{
+		BranchUpdate: &pb.OperationBranchUpdate{CommitId: commitID},
+	}, nil
+}
+
+func validateFFBranchRequest(in *pb.UserFFBranchRequest) error {
+	if in.GetUser() == nil {
+		return fmt.Errorf("empty user")
+	}
+
+	if len(in.GetBranch()) == 0 {
+		return fmt.Errorf("empty branch name")
+	}
+
+	if in.GetCommitId() == "" {
+		return fmt.Errorf("empty commit ID")
+	}
+
+	return nil
+}
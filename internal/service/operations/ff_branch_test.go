@@ -0,0 +1,132 @@
+package operations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+const ffBranchName = "gitaly-ff-test-branch"
+
+func TestSuccessfulUserFFBranchRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	base := testhelper.CreateCommit(t, testRepoPath, ffBranchName, "", map[string]string{"README": "base"})
+	commitID := testhelper.CreateCommit(t, testRepoPath, "gitaly-ff-test-source", base, map[string]string{"README": "next"})
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.UserFFBranch(ctx, &pb.UserFFBranchRequest{
+		Repository: testRepo,
+		User:       testUser,
+		CommitId:   commitID,
+		Branch:     []byte(ffBranchName),
+	})
+	require.NoError(t, err)
+	require.Equal(t, &pb.UserFFBranchResponse{BranchUpdate: &pb.OperationBranchUpdate{CommitId: commitID}}, response)
+
+	require.Equal(t, commitID, testhelper.RevParse(t, testRepoPath, ffBranchName))
+}
+
+func TestFailedUserFFBranchRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	base := testhelper.CreateCommit(t, testRepoPath, "gitaly-ff-test-base", "", map[string]string{"README": "base"})
+	target := testhelper.CreateCommit(t, testRepoPath, ffBranchName, base, map[string]string{"README": "target"})
+	diverged := testhelper.CreateCommit(t, testRepoPath, "gitaly-ff-test-diverged", base, map[string]string{"README": "diverged"})
+
+	testCases := []struct {
+		desc    string
+		request *pb.UserFFBranchRequest
+		code    codes.Code
+	}{
+		{
+			desc:    "empty user",
+			request: &pb.UserFFBranchRequest{Repository: testRepo, CommitId: diverged, Branch: []byte(ffBranchName)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty branch",
+			request: &pb.UserFFBranchRequest{Repository: testRepo, User: testUser, CommitId: diverged},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty commit",
+			request: &pb.UserFFBranchRequest{Repository: testRepo, User: testUser, Branch: []byte(ffBranchName)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "non-existing branch",
+			request: &pb.UserFFBranchRequest{Repository: testRepo, User: testUser, CommitId: diverged, Branch: []byte("does-not-exist")},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "not fast forward",
+			request: &pb.UserFFBranchRequest{Repository: testRepo, User: testUser, CommitId: diverged, Branch: []byte(ffBranchName)},
+			code:    codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			_, err := client.UserFFBranch(ctx, tc.request)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+
+			require.Equal(t, target, testhelper.RevParse(t, testRepoPath, ffBranchName))
+		})
+	}
+}
+
+func TestFailedUserFFBranchRequestDueToHooks(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	base := testhelper.CreateCommit(t, testRepoPath, ffBranchName, "", map[string]string{"README": "base"})
+	commitID := testhelper.CreateCommit(t, testRepoPath, "gitaly-ff-test-source", base, map[string]string{"README": "next"})
+
+	cleanupHooks := testhelper.SetupGitlabShellHooks(t, map[string]string{"pre-receive": "#!/bin/sh\necho 'GitLab: You are not allowed to push'\nexit 1\n"})
+	defer cleanupHooks()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.UserFFBranch(ctx, &pb.UserFFBranchRequest{
+		Repository: testRepo,
+		User:       testUser,
+		CommitId:   commitID,
+		Branch:     []byte(ffBranchName),
+	})
+	require.NoError(t, err)
+	require.Nil(t, response.BranchUpdate)
+	require.Contains(t, response.PreReceiveError, "You are not allowed to push")
+
+	require.Equal(t, base, testhelper.RevParse(t, testRepoPath, ffBranchName))
+}
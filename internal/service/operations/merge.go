@@ -0,0 +1,154 @@
+package operations
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/hooks"
+	"gitlab.com/gitlab-org/gitaly/internal/git/index"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+	"gitlab.com/gitlab-org/gitaly/internal/tempdir"
+)
+
+// UserMergeBranch merges a commit into a branch in two steps. The merge
+// commit is created from the first request and its ID is sent back, but the
+// branch is only updated once the client confirms with apply set in the
+// second request.
+func (s *server) UserMergeBranch(stream pb.OperationService_UserMergeBranchServer) error {
+	firstRequest, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	if err := validateMergeBranchRequest(firstRequest); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "UserMergeBranch: %v", err)
+	}
+
+	ctx := stream.Context()
+	repo := firstRequest.GetRepository()
+	repoPath, err := helper.GetRepoPath(repo)
+	if err != nil {
+		return err
+	}
+
+	branch := "refs/heads/" + string(firstRequest.GetBranch())
+	revision, commitID, err := resolveMergeCommits(ctx, repoPath, branch, firstRequest.GetCommitId())
+	if err != nil {
+		return grpc.Errorf(codes.FailedPrecondition, "UserMergeBranch: %v", err)
+	}
+
+	mergeCommitID, err := createMergeCommit(ctx, repo, repoPath, firstRequest.GetUser(), revision, commitID, firstRequest.GetMessage())
+	if err != nil {
+		return err
+	}
+
+	if err := stream.Send(&pb.UserMergeBranchResponse{CommitId: mergeCommitID}); err != nil {
+		return err
+	}
+
+	secondRequest, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	if !secondRequest.GetApply() {
+		return grpc.Errorf(codes.FailedPrecondition, "UserMergeBranch: merge aborted by client")
+	}
+
+	hookErr, err := hooks.RPCError("UserMergeBranch", hooks.UpdateReference(ctx, repo, firstRequest.GetUser(), branch, mergeCommitID, revision))
+	if err != nil {
+		return err
+	}
+	if hookErr != nil {
+		return stream.Send(&pb.UserMergeBranchResponse{PreReceiveError: hookErr.Message()})
+	}
+
+	return stream.Send(&pb.UserMergeBranchResponse{
+		BranchUpdate: &pb.OperationBranchUpdate{CommitId: mergeCommitID},
+	})
+}
+
+func validateMergeBranchRequest(in *pb.UserMergeBranchRequest) error {
+	if in.GetUser() == nil {
+		return fmt.Errorf("empty user")
+	}
+
+	if len(in.GetBranch()) == 0 {
+		return fmt.Errorf("empty branch name")
+	}
+
+	if in.GetCommitId() == "" {
+		return fmt.Errorf("empty commit ID")
+	}
+
+	if len(in.GetMessage()) == 0 {
+		return fmt.Errorf("empty message")
+	}
+
+	return nil
+}
+
+// resolveMergeCommits returns the commit IDs of the branch and of the
+// commit to merge into it.
+func resolveMergeCommits(ctx context.Context, repoPath, branch, commit string) (string, string, error) {
+	revision, err := git.ResolveCommit(ctx, repoPath, branch)
+	if err != nil {
+		return "", "", err
+	}
+	if revision == "" {
+		return "", "", fmt.Errorf("branch not found")
+	}
+
+	commitID, err := git.ResolveCommit(ctx, repoPath, commit)
+	if err != nil {
+		return "", "", err
+	}
+	if commitID == "" {
+		return "", "", fmt.Errorf("commit not found")
+	}
+
+	return revision, commitID, nil
+}
+
+// createMergeCommit merges theirs into ours without a worktree and returns
+// the ID of the merge commit. No reference is updated.
+func createMergeCommit(ctx context.Context, repo *pb.Repository, repoPath string, user *pb.User, ours, theirs string, message []byte) (string, error) {
+	base, err := git.MergeBase(ctx, repoPath, ours, theirs)
+	if err != nil {
+		return "", grpc.Errorf(codes.Internal, "UserMergeBranch: %v", err)
+	}
+	if base == "" {
+		return "", grpc.Errorf(codes.FailedPrecondition, "UserMergeBranch: no merge base")
+	}
+
+	tempDir, err := tempdir.New(ctx, repo)
+	if err != nil {
+		return "", grpc.Errorf(codes.Internal, "UserMergeBranch: %v", err)
+	}
+
+	idx := index.New(repoPath, tempDir)
+	conflicts, err := idx.Merge(ctx, base, ours, theirs)
+	if err != nil {
+		return "", grpc.Errorf(codes.Internal, "UserMergeBranch: %v", err)
+	}
+	if len(conflicts) > 0 {
+		return "", grpc.Errorf(codes.FailedPrecondition, "UserMergeBranch: merge conflict in %s", conflicts[0].Path)
+	}
+
+	tree, err := idx.WriteTree(ctx)
+	if err != nil {
+		return "", grpc.Errorf(codes.Internal, "UserMergeBranch: %v", err)
+	}
+
+	commitID, err := git.CommitTree(ctx, repoPath, git.UserSignature(user), git.UserSignature(user), tree, message, ours, theirs)
+	if err != nil {
+		return "", grpc.Errorf(codes.Internal, "UserMergeBranch: %v", err)
+	}
+
+	return commitID, nil
+}
@@ -0,0 +1,311 @@
+package operations
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+const mergeBranchName = "gitaly-merge-test-branch"
+
+// setupMergeBranches creates a target branch and a commit to merge into it.
+// Both change a copy of the same file, at the beginning and at the end.
+func setupMergeBranches(t *testing.T, repoPath string, targetChange, sourceChange string) (string, string) {
+	lines := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	base := testhelper.CreateCommit(t, repoPath, "gitaly-merge-test-base", "", map[string]string{"numbers.txt": lines})
+
+	target := testhelper.CreateCommit(t, repoPath, mergeBranchName, base, map[string]string{
+		"numbers.txt": targetChange + lines[2:],
+		"target.txt":  "target",
+	})
+	source := testhelper.CreateCommit(t, repoPath, "gitaly-merge-test-source", base, map[string]string{
+		"numbers.txt": lines[:len(lines)-2] + sourceChange,
+		"source.txt":  "source",
+	})
+
+	return target, source
+}
+
+func TestSuccessfulUserMergeBranchRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+	testRepo.GlRepository = "project-1"
+
+	target, source := setupMergeBranches(t, testRepoPath, "one\n", "nine\n")
+
+	hookOutputFile, err := ioutil.TempFile("", "gitaly-hook-output")
+	require.NoError(t, err)
+	hookOutputFile.Close()
+	defer os.Remove(hookOutputFile.Name())
+
+	script := "#!/bin/sh\necho \"$GL_ID $GL_REPOSITORY $GL_PROTOCOL\" >>" + hookOutputFile.Name() + "\n"
+	cleanupHooks := testhelper.SetupGitlabShellHooks(t, map[string]string{"pre-receive": script, "post-receive": script})
+	defer cleanupHooks()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.UserMergeBranch(ctx)
+	require.NoError(t, err)
+
+	message := "Merge into " + mergeBranchName
+	require.NoError(t, stream.Send(&pb.UserMergeBranchRequest{
+		Repository: testRepo,
+		User:       testUser,
+		CommitId:   source,
+		Branch:     []byte(mergeBranchName),
+		Message:    []byte(message),
+	}))
+
+	firstResponse, err := stream.Recv()
+	require.NoError(t, err)
+	mergeCommitID := firstResponse.CommitId
+	require.NotEmpty(t, mergeCommitID)
+
+	// The branch is not touched before the client confirms
+	require.Equal(t, target, testhelper.RevParse(t, testRepoPath, mergeBranchName))
+
+	require.NoError(t, stream.Send(&pb.UserMergeBranchRequest{Apply: true}))
+
+	secondResponse, err := stream.Recv()
+	require.NoError(t, err)
+	require.Empty(t, secondResponse.PreReceiveError)
+	require.Equal(t, &pb.OperationBranchUpdate{CommitId: mergeCommitID}, secondResponse.BranchUpdate)
+
+	require.Equal(t, mergeCommitID, testhelper.RevParse(t, testRepoPath, mergeBranchName))
+	require.Equal(t, target+"\n"+source, testhelper.RevParse(t, testRepoPath, mergeCommitID+"^@"))
+
+	commitInfo := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "log", "-1", "--format=%an <%ae>%n%cn <%ce>%n%B", mergeCommitID))
+	require.Equal(t, "Jane Doe <janedoe@example.com>\nJane Doe <janedoe@example.com>\n"+message+"\n", commitInfo)
+
+	numbers := testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "show", mergeCommitID+":numbers.txt")
+	require.Equal(t, "one\n2\n3\n4\n5\n6\n7\n8\nnine\n", string(numbers))
+	for _, file := range []string{"target.txt", "source.txt"} {
+		testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "cat-file", "-e", mergeCommitID+":"+file)
+	}
+
+	hookOutput := testhelper.MustReadFile(t, hookOutputFile.Name())
+	require.Equal(t, "user-123 project-1 web\nuser-123 project-1 web\n", string(hookOutput))
+}
+
+func TestAbortedUserMergeBranchRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	target, source := setupMergeBranches(t, testRepoPath, "one\n", "nine\n")
+
+	testCases := []struct {
+		desc       string
+		moveBranch bool
+		apply      bool
+		code       codes.Code
+	}{
+		{desc: "aborted by client", apply: false, code: codes.FailedPrecondition},
+		{desc: "branch moved concurrently", moveBranch: true, apply: true, code: codes.Aborted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", "refs/heads/"+mergeBranchName, target)
+
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			stream, err := client.UserMergeBranch(ctx)
+			require.NoError(t, err)
+
+			require.NoError(t, stream.Send(&pb.UserMergeBranchRequest{
+				Repository: testRepo,
+				User:       testUser,
+				CommitId:   source,
+				Branch:     []byte(mergeBranchName),
+				Message:    []byte("Merge"),
+			}))
+
+			_, err = stream.Recv()
+			require.NoError(t, err)
+
+			expectedBranch := target
+			if tc.moveBranch {
+				expectedBranch = testhelper.CreateCommit(t, testRepoPath, mergeBranchName, target, map[string]string{"concurrent.txt": "push"})
+			}
+
+			require.NoError(t, stream.Send(&pb.UserMergeBranchRequest{Apply: tc.apply}))
+
+			_, err = stream.Recv()
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+
+			require.Equal(t, expectedBranch, testhelper.RevParse(t, testRepoPath, mergeBranchName))
+		})
+	}
+}
+
+func TestFailedUserMergeBranchRequestDueToConflict(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	// Both sides change the first line of numbers.txt
+	lines := "1\n2\n3\n"
+	base := testhelper.CreateCommit(t, testRepoPath, "gitaly-merge-test-base", "", map[string]string{"numbers.txt": lines})
+	target := testhelper.CreateCommit(t, testRepoPath, mergeBranchName, base, map[string]string{"numbers.txt": "one\n" + lines[2:]})
+	source := testhelper.CreateCommit(t, testRepoPath, "gitaly-merge-test-source", base, map[string]string{"numbers.txt": "uno\n" + lines[2:]})
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.UserMergeBranch(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(&pb.UserMergeBranchRequest{
+		Repository: testRepo,
+		User:       testUser,
+		CommitId:   source,
+		Branch:     []byte(mergeBranchName),
+		Message:    []byte("Merge"),
+	}))
+
+	_, err = stream.Recv()
+	testhelper.AssertGrpcError(t, err, codes.FailedPrecondition, "numbers.txt")
+
+	require.Equal(t, target, testhelper.RevParse(t, testRepoPath, mergeBranchName))
+}
+
+func TestFailedUserMergeBranchRequestDueToHooks(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	target, source := setupMergeBranches(t, testRepoPath, "one\n", "nine\n")
+
+	for _, hookName := range []string{"pre-receive", "update"} {
+		t.Run(hookName, func(t *testing.T) {
+			cleanupHooks := testhelper.SetupGitlabShellHooks(t, map[string]string{hookName: "#!/bin/sh\necho 'GitLab: You are not allowed to push'\nexit 1\n"})
+			defer cleanupHooks()
+
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			stream, err := client.UserMergeBranch(ctx)
+			require.NoError(t, err)
+
+			require.NoError(t, stream.Send(&pb.UserMergeBranchRequest{
+				Repository: testRepo,
+				User:       testUser,
+				CommitId:   source,
+				Branch:     []byte(mergeBranchName),
+				Message:    []byte("Merge"),
+			}))
+
+			_, err = stream.Recv()
+			require.NoError(t, err)
+
+			require.NoError(t, stream.Send(&pb.UserMergeBranchRequest{Apply: true}))
+
+			response, err := stream.Recv()
+			require.NoError(t, err)
+			require.Nil(t, response.BranchUpdate)
+			require.Contains(t, response.PreReceiveError, "You are not allowed to push")
+
+			require.Equal(t, target, testhelper.RevParse(t, testRepoPath, mergeBranchName))
+		})
+	}
+}
+
+func TestFailedUserMergeBranchRequestDueToValidations(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	_, source := setupMergeBranches(t, testRepoPath, "one\n", "nine\n")
+
+	testCases := []struct {
+		desc    string
+		request *pb.UserMergeBranchRequest
+		code    codes.Code
+	}{
+		{
+			desc:    "empty user",
+			request: &pb.UserMergeBranchRequest{Repository: testRepo, CommitId: source, Branch: []byte(mergeBranchName), Message: []byte("Merge")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty branch",
+			request: &pb.UserMergeBranchRequest{Repository: testRepo, User: testUser, CommitId: source, Message: []byte("Merge")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty commit",
+			request: &pb.UserMergeBranchRequest{Repository: testRepo, User: testUser, Branch: []byte(mergeBranchName), Message: []byte("Merge")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty message",
+			request: &pb.UserMergeBranchRequest{Repository: testRepo, User: testUser, CommitId: source, Branch: []byte(mergeBranchName)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "non-existing branch",
+			request: &pb.UserMergeBranchRequest{Repository: testRepo, User: testUser, CommitId: source, Branch: []byte("does-not-exist"), Message: []byte("Merge")},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "non-existing commit",
+			request: &pb.UserMergeBranchRequest{Repository: testRepo, User: testUser, CommitId: strings.Repeat("1", 40), Branch: []byte(mergeBranchName), Message: []byte("Merge")},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "non-existing repository",
+			request: &pb.UserMergeBranchRequest{Repository: &pb.Repository{StorageName: testRepo.StorageName, RelativePath: "does-not-exist.git"}, User: testUser, CommitId: source, Branch: []byte(mergeBranchName), Message: []byte("Merge")},
+			code:    codes.NotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			stream, err := client.UserMergeBranch(ctx)
+			require.NoError(t, err)
+			require.NoError(t, stream.Send(tc.request))
+
+			_, err = stream.Recv()
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+}
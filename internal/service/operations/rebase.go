@@ -0,0 +1,162 @@
+package operations
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/hooks"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+	"gitlab.com/gitlab-org/gitaly/internal/tempdir"
+)
+
+// UserRebase rebases a branch onto a target branch. The rebase runs in a
+// temporary worktree, which git registers in $GIT_DIR/worktrees/ until it is
+// removed. Apart from that and the new objects, the repository is only
+// changed by the final update of the branch.
+func (s *server) UserRebase(ctx context.Context, in *pb.UserRebaseRequest) (*pb.UserRebaseResponse, error) {
+	if err := validateRebaseRequest(in); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "UserRebase: %v", err)
+	}
+
+	repo := in.GetRepository()
+	repoPath, err := helper.GetRepoPath(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	branch := "refs/heads/" + string(in.GetBranch())
+	oldrev, err := git.ResolveCommit(ctx, repoPath, branch)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserRebase: %v", err)
+	}
+	if oldrev == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserRebase: branch not found")
+	}
+	if oldrev != in.GetBranchSha() {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserRebase: branch has moved to %s", oldrev)
+	}
+
+	target, err := git.ResolveCommit(ctx, repoPath, "refs/heads/"+string(in.GetTargetBranch()))
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserRebase: %v", err)
+	}
+	if target == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserRebase: target branch not found")
+	}
+
+	tempDir, err := tempdir.New(ctx, repo)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserRebase: %v", err)
+	}
+
+	worktreePath := path.Join(tempDir, "rebase")
+	if _, err := runGit(ctx, repoPath, nil, "worktree", "add", "--detach", worktreePath, oldrev); err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserRebase: %v", err)
+	}
+	defer removeWorktree(ctx, repoPath, worktreePath)
+
+	committer := git.UserSignature(in.GetUser()).CommitterEnv()
+	if _, rebaseErr := runGit(ctx, worktreePath, committer, "rebase", target); rebaseErr != nil {
+		conflicts, err := runGit(ctx, worktreePath, nil, "diff", "--name-only", "--diff-filter=U", "-z")
+		if err != nil {
+			return nil, grpc.Errorf(codes.Internal, "UserRebase: %v", err)
+		}
+		if len(conflicts) == 0 {
+			return nil, grpc.Errorf(codes.Internal, "UserRebase: %v", rebaseErr)
+		}
+
+		treeError := &pb.CreateTreeError{Code: pb.CreateTreeError_CONFLICT}
+		for _, conflict := range bytes.Split(bytes.TrimSuffix(conflicts, []byte{0}), []byte{0}) {
+			treeError.ConflictPaths = append(treeError.ConflictPaths, conflict)
+		}
+
+		return &pb.UserRebaseResponse{CreateTreeError: treeError}, nil
+	}
+
+	head, err := runGit(ctx, worktreePath, nil, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserRebase: %v", err)
+	}
+
+	newrev := strings.TrimSpace(string(head))
+	if newrev == oldrev {
+		return &pb.UserRebaseResponse{BranchUpdate: &pb.OperationBranchUpdate{CommitId: newrev}}, nil
+	}
+
+	hookErr, err := hooks.RPCError("UserRebase", hooks.UpdateReference(ctx, repo, in.GetUser(), branch, newrev, oldrev))
+	if err != nil {
+		return nil, err
+	}
+	if hookErr != nil {
+		return &pb.UserRebaseResponse{PreReceiveError: hookErr.Message()}, nil
+	}
+
+	return &pb.UserRebaseResponse{BranchUpdate: &pb.OperationBranchUpdate{CommitId: newrev}}, nil
+}
+
+func validateRebaseRequest(in *pb.UserRebaseRequest) error {
+	if in.GetUser() == nil {
+		return fmt.Errorf("empty user")
+	}
+
+	if len(in.GetBranch()) == 0 {
+		return fmt.Errorf("empty branch name")
+	}
+
+	if in.GetBranchSha() == "" {
+		return fmt.Errorf("empty branch SHA")
+	}
+
+	if len(in.GetTargetBranch()) == 0 {
+		return fmt.Errorf("empty target branch name")
+	}
+
+	return nil
+}
+
+// runGit runs git in dir and returns its standard output. The standard
+// error is part of the returned error.
+func runGit(ctx context.Context, dir string, env []string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(command.GitPath(), args...)
+	cmd.Dir = dir
+
+	git, err := command.New(ctx, cmd, nil, &stdout, &stderr, env...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := git.Wait(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// removeWorktree deletes a worktree and its administrative files in the
+// repository. It doesn't use ctx for git, which may be cancelled already.
+func removeWorktree(ctx context.Context, repoPath, worktreePath string) {
+	logger := grpc_logrus.Extract(ctx).WithField("worktree", worktreePath)
+
+	if err := os.RemoveAll(worktreePath); err != nil {
+		logger.WithError(err).Warn("failed to remove worktree")
+		return
+	}
+
+	if _, err := runGit(context.Background(), repoPath, nil, "worktree", "prune"); err != nil {
+		logger.WithError(err).Warn("failed to prune worktrees")
+	}
+}
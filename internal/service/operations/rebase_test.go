@@ -0,0 +1,218 @@
+package operations
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+const rebaseBranchName = "gitaly-apply-commit-source"
+
+func requireNoWorktrees(t *testing.T, repoPath string) {
+	worktrees, err := ioutil.ReadDir(path.Join(repoPath, "worktrees"))
+	if err == nil {
+		require.Empty(t, worktrees)
+	}
+}
+
+func TestSuccessfulUserRebaseRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+	branchSha := testhelper.CreateCommit(t, testRepoPath, rebaseBranchName, setup.source, map[string]string{"other.txt": "other"})
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.UserRebase(ctx, &pb.UserRebaseRequest{
+		Repository:   testRepo,
+		User:         testUser,
+		Branch:       []byte(rebaseBranchName),
+		BranchSha:    branchSha,
+		TargetBranch: []byte(applyCommitBranchName),
+	})
+	require.NoError(t, err)
+	require.Nil(t, response.CreateTreeError)
+	require.Empty(t, response.PreReceiveError)
+
+	commitID := response.BranchUpdate.CommitId
+	require.Equal(t, &pb.OperationBranchUpdate{CommitId: commitID}, response.BranchUpdate)
+	require.Equal(t, commitID, testhelper.RevParse(t, testRepoPath, rebaseBranchName))
+	require.Equal(t, setup.target, testhelper.RevParse(t, testRepoPath, commitID+"~2"))
+	require.Equal(t, "one\n2\n3\n4\n5\n6\n7\n8\nnine\n", testhelper.ShowFile(t, testRepoPath, commitID, "numbers.txt"))
+
+	commitInfo := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "log", "-2", "--format=%an <%ae> %cn <%ce>", commitID))
+	expectedInfo := "Scrooge McDuck <scrooge@mcduck.com> Jane Doe <janedoe@example.com>\n"
+	require.Equal(t, expectedInfo+expectedInfo, commitInfo)
+
+	requireNoWorktrees(t, testRepoPath)
+}
+
+func TestSuccessfulUserRebaseRequestUpToDate(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.UserRebase(ctx, &pb.UserRebaseRequest{
+		Repository:   testRepo,
+		User:         testUser,
+		Branch:       []byte(applyCommitBranchName),
+		BranchSha:    setup.target,
+		TargetBranch: []byte("gitaly-apply-commit-base"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, &pb.OperationBranchUpdate{CommitId: setup.target}, response.BranchUpdate)
+	require.Equal(t, setup.target, testhelper.RevParse(t, testRepoPath, applyCommitBranchName))
+}
+
+func TestFailedUserRebaseRequestDueToConflict(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.UserRebase(ctx, &pb.UserRebaseRequest{
+		Repository:   testRepo,
+		User:         testUser,
+		Branch:       []byte("gitaly-apply-commit-conflicting"),
+		BranchSha:    setup.conflicting,
+		TargetBranch: []byte(applyCommitBranchName),
+	})
+	require.NoError(t, err)
+	require.Nil(t, response.BranchUpdate)
+	require.Equal(t, &pb.CreateTreeError{Code: pb.CreateTreeError_CONFLICT, ConflictPaths: [][]byte{[]byte("numbers.txt")}}, response.CreateTreeError)
+	require.Equal(t, setup.conflicting, testhelper.RevParse(t, testRepoPath, "gitaly-apply-commit-conflicting"))
+
+	requireNoWorktrees(t, testRepoPath)
+}
+
+func TestFailedUserRebaseRequestDueToHooks(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+
+	cleanupHooks := testhelper.SetupGitlabShellHooks(t, map[string]string{"update": "#!/bin/sh\necho 'GitLab: You are not allowed to push'\nexit 1\n"})
+	defer cleanupHooks()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.UserRebase(ctx, &pb.UserRebaseRequest{
+		Repository:   testRepo,
+		User:         testUser,
+		Branch:       []byte(rebaseBranchName),
+		BranchSha:    setup.source,
+		TargetBranch: []byte(applyCommitBranchName),
+	})
+	require.NoError(t, err)
+	require.Nil(t, response.BranchUpdate)
+	require.Contains(t, response.PreReceiveError, "You are not allowed to push")
+	require.Equal(t, setup.source, testhelper.RevParse(t, testRepoPath, rebaseBranchName))
+}
+
+func TestFailedUserRebaseRequestDueToValidations(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+	branch := []byte(rebaseBranchName)
+	target := []byte(applyCommitBranchName)
+
+	testCases := []struct {
+		desc    string
+		request *pb.UserRebaseRequest
+		code    codes.Code
+	}{
+		{
+			desc:    "empty user",
+			request: &pb.UserRebaseRequest{Repository: testRepo, Branch: branch, BranchSha: setup.source, TargetBranch: target},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty branch",
+			request: &pb.UserRebaseRequest{Repository: testRepo, User: testUser, BranchSha: setup.source, TargetBranch: target},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty branch SHA",
+			request: &pb.UserRebaseRequest{Repository: testRepo, User: testUser, Branch: branch, TargetBranch: target},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty target branch",
+			request: &pb.UserRebaseRequest{Repository: testRepo, User: testUser, Branch: branch, BranchSha: setup.source},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "non-existing branch",
+			request: &pb.UserRebaseRequest{Repository: testRepo, User: testUser, Branch: []byte("does-not-exist"), BranchSha: setup.source, TargetBranch: target},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "branch has moved",
+			request: &pb.UserRebaseRequest{Repository: testRepo, User: testUser, Branch: branch, BranchSha: strings.Repeat("1", 40), TargetBranch: target},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "non-existing target branch",
+			request: &pb.UserRebaseRequest{Repository: testRepo, User: testUser, Branch: branch, BranchSha: setup.source, TargetBranch: []byte("does-not-exist")},
+			code:    codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			_, err := client.UserRebase(ctx, tc.request)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+}
@@ -0,0 +1,10 @@
+package operations
+
+import pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+type server struct{}
+
+// NewServer creates a new instance of a grpc OperationServiceServer
+func NewServer() pb.OperationServiceServer {
+	return &server{}
+}
@@ -0,0 +1,110 @@
+package operations
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/index"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+	"gitlab.com/gitlab-org/gitaly/internal/tempdir"
+)
+
+// UserSquash creates a single commit on top of start_sha with the changes
+// of the commits leading to end_sha. No branch is updated.
+func (s *server) UserSquash(ctx context.Context, in *pb.UserSquashRequest) (*pb.UserSquashResponse, error) {
+	if err := validateSquashRequest(in); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "UserSquash: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	startCommit, err := git.ResolveCommit(ctx, repoPath, in.GetStartSha())
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserSquash: %v", err)
+	}
+	if startCommit == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserSquash: start commit not found")
+	}
+
+	endCommit, err := git.ResolveCommit(ctx, repoPath, in.GetEndSha())
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserSquash: %v", err)
+	}
+	if endCommit == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserSquash: end commit not found")
+	}
+
+	base, err := git.MergeBase(ctx, repoPath, startCommit, endCommit)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserSquash: %v", err)
+	}
+	if base == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserSquash: no merge base")
+	}
+
+	tempDir, err := tempdir.New(ctx, in.GetRepository())
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserSquash: %v", err)
+	}
+
+	idx := index.New(repoPath, tempDir)
+	conflicts, err := idx.Merge(ctx, base, startCommit, endCommit)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserSquash: %v", err)
+	}
+	if len(conflicts) > 0 {
+		return &pb.UserSquashResponse{CreateTreeError: conflictError(conflicts)}, nil
+	}
+
+	tree, err := idx.WriteTree(ctx)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserSquash: %v", err)
+	}
+
+	startTree, err := git.ResolveRevision(ctx, repoPath, startCommit+"^{tree}")
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserSquash: %v", err)
+	}
+	if tree == startTree {
+		return &pb.UserSquashResponse{CreateTreeError: &pb.CreateTreeError{Code: pb.CreateTreeError_EMPTY}}, nil
+	}
+
+	squashID, err := git.CommitTree(ctx, repoPath, git.UserSignature(in.GetAuthor()), git.UserSignature(in.GetUser()), tree, in.GetCommitMessage(), startCommit)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserSquash: %v", err)
+	}
+
+	return &pb.UserSquashResponse{SquashSha: squashID}, nil
+}
+
+func validateSquashRequest(in *pb.UserSquashRequest) error {
+	if in.GetUser() == nil {
+		return fmt.Errorf("empty user")
+	}
+
+	if in.GetAuthor() == nil {
+		return fmt.Errorf("empty author")
+	}
+
+	if in.GetStartSha() == "" {
+		return fmt.Errorf("empty start SHA")
+	}
+
+	if in.GetEndSha() == "" {
+		return fmt.Errorf("empty end SHA")
+	}
+
+	if len(in.GetCommitMessage()) == 0 {
+		return fmt.Errorf("empty commit message")
+	}
+
+	return nil
+}
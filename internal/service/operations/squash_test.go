@@ -0,0 +1,172 @@
+package operations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+var squashAuthor = &pb.User{Name: []byte("John Doe"), Email: []byte("johndoe@example.com")}
+
+func TestSuccessfulUserSquashRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+	endCommit := testhelper.CreateCommit(t, testRepoPath, "gitaly-apply-commit-source", setup.source, map[string]string{"other.txt": "other"})
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.UserSquash(ctx, &pb.UserSquashRequest{
+		Repository:    testRepo,
+		User:          testUser,
+		StartSha:      setup.target,
+		EndSha:        endCommit,
+		Author:        squashAuthor,
+		CommitMessage: []byte("Squash source"),
+	})
+	require.NoError(t, err)
+	require.Nil(t, response.CreateTreeError)
+
+	squashID := response.SquashSha
+	require.Equal(t, setup.target, testhelper.RevParse(t, testRepoPath, squashID+"^"))
+	require.Equal(t, "one\n2\n3\n4\n5\n6\n7\n8\nnine\n", testhelper.ShowFile(t, testRepoPath, squashID, "numbers.txt"))
+	require.Equal(t, "source", testhelper.ShowFile(t, testRepoPath, squashID, "source.txt"))
+	require.Equal(t, "other", testhelper.ShowFile(t, testRepoPath, squashID, "other.txt"))
+
+	commitInfo := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "log", "-1", "--format=%an <%ae>%n%cn <%ce>%n%B", squashID))
+	require.Equal(t, "John Doe <johndoe@example.com>\nJane Doe <janedoe@example.com>\nSquash source\n", commitInfo)
+
+	// No branch points to the squashed commit
+	branches := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "branch", "--contains", squashID))
+	require.Empty(t, branches)
+}
+
+func TestFailedUserSquashRequestDueToTreeErrors(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+
+	testCases := []struct {
+		desc          string
+		startSha      string
+		endSha        string
+		expectedError *pb.CreateTreeError
+	}{
+		{
+			desc:          "conflict",
+			startSha:      setup.target,
+			endSha:        setup.conflicting,
+			expectedError: &pb.CreateTreeError{Code: pb.CreateTreeError_CONFLICT, ConflictPaths: [][]byte{[]byte("numbers.txt")}},
+		},
+		{
+			desc:          "no changes",
+			startSha:      setup.target,
+			endSha:        setup.base,
+			expectedError: &pb.CreateTreeError{Code: pb.CreateTreeError_EMPTY},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			response, err := client.UserSquash(ctx, &pb.UserSquashRequest{
+				Repository:    testRepo,
+				User:          testUser,
+				StartSha:      tc.startSha,
+				EndSha:        tc.endSha,
+				Author:        squashAuthor,
+				CommitMessage: []byte("Squash"),
+			})
+			require.NoError(t, err)
+			require.Empty(t, response.SquashSha)
+			require.Equal(t, tc.expectedError, response.CreateTreeError)
+		})
+	}
+}
+
+func TestFailedUserSquashRequestDueToValidations(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setup := setupApplyCommit(t, testRepoPath)
+
+	testCases := []struct {
+		desc    string
+		request *pb.UserSquashRequest
+		code    codes.Code
+	}{
+		{
+			desc:    "empty user",
+			request: &pb.UserSquashRequest{Repository: testRepo, Author: squashAuthor, StartSha: setup.target, EndSha: setup.source, CommitMessage: []byte("Squash")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty author",
+			request: &pb.UserSquashRequest{Repository: testRepo, User: testUser, StartSha: setup.target, EndSha: setup.source, CommitMessage: []byte("Squash")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty start SHA",
+			request: &pb.UserSquashRequest{Repository: testRepo, User: testUser, Author: squashAuthor, EndSha: setup.source, CommitMessage: []byte("Squash")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty end SHA",
+			request: &pb.UserSquashRequest{Repository: testRepo, User: testUser, Author: squashAuthor, StartSha: setup.target, CommitMessage: []byte("Squash")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty commit message",
+			request: &pb.UserSquashRequest{Repository: testRepo, User: testUser, Author: squashAuthor, StartSha: setup.target, EndSha: setup.source},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "non-existing start commit",
+			request: &pb.UserSquashRequest{Repository: testRepo, User: testUser, Author: squashAuthor, StartSha: strings.Repeat("1", 40), EndSha: setup.source, CommitMessage: []byte("Squash")},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "non-existing end commit",
+			request: &pb.UserSquashRequest{Repository: testRepo, User: testUser, Author: squashAuthor, StartSha: setup.target, EndSha: strings.Repeat("1", 40), CommitMessage: []byte("Squash")},
+			code:    codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			_, err := client.UserSquash(ctx, tc.request)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package operations
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/git/hooks"
+	"gitlab.com/gitlab-org/gitaly/internal/git/log"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+func (s *server) UserCreateTag(ctx context.Context, in *pb.UserCreateTagRequest) (*pb.UserCreateTagResponse, error) {
+	if err := validateCreateTagRequest(ctx, in); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "UserCreateTag: %v", err)
+	}
+
+	repo := in.GetRepository()
+	repoPath, err := helper.GetRepoPath(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	reference := "refs/tags/" + string(in.GetTagName())
+	existing, err := git.ResolveRevision(ctx, repoPath, reference)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserCreateTag: %v", err)
+	}
+	if existing != "" {
+		return nil, grpc.Errorf(codes.AlreadyExists, "UserCreateTag: tag %s already exists", in.GetTagName())
+	}
+
+	targetID, err := git.ResolveRevision(ctx, repoPath, string(in.GetTargetRevision()))
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserCreateTag: %v", err)
+	}
+	if targetID == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserCreateTag: target revision not found")
+	}
+
+	targetCommitID, err := git.ResolveCommit(ctx, repoPath, targetID)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserCreateTag: %v", err)
+	}
+	if targetCommitID == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserCreateTag: target revision is not a commit")
+	}
+
+	tagID := targetID
+	if len(in.GetMessage()) > 0 {
+		tagID, err = createTagObject(ctx, repoPath, in.GetUser(), string(in.GetTagName()), targetID, in.GetMessage())
+		if err != nil {
+			return nil, grpc.Errorf(codes.Internal, "UserCreateTag: %v", err)
+		}
+	}
+
+	err = hooks.UpdateReference(ctx, repo, in.GetUser(), reference, tagID, git.NullSha)
+	if _, ok := err.(*hooks.UpdateRefError); ok {
+		// The tag was created concurrently
+		return nil, grpc.Errorf(codes.AlreadyExists, "UserCreateTag: tag %s already exists", in.GetTagName())
+	}
+
+	hookErr, err := hooks.RPCError("UserCreateTag", err)
+	if err != nil {
+		return nil, err
+	}
+	if hookErr != nil {
+		return &pb.UserCreateTagResponse{PreReceiveError: hookErr.Message()}, nil
+	}
+
+	targetCommit, err := log.GetCommit(ctx, repo, targetCommitID, "")
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserCreateTag: %v", err)
+	}
+
+	return &pb.UserCreateTagResponse{
+		Tag: &pb.Tag{
+			Name:         in.GetTagName(),
+			Id:           tagID,
+			TargetCommit: targetCommit,
+			Message:      in.GetMessage(),
+		},
+	}, nil
+}
+
+func (s *server) UserDeleteTag(ctx context.Context, in *pb.UserDeleteTagRequest) (*pb.UserDeleteTagResponse, error) {
+	if err := validateDeleteTagRequest(ctx, in); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "UserDeleteTag: %v", err)
+	}
+
+	repo := in.GetRepository()
+	repoPath, err := helper.GetRepoPath(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	reference := "refs/tags/" + string(in.GetTagName())
+	revision, err := git.ResolveRevision(ctx, repoPath, reference)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "UserDeleteTag: %v", err)
+	}
+	if revision == "" {
+		return nil, grpc.Errorf(codes.FailedPrecondition, "UserDeleteTag: tag not found")
+	}
+
+	hookErr, err := hooks.RPCError("UserDeleteTag", hooks.UpdateReference(ctx, repo, in.GetUser(), reference, git.NullSha, revision))
+	if err != nil {
+		return nil, err
+	}
+	if hookErr != nil {
+		return &pb.UserDeleteTagResponse{PreReceiveError: hookErr.Message()}, nil
+	}
+
+	return &pb.UserDeleteTagResponse{}, nil
+}
+
+func validateCreateTagRequest(ctx context.Context, in *pb.UserCreateTagRequest) error {
+	if in.GetUser() == nil {
+		return fmt.Errorf("empty user")
+	}
+
+	if err := validateTagName(ctx, in.GetTagName()); err != nil {
+		return err
+	}
+
+	if err := git.ValidateRevision(in.GetTargetRevision()); err != nil {
+		return fmt.Errorf("target revision: %v", err)
+	}
+
+	return nil
+}
+
+func validateDeleteTagRequest(ctx context.Context, in *pb.UserDeleteTagRequest) error {
+	if in.GetUser() == nil {
+		return fmt.Errorf("empty user")
+	}
+
+	return validateTagName(ctx, in.GetTagName())
+}
+
+func validateTagName(ctx context.Context, tagName []byte) error {
+	if len(tagName) == 0 {
+		return fmt.Errorf("empty tag name")
+	}
+
+	cmd, err := command.Git(ctx, "check-ref-format", "refs/tags/"+string(tagName))
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("invalid tag name")
+	}
+
+	return nil
+}
+
+// createTagObject writes an annotated tag object for targetID, tagged by
+// user, and returns its ID.
+func createTagObject(ctx context.Context, repoPath string, user *pb.User, tagName, targetID string, message []byte) (string, error) {
+	targetType, err := objectType(ctx, repoPath, targetID)
+	if err != nil {
+		return "", err
+	}
+
+	var tag bytes.Buffer
+	fmt.Fprintf(&tag, "object %s\ntype %s\ntag %s\n", targetID, targetType, tagName)
+	fmt.Fprintf(&tag, "tagger %s <%s> %d +0000\n\n", git.SanitizeIdentity(user.GetName()), git.SanitizeIdentity(user.GetEmail()), time.Now().Unix())
+	tag.Write(message)
+	if !bytes.HasSuffix(message, []byte("\n")) {
+		tag.WriteByte('\n')
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), "--git-dir", repoPath, "mktag"), &tag, &stdout, &stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("mktag: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func objectType(ctx context.Context, repoPath, oid string) (string, error) {
+	var stdout bytes.Buffer
+
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), "--git-dir", repoPath, "cat-file", "-t", oid), nil, &stdout, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("cat-file: %v", err)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
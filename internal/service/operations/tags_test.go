@@ -0,0 +1,293 @@
+package operations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+func TestSuccessfulUserCreateTagRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	targetCommitID := testhelper.CreateCommit(t, testRepoPath, "gitaly-tag-test", "", map[string]string{"README": "tagged"})
+	annotatedTarget := "gitaly-annotated-tag-test"
+
+	testCases := []struct {
+		desc           string
+		tagName        string
+		targetRevision string
+		message        string
+		annotated      bool
+	}{
+		{desc: "lightweight tag", tagName: "lightweight-tag", targetRevision: targetCommitID},
+		{desc: "lightweight tag of a branch", tagName: "lightweight-branch-tag", targetRevision: "gitaly-tag-test"},
+		{desc: "annotated tag", tagName: annotatedTarget, targetRevision: targetCommitID, message: "This is an annotated tag", annotated: true},
+		{desc: "annotated tag of a tag", tagName: "tag-of-tag", targetRevision: "refs/tags/" + annotatedTarget, message: "Tag of a tag\n", annotated: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			response, err := client.UserCreateTag(ctx, &pb.UserCreateTagRequest{
+				Repository:     testRepo,
+				TagName:        []byte(tc.tagName),
+				User:           testUser,
+				TargetRevision: []byte(tc.targetRevision),
+				Message:        []byte(tc.message),
+			})
+			require.NoError(t, err)
+			require.Empty(t, response.PreReceiveError)
+
+			tag := response.Tag
+			require.Equal(t, tc.tagName, string(tag.Name))
+			require.Equal(t, tc.message, string(tag.Message))
+			require.Equal(t, targetCommitID, tag.TargetCommit.Id)
+			require.Equal(t, testhelper.RevParse(t, testRepoPath, "refs/tags/"+tc.tagName), tag.Id)
+
+			objectType := strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "cat-file", "-t", tag.Id)))
+			if !tc.annotated {
+				require.Equal(t, "commit", objectType)
+				return
+			}
+
+			require.Equal(t, "tag", objectType)
+			tagContent := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "cat-file", "tag", tag.Id))
+			require.Contains(t, tagContent, "tag "+tc.tagName+"\n")
+			require.Contains(t, tagContent, "tagger Jane Doe <janedoe@example.com>")
+			require.Contains(t, tagContent, "\n\n"+strings.TrimSuffix(tc.message, "\n")+"\n")
+		})
+	}
+}
+
+func TestUserCreateTagSanitizesTagger(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	user := &pb.User{
+		GlId:  testUser.GlId,
+		Name:  []byte(" Jane <Doe>\ntype blob\n"),
+		Email: []byte("<janedoe@example.com>\nforged header"),
+	}
+	response, err := client.UserCreateTag(ctx, &pb.UserCreateTagRequest{
+		Repository:     testRepo,
+		TagName:        []byte("sanitized-tag"),
+		User:           user,
+		TargetRevision: []byte("master"),
+		Message:        []byte("Sanitized"),
+	})
+	require.NoError(t, err)
+
+	tagContent := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "cat-file", "tag", response.Tag.Id))
+	header := strings.SplitN(tagContent, "\n\n", 2)[0]
+	require.Contains(t, header, "\ntagger Jane Doetype blob <janedoe@example.comforged header> ")
+	require.Equal(t, 4, strings.Count(header, "\n")+1, "the tag has more headers than expected:\n%s", header)
+}
+
+func TestFailedUserCreateTagRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	targetCommitID := testhelper.CreateCommit(t, testRepoPath, "gitaly-tag-test", "", map[string]string{"README": "tagged"})
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "tag", "existing-tag", targetCommitID)
+	treeID := testhelper.RevParse(t, testRepoPath, targetCommitID+"^{tree}")
+
+	testCases := []struct {
+		desc    string
+		request *pb.UserCreateTagRequest
+		code    codes.Code
+	}{
+		{
+			desc:    "empty user",
+			request: &pb.UserCreateTagRequest{Repository: testRepo, TagName: []byte("new-tag"), TargetRevision: []byte(targetCommitID)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty tag name",
+			request: &pb.UserCreateTagRequest{Repository: testRepo, User: testUser, TargetRevision: []byte(targetCommitID)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "invalid tag name",
+			request: &pb.UserCreateTagRequest{Repository: testRepo, User: testUser, TagName: []byte("new..tag"), TargetRevision: []byte(targetCommitID)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty target revision",
+			request: &pb.UserCreateTagRequest{Repository: testRepo, User: testUser, TagName: []byte("new-tag")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "existing tag",
+			request: &pb.UserCreateTagRequest{Repository: testRepo, User: testUser, TagName: []byte("existing-tag"), TargetRevision: []byte(targetCommitID)},
+			code:    codes.AlreadyExists,
+		},
+		{
+			desc:    "non-existing target revision",
+			request: &pb.UserCreateTagRequest{Repository: testRepo, User: testUser, TagName: []byte("new-tag"), TargetRevision: []byte("does-not-exist")},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "target revision is not a commit",
+			request: &pb.UserCreateTagRequest{Repository: testRepo, User: testUser, TagName: []byte("new-tag"), TargetRevision: []byte(treeID)},
+			code:    codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			_, err := client.UserCreateTag(ctx, tc.request)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+
+	require.Equal(t, targetCommitID, testhelper.RevParse(t, testRepoPath, "refs/tags/existing-tag"))
+}
+
+func TestSuccessfulUserDeleteTagRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	targetCommitID := testhelper.CreateCommit(t, testRepoPath, "gitaly-tag-test", "", map[string]string{"README": "tagged"})
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "-c", "user.name=Scrooge McDuck", "-c", "user.email=scrooge@mcduck.com", "tag", "-m", "annotated", "tag-to-delete", targetCommitID)
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.UserDeleteTag(ctx, &pb.UserDeleteTagRequest{
+		Repository: testRepo,
+		TagName:    []byte("tag-to-delete"),
+		User:       testUser,
+	})
+	require.NoError(t, err)
+	require.Empty(t, response.PreReceiveError)
+
+	tags := testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "tag", "--list", "tag-to-delete")
+	require.Empty(t, tags)
+}
+
+func TestFailedUserDeleteTagRequest(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, _, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	testCases := []struct {
+		desc    string
+		request *pb.UserDeleteTagRequest
+		code    codes.Code
+	}{
+		{
+			desc:    "empty user",
+			request: &pb.UserDeleteTagRequest{Repository: testRepo, TagName: []byte("some-tag")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty tag name",
+			request: &pb.UserDeleteTagRequest{Repository: testRepo, User: testUser},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "invalid tag name",
+			request: &pb.UserDeleteTagRequest{Repository: testRepo, User: testUser, TagName: []byte("../heads/master")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "non-existing tag",
+			request: &pb.UserDeleteTagRequest{Repository: testRepo, User: testUser, TagName: []byte("does-not-exist")},
+			code:    codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			_, err := client.UserDeleteTag(ctx, tc.request)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+}
+
+func TestFailedTagRequestsDueToHooks(t *testing.T) {
+	server := runOperationServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newOperationClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	targetCommitID := testhelper.CreateCommit(t, testRepoPath, "gitaly-tag-test", "", map[string]string{"README": "tagged"})
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "tag", "existing-tag", targetCommitID)
+
+	cleanupHooks := testhelper.SetupGitlabShellHooks(t, map[string]string{"update": "#!/bin/sh\necho 'GitLab: You are not allowed to change tags'\nexit 1\n"})
+	defer cleanupHooks()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	createResponse, err := client.UserCreateTag(ctx, &pb.UserCreateTagRequest{
+		Repository:     testRepo,
+		TagName:        []byte("new-tag"),
+		User:           testUser,
+		TargetRevision: []byte(targetCommitID),
+	})
+	require.NoError(t, err)
+	require.Nil(t, createResponse.Tag)
+	require.Contains(t, createResponse.PreReceiveError, "You are not allowed to change tags")
+
+	deleteResponse, err := client.UserDeleteTag(ctx, &pb.UserDeleteTagRequest{
+		Repository: testRepo,
+		TagName:    []byte("existing-tag"),
+		User:       testUser,
+	})
+	require.NoError(t, err)
+	require.Contains(t, deleteResponse.PreReceiveError, "You are not allowed to change tags")
+
+	tags := testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "tag", "--list", "new-tag", "existing-tag")
+	require.Equal(t, "existing-tag\n", string(tags))
+}
@@ -0,0 +1,47 @@
+package operations
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+var (
+	serverSocketPath = testhelper.GetTemporaryGitalySocketFileName()
+	testUser         = &pb.User{GlId: "user-123", Name: []byte("Jane Doe"), Email: []byte("janedoe@example.com")}
+)
+
+func runOperationServiceServer(t *testing.T) *grpc.Server {
+	server := testhelper.NewTestGrpcServer(t, nil, nil)
+	listener, err := net.Listen("unix", serverSocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pb.RegisterOperationServiceServer(server, NewServer())
+	reflection.Register(server)
+
+	go server.Serve(listener)
+
+	return server
+}
+
+func newOperationClient(t *testing.T) (pb.OperationServiceClient, *grpc.ClientConn) {
+	connOpts := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, _ time.Duration) (net.Conn, error) {
+			return net.Dial("unix", addr)
+		}),
+	}
+	conn, err := grpc.Dial(serverSocketPath, connOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pb.NewOperationServiceClient(conn), conn
+}
@@ -1,51 +1,208 @@
 package ref
 
 import (
-	"gitlab.com/gitlab-org/gitaly/internal/rubyserver"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
 
-	"golang.org/x/net/context"
+const (
+	localBranchPrefix  = "refs/heads/"
+	remoteBranchPrefix = "refs/remotes/"
 )
 
+// CreateBranch creates a local branch at a start point, or at HEAD if
+// none is given. Failures caused by the request are reported in the
+// status of the response.
 func (s *server) CreateBranch(ctx context.Context, req *pb.CreateBranchRequest) (*pb.CreateBranchResponse, error) {
-	client, err := s.RefServiceClient(ctx)
+	repoPath, err := helper.GetRepoPath(req.GetRepository())
 	if err != nil {
 		return nil, err
 	}
 
-	clientCtx, err := rubyserver.SetHeaders(ctx, req.GetRepository())
+	startPoint := req.GetStartPoint()
+	if len(startPoint) == 0 {
+		startPoint = []byte("HEAD")
+	}
+
+	if git.ValidateRevision(startPoint) != nil {
+		return &pb.CreateBranchResponse{Status: pb.CreateBranchResponse_ERR_INVALID_START_POINT}, nil
+	}
+
+	startPointID, err := resolveRevision(ctx, repoPath, string(startPoint)+"^{commit}")
+	if _, ok := err.(revisionNotFoundError); ok {
+		return &pb.CreateBranchResponse{Status: pb.CreateBranchResponse_ERR_INVALID_START_POINT}, nil
+	} else if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "CreateBranch: %v", err)
+	}
+
+	name := string(req.GetName())
+	ref := localBranchPrefix + name
+
+	err = checkRefFormat(ctx, repoPath, ref)
+	if _, ok := err.(invalidRefNameError); ok {
+		return &pb.CreateBranchResponse{Status: pb.CreateBranchResponse_ERR_INVALID}, nil
+	} else if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "CreateBranch: %v", err)
+	}
+
+	// create SP <ref> NUL <newvalue> NUL fails if the reference exists
+	stdin := fmt.Sprintf("create %s\x00%s\x00", ref, startPointID)
+	err = runUpdateRef(ctx, repoPath, strings.NewReader(stdin))
+	if _, ok := err.(updateRefError); ok {
+		return &pb.CreateBranchResponse{Status: pb.CreateBranchResponse_ERR_EXISTS}, nil
+	} else if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "CreateBranch: %v", err)
+	}
+
+	branch, err := findBranch(ctx, repoPath, []string{ref})
 	if err != nil {
-		return nil, err
+		return nil, grpc.Errorf(codes.Internal, "CreateBranch: %v", err)
+	}
+
+	if branch == nil {
+		return nil, grpc.Errorf(codes.Internal, "CreateBranch: branch not found after creation: %q", name)
 	}
 
-	return client.CreateBranch(clientCtx, req)
+	return &pb.CreateBranchResponse{Status: pb.CreateBranchResponse_OK, Branch: branch.Branch}, nil
 }
 
+// DeleteBranch deletes a branch, found by name like FindBranch does
 func (s *server) DeleteBranch(ctx context.Context, req *pb.DeleteBranchRequest) (*pb.DeleteBranchResponse, error) {
-	client, err := s.RefServiceClient(ctx)
+	if len(req.GetName()) == 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "empty Name")
+	}
+
+	repoPath, err := helper.GetRepoPath(req.GetRepository())
 	if err != nil {
 		return nil, err
 	}
 
-	clientCtx, err := rubyserver.SetHeaders(ctx, req.GetRepository())
+	branch, err := findBranch(ctx, repoPath, branchCandidates(string(req.GetName())))
 	if err != nil {
-		return nil, err
+		return nil, grpc.Errorf(codes.Internal, "DeleteBranch: %v", err)
+	}
+
+	if branch == nil {
+		return nil, grpc.Errorf(codes.Internal, "DeleteBranch: branch not found: %q", req.GetName())
+	}
+
+	// delete SP <ref> NUL [<oldvalue>] NUL
+	stdin := fmt.Sprintf("delete %s\x00%s\x00", branch.ref, branch.TargetCommit.Id)
+	if err := runUpdateRef(ctx, repoPath, strings.NewReader(stdin)); err != nil {
+		return nil, grpc.Errorf(codes.Internal, "DeleteBranch: %v", err)
 	}
 
-	return client.DeleteBranch(clientCtx, req)
+	return &pb.DeleteBranchResponse{}, nil
 }
 
+// FindBranch returns the branch with the given name, or no branch if it
+// doesn't exist. The name can also be a full reference name like
+// refs/heads/master, or start with heads/ or remotes/.
 func (s *server) FindBranch(ctx context.Context, req *pb.FindBranchRequest) (*pb.FindBranchResponse, error) {
-	client, err := s.RefServiceClient(ctx)
+	if len(req.GetName()) == 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "empty Name")
+	}
+
+	repoPath, err := helper.GetRepoPath(req.GetRepository())
 	if err != nil {
 		return nil, err
 	}
 
-	clientCtx, err := rubyserver.SetHeaders(ctx, req.GetRepository())
+	branch, err := findBranch(ctx, repoPath, branchCandidates(string(req.GetName())))
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "FindBranch: %v", err)
+	}
+
+	if branch == nil {
+		return &pb.FindBranchResponse{}, nil
+	}
+
+	return &pb.FindBranchResponse{Branch: branch.Branch}, nil
+}
+
+// branchCandidates returns the references a branch name can refer to, in
+// order of precedence: local branches first, then remote branches, then
+// the name taken relative to refs/, like heads/master.
+func branchCandidates(name string) []string {
+	if strings.HasPrefix(name, localBranchPrefix) || strings.HasPrefix(name, remoteBranchPrefix) {
+		return []string{name}
+	}
+
+	candidates := []string{localBranchPrefix + name, remoteBranchPrefix + name}
+	if ref := "refs/" + name; strings.HasPrefix(ref, localBranchPrefix) || strings.HasPrefix(ref, remoteBranchPrefix) {
+		candidates = append(candidates, ref)
+	}
+
+	return candidates
+}
+
+// foundBranch is a branch and the reference it was found at
+type foundBranch struct {
+	*pb.Branch
+	ref string
+}
+
+// findBranch returns the branch of the first existing reference among
+// candidates, or nil if there is none.
+func findBranch(ctx context.Context, repoPath string, candidates []string) (*foundBranch, error) {
+	// Each branch is terminated by a NUL since the message can span lines
+	format := strings.Join(branchFormatFields, "%00") + "%00"
+	args := append([]string{"--git-dir", repoPath, "for-each-ref", "--format=" + format, "--"}, candidates...)
+	cmd, err := command.Git(ctx, args...)
 	if err != nil {
 		return nil, err
 	}
 
-	return client.FindBranch(clientCtx, req)
+	var stdout bytes.Buffer
+	if _, err := stdout.ReadFrom(cmd); err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+
+	// for-each-ref also lists the references below the patterns, so only
+	// exact matches are kept
+	branches := make(map[string][][]byte)
+	fields := bytes.Split(stdout.Bytes(), []byte{'\x00'})
+	for len(fields) >= len(branchFormatFields) {
+		elements := fields[:len(branchFormatFields)]
+		fields = fields[len(branchFormatFields):]
+
+		elements[0] = bytes.TrimPrefix(elements[0], []byte{'\n'})
+		branches[string(elements[0])] = elements
+	}
+
+	for _, ref := range candidates {
+		if elements, ok := branches[ref]; ok {
+			commit, err := buildBranchCommit(elements)
+			if err != nil {
+				return nil, err
+			}
+
+			return &foundBranch{Branch: &pb.Branch{Name: []byte(branchName(ref)), TargetCommit: commit}, ref: ref}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// branchName returns the name of a branch without its reference prefix
+func branchName(ref string) string {
+	if strings.HasPrefix(ref, localBranchPrefix) {
+		return strings.TrimPrefix(ref, localBranchPrefix)
+	}
+
+	return strings.TrimPrefix(ref, remoteBranchPrefix)
 }
@@ -0,0 +1,108 @@
+package ref
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/rubyserver"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+// branchParityCase is a branch request that is sent to both the Go and the
+// gitaly-ruby implementations, each on its own copy of the test repository.
+type branchParityCase struct {
+	desc  string
+	setup func(t *testing.T, repoPath string)
+	call  func(ctx context.Context, client pb.RefServiceClient, repo *pb.Repository) (interface{}, error)
+}
+
+func createBranchCall(name, startPoint string) func(context.Context, pb.RefServiceClient, *pb.Repository) (interface{}, error) {
+	return func(ctx context.Context, client pb.RefServiceClient, repo *pb.Repository) (interface{}, error) {
+		return client.CreateBranch(ctx, &pb.CreateBranchRequest{Repository: repo, Name: []byte(name), StartPoint: []byte(startPoint)})
+	}
+}
+
+func deleteBranchCall(name string) func(context.Context, pb.RefServiceClient, *pb.Repository) (interface{}, error) {
+	return func(ctx context.Context, client pb.RefServiceClient, repo *pb.Repository) (interface{}, error) {
+		return client.DeleteBranch(ctx, &pb.DeleteBranchRequest{Repository: repo, Name: []byte(name)})
+	}
+}
+
+func findBranchCall(name string) func(context.Context, pb.RefServiceClient, *pb.Repository) (interface{}, error) {
+	return func(ctx context.Context, client pb.RefServiceClient, repo *pb.Repository) (interface{}, error) {
+		return client.FindBranch(ctx, &pb.FindBranchRequest{Repository: repo, Name: []byte(name)})
+	}
+}
+
+func createParityRemoteBranch(t *testing.T, repoPath string) {
+	createRemoteBranch(t, repoPath, "origin", "parity", "refs/heads/feature")
+}
+
+func TestBranchesParityWithRuby(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	rubyClient, err := rubyServer.RefServiceClient(ctx)
+	require.NoError(t, err)
+
+	masterID := showRef(t, testRepoPath, "refs/heads/master")
+
+	testCases := []branchParityCase{
+		{desc: "create from HEAD", call: createBranchCall("parity", "")},
+		{desc: "create from an object ID", call: createBranchCall("parity", masterID)},
+		{desc: "create from a branch", call: createBranchCall("parity", "feature")},
+		{desc: "create an existing branch", call: createBranchCall("master", "")},
+		{desc: "create with an empty name", call: createBranchCall("", "")},
+		{desc: "create with an invalid name", call: createBranchCall("parity..branch", "")},
+		{desc: "create from a missing start point", call: createBranchCall("parity", "i-do-not-exist")},
+		{desc: "find by name", call: findBranchCall("master")},
+		{desc: "find by reference", call: findBranchCall("refs/heads/master")},
+		{desc: "find by heads/ path", call: findBranchCall("heads/master")},
+		{desc: "find a remote branch", setup: createParityRemoteBranch, call: findBranchCall("origin/parity")},
+		{desc: "find a missing branch", call: findBranchCall("i-do-not-exist")},
+		{desc: "find with an empty name", call: findBranchCall("")},
+		{desc: "delete by name", call: deleteBranchCall("feature")},
+		{desc: "delete by reference", call: deleteBranchCall("refs/heads/feature")},
+		{desc: "delete a remote branch", setup: createParityRemoteBranch, call: deleteBranchCall("origin/parity")},
+		{desc: "delete a missing branch", call: deleteBranchCall("i-do-not-exist")},
+		{desc: "delete with an empty name", call: deleteBranchCall("")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			goRepo, goRepoPath, goCleanup := testhelper.NewTestRepo(t)
+			defer goCleanup()
+
+			rubyRepo, rubyRepoPath, rubyCleanup := testhelper.NewTestRepo(t)
+			defer rubyCleanup()
+
+			if tc.setup != nil {
+				tc.setup(t, goRepoPath)
+				tc.setup(t, rubyRepoPath)
+			}
+
+			goResponse, goErr := tc.call(ctx, client, goRepo)
+
+			rubyCtx, err := rubyserver.SetHeaders(ctx, rubyRepo)
+			require.NoError(t, err)
+			rubyResponse, rubyErr := tc.call(rubyCtx, rubyClient, rubyRepo)
+
+			require.Equal(t, grpc.Code(rubyErr), grpc.Code(goErr), "Ruby error: %v, Go error: %v", rubyErr, goErr)
+			if rubyErr == nil {
+				require.Equal(t, rubyResponse, goResponse)
+			}
+
+			require.Equal(t, listRefs(t, rubyRepoPath), listRefs(t, goRepoPath))
+		})
+	}
+}
@@ -3,6 +3,7 @@ package ref
 import (
 	"context"
 	"os/exec"
+	"strings"
 	"testing"
 
 	"gitlab.com/gitlab-org/gitaly/internal/git/log"
@@ -99,6 +100,11 @@ func TestFailedCreateBranchRequest(t *testing.T) {
 			branchName: "",
 			status:     pb.CreateBranchResponse_ERR_INVALID,
 		},
+		{
+			desc:       "invalid branch name",
+			branchName: "shiny..new-branch",
+			status:     pb.CreateBranchResponse_ERR_INVALID,
+		},
 		{
 			desc:       "invalid start point",
 			branchName: "shiny-new-branch",
@@ -275,6 +281,37 @@ func TestSuccessfulFindBranchRequest(t *testing.T) {
 	}
 }
 
+func TestSuccessfulFindRemoteBranchRequest(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	createRemoteBranch(t, testRepoPath, "origin", "feature", "refs/heads/feature")
+	// A local branch with the same name takes precedence
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", "refs/heads/origin/master", "refs/heads/feature")
+
+	featureCommit, err := log.GetCommit(ctx, testRepo, "feature", "")
+	require.NoError(t, err)
+
+	for _, branchName := range []string{"origin/feature", "refs/remotes/origin/feature", "remotes/origin/feature", "origin/master"} {
+		t.Run(branchName, func(t *testing.T) {
+			response, err := client.FindBranch(ctx, &pb.FindBranchRequest{Repository: testRepo, Name: []byte(branchName)})
+			require.NoError(t, err)
+
+			expectedName := strings.TrimPrefix(strings.TrimPrefix(branchName, "refs/"), "remotes/")
+			require.Equal(t, &pb.Branch{Name: []byte(expectedName), TargetCommit: featureCommit}, response.Branch)
+		})
+	}
+}
+
 func TestFailedFindBranchRequest(t *testing.T) {
 	server := runRefServiceServer(t)
 	defer server.Stop()
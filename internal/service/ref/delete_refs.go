@@ -0,0 +1,181 @@
+package ref
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+// deleteRefsBatchSize is the number of references deleted per update-ref
+// transaction, so that a single transaction doesn't lock too many refs.
+var deleteRefsBatchSize = 1000
+
+// DeleteRefs deletes a list of references, or all the references under some
+// prefixes, and returns how many were deleted. The deletion is done in
+// batches; if a batch fails, the previous ones stay applied.
+func (s *server) DeleteRefs(ctx context.Context, in *pb.DeleteRefsRequest) (*pb.DeleteRefsResponse, error) {
+	if err := validateDeleteRefsRequest(in); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "DeleteRefs: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := refsToDelete(ctx, repoPath, in)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "DeleteRefs: %v", err)
+	}
+
+	var deleted int64
+	for len(refs) > 0 {
+		batch := refs
+		if len(batch) > deleteRefsBatchSize {
+			batch = batch[:deleteRefsBatchSize]
+		}
+		refs = refs[len(batch):]
+
+		var stdin bytes.Buffer
+		for _, ref := range batch {
+			// no-deref deletes symbolic references themselves rather
+			// than the references they point to. The old value keeps a
+			// reference updated since it was listed from being deleted.
+			// option SP no-deref NUL delete SP <ref> NUL [<oldvalue>] NUL
+			fmt.Fprintf(&stdin, "option no-deref\x00delete %s\x00%s\x00", ref.name, ref.oid)
+		}
+
+		err := runUpdateRef(ctx, repoPath, &stdin)
+		if _, ok := err.(updateRefError); ok {
+			return nil, grpc.Errorf(codes.FailedPrecondition, "DeleteRefs: %d refs deleted before failure: %v", deleted, err)
+		} else if err != nil {
+			return nil, grpc.Errorf(codes.Internal, "DeleteRefs: %v", err)
+		}
+
+		deleted += int64(len(batch))
+	}
+
+	if in.GetPackRefs() {
+		cmd, err := command.Git(ctx, "--git-dir", repoPath, "pack-refs", "--all")
+		if err != nil {
+			return nil, grpc.Errorf(codes.Internal, "DeleteRefs: %v", err)
+		}
+
+		if err := cmd.Wait(); err != nil {
+			return nil, grpc.Errorf(codes.Internal, "DeleteRefs: pack-refs: %v", err)
+		}
+	}
+
+	return &pb.DeleteRefsResponse{DeletedCount: deleted}, nil
+}
+
+func validateDeleteRefsRequest(in *pb.DeleteRefsRequest) error {
+	if len(in.GetRefs()) == 0 && len(in.GetPrefixes()) == 0 {
+		return fmt.Errorf("empty refs and prefixes")
+	}
+
+	for _, ref := range in.GetRefs() {
+		if !isValidRefName(string(ref)) {
+			return fmt.Errorf("invalid ref: %q", ref)
+		}
+	}
+
+	for _, prefix := range in.GetPrefixes() {
+		// Deleting all the references at once is never wanted
+		if !isValidRefName(string(prefix)) || strings.Trim(string(prefix), "/") == "refs" {
+			return fmt.Errorf("invalid prefix: %q", prefix)
+		}
+	}
+
+	return nil
+}
+
+// refToDelete is a reference and the object it pointed to when listed
+type refToDelete struct {
+	name string
+	// Empty for a symbolic reference to a missing reference
+	oid string
+}
+
+// refsToDelete lists the existing references the request asks to delete.
+func refsToDelete(ctx context.Context, repoPath string, in *pb.DeleteRefsRequest) ([]refToDelete, error) {
+	refs := make(map[string]bool)
+	var names []string
+	for _, ref := range in.GetRefs() {
+		if !refs[string(ref)] {
+			names = append(names, string(ref))
+		}
+		refs[string(ref)] = true
+	}
+
+	var prefixes []string
+	for _, prefix := range in.GetPrefixes() {
+		prefixes = append(prefixes, strings.TrimSuffix(string(prefix), "/")+"/")
+	}
+
+	// for-each-ref matches patterns by whole path components, so each
+	// pattern lists the reference itself and the ones under it
+	args := []string{"--git-dir", repoPath, "for-each-ref", "--format=%(objectname) %(refname)", "--"}
+	args = append(args, refListPatterns(names)...)
+	args = append(args, prefixes...)
+
+	cmd, err := command.Git(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var toDelete []refToDelete
+	scanner := bufio.NewScanner(cmd)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid for-each-ref output: %q", scanner.Text())
+		}
+
+		ref := refToDelete{name: fields[1], oid: fields[0]}
+
+		if (refs[ref.name] || hasAnyPrefix(ref.name, prefixes)) && !isExcepted(ref.name, in.GetExcept()) {
+			toDelete = append(toDelete, ref)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("for-each-ref: %v", err)
+	}
+
+	return toDelete, nil
+}
+
+func hasAnyPrefix(ref string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(ref, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isExcepted(ref string, except [][]byte) bool {
+	for _, e := range except {
+		exception := string(e)
+		if ref == exception || strings.HasSuffix(exception, "/") && strings.HasPrefix(ref, exception) {
+			return true
+		}
+	}
+
+	return false
+}
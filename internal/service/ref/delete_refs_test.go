@@ -0,0 +1,287 @@
+package ref
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+var deleteRefsTestRefs = []string{
+	"refs/environments/production/deployments/1",
+	"refs/keep-around/1",
+	"refs/merge-requests/1/head",
+	"refs/merge-requests/2/head",
+	"refs/merge-requests/2/merge",
+	"refs/merge-requests/3/head",
+}
+
+func setupDeleteRefs(t *testing.T, repoPath string) {
+	masterID := showRef(t, repoPath, "refs/heads/master")
+	for _, ref := range deleteRefsTestRefs {
+		testhelper.MustRunCommand(t, nil, "git", "--git-dir", repoPath, "update-ref", ref, masterID)
+	}
+}
+
+func listRefs(t *testing.T, repoPath string, patterns ...string) []string {
+	args := append([]string{"--git-dir", repoPath, "for-each-ref", "--format=%(refname)"}, patterns...)
+	output := strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", args...)))
+	if output == "" {
+		return nil
+	}
+
+	return strings.Split(output, "\n")
+}
+
+func TestSuccessfulDeleteRefsRequest(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testCases := []struct {
+		desc          string
+		request       *pb.DeleteRefsRequest
+		deletedCount  int64
+		remainingRefs []string
+	}{
+		{
+			desc: "refs",
+			request: &pb.DeleteRefsRequest{
+				Refs: [][]byte{[]byte("refs/merge-requests/1/head"), []byte("refs/keep-around/1"), []byte("refs/keep-around/does-not-exist"), []byte("refs/merge-requests/2")},
+			},
+			deletedCount: 2,
+			remainingRefs: []string{
+				"refs/environments/production/deployments/1",
+				"refs/merge-requests/2/head",
+				"refs/merge-requests/2/merge",
+				"refs/merge-requests/3/head",
+			},
+		},
+		{
+			desc: "prefixes",
+			request: &pb.DeleteRefsRequest{
+				Prefixes: [][]byte{[]byte("refs/merge-requests/"), []byte("refs/environments")},
+			},
+			deletedCount:  5,
+			remainingRefs: []string{"refs/keep-around/1"},
+		},
+		{
+			desc: "prefixes with exceptions",
+			request: &pb.DeleteRefsRequest{
+				Prefixes: [][]byte{[]byte("refs/merge-requests/")},
+				Except:   [][]byte{[]byte("refs/merge-requests/2/"), []byte("refs/merge-requests/3/head")},
+			},
+			deletedCount: 1,
+			remainingRefs: []string{
+				"refs/environments/production/deployments/1",
+				"refs/keep-around/1",
+				"refs/merge-requests/2/head",
+				"refs/merge-requests/2/merge",
+				"refs/merge-requests/3/head",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+			defer cleanupFn()
+
+			setupDeleteRefs(t, testRepoPath)
+			branches := listRefs(t, testRepoPath, "refs/heads/", "refs/tags/")
+
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			tc.request.Repository = testRepo
+			response, err := client.DeleteRefs(ctx, tc.request)
+			require.NoError(t, err)
+			require.Equal(t, tc.deletedCount, response.DeletedCount)
+
+			require.Equal(t, tc.remainingRefs, listRefs(t, testRepoPath, "refs/environments/", "refs/keep-around/", "refs/merge-requests/"))
+			require.Equal(t, branches, listRefs(t, testRepoPath, "refs/heads/", "refs/tags/"))
+		})
+	}
+}
+
+func TestSuccessfulDeleteRefsRequestInBatches(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setupDeleteRefs(t, testRepoPath)
+
+	defer func(oldSize int) {
+		deleteRefsBatchSize = oldSize
+	}(deleteRefsBatchSize)
+	deleteRefsBatchSize = 2
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.DeleteRefs(ctx, &pb.DeleteRefsRequest{
+		Repository: testRepo,
+		Prefixes:   [][]byte{[]byte("refs/merge-requests/"), []byte("refs/environments/"), []byte("refs/keep-around/")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(len(deleteRefsTestRefs)), response.DeletedCount)
+	require.Empty(t, listRefs(t, testRepoPath, "refs/environments/", "refs/keep-around/", "refs/merge-requests/"))
+}
+
+func TestSuccessfulDeleteRefsRequestWithManyRefs(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setupDeleteRefs(t, testRepoPath)
+	branches := listRefs(t, testRepoPath, "refs/heads/", "refs/tags/")
+
+	// The refs are listed by their namespaces instead
+	defer func(oldMax int) {
+		maxRefPatterns = oldMax
+	}(maxRefPatterns)
+	maxRefPatterns = 1
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.DeleteRefs(ctx, &pb.DeleteRefsRequest{
+		Repository: testRepo,
+		Refs:       [][]byte{[]byte("refs/merge-requests/1/head"), []byte("refs/keep-around/1"), []byte("refs/merge-requests/2")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), response.DeletedCount)
+
+	require.Equal(t, []string{
+		"refs/environments/production/deployments/1",
+		"refs/merge-requests/2/head",
+		"refs/merge-requests/2/merge",
+		"refs/merge-requests/3/head",
+	}, listRefs(t, testRepoPath, "refs/environments/", "refs/keep-around/", "refs/merge-requests/"))
+	require.Equal(t, branches, listRefs(t, testRepoPath, "refs/heads/", "refs/tags/"))
+}
+
+func TestSuccessfulDeleteRefsRequestWithSymbolicRefs(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	masterID := showRef(t, testRepoPath, "refs/heads/master")
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", "refs/remotes/origin/master", masterID)
+	// One symbolic reference points to a reference deleted in the same
+	// batch, the other to a reference outside of the prefix
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/master")
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "symbolic-ref", "refs/remotes/origin/local", "refs/heads/master")
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.DeleteRefs(ctx, &pb.DeleteRefsRequest{
+		Repository: testRepo,
+		Prefixes:   [][]byte{[]byte("refs/remotes/origin/")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), response.DeletedCount)
+	require.Empty(t, listRefs(t, testRepoPath, "refs/remotes/origin/"))
+	require.Equal(t, masterID, showRef(t, testRepoPath, "refs/heads/master"))
+}
+
+func TestSuccessfulDeleteRefsRequestPackingRefs(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	setupDeleteRefs(t, testRepoPath)
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.DeleteRefs(ctx, &pb.DeleteRefsRequest{
+		Repository: testRepo,
+		Prefixes:   [][]byte{[]byte("refs/merge-requests/")},
+		PackRefs:   true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), response.DeletedCount)
+
+	// The remaining loose references were packed
+	_, err = os.Stat(path.Join(testRepoPath, "refs/keep-around/1"))
+	require.True(t, os.IsNotExist(err))
+
+	packedRefs, err := ioutil.ReadFile(path.Join(testRepoPath, "packed-refs"))
+	require.NoError(t, err)
+	require.Contains(t, string(packedRefs), " refs/keep-around/1\n")
+	require.NotContains(t, string(packedRefs), "refs/merge-requests/")
+}
+
+func TestFailedDeleteRefsRequestDueToValidations(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, _, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	testCases := []struct {
+		desc    string
+		request *pb.DeleteRefsRequest
+	}{
+		{
+			desc:    "no refs or prefixes",
+			request: &pb.DeleteRefsRequest{Repository: testRepo, Except: [][]byte{[]byte("refs/heads/master")}},
+		},
+		{
+			desc:    "ref outside of refs/",
+			request: &pb.DeleteRefsRequest{Repository: testRepo, Refs: [][]byte{[]byte("HEAD")}},
+		},
+		{
+			desc:    "prefix outside of refs/",
+			request: &pb.DeleteRefsRequest{Repository: testRepo, Prefixes: [][]byte{[]byte("heads/")}},
+		},
+		{
+			desc:    "all refs",
+			request: &pb.DeleteRefsRequest{Repository: testRepo, Prefixes: [][]byte{[]byte("refs/")}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			_, err := client.DeleteRefs(ctx, tc.request)
+			testhelper.AssertGrpcError(t, err, codes.InvalidArgument, "")
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package ref
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+	"gitlab.com/gitlab-org/gitaly/internal/helper/lines"
+)
+
+// FindMergedBranches streams the local branches that are merged into the
+// target revision, or one page of them. All the branches are checked by a
+// single for-each-ref --merged.
+func (s *server) FindMergedBranches(in *pb.FindMergedBranchesRequest, stream pb.RefService_FindMergedBranchesServer) error {
+	if len(in.GetTarget()) == 0 {
+		return grpc.Errorf(codes.InvalidArgument, "FindMergedBranches: empty Target")
+	}
+
+	if err := git.ValidateRevision(in.GetTarget()); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "FindMergedBranches: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+
+	targetID, err := resolveRevision(ctx, repoPath, string(in.GetTarget())+"^{commit}")
+	if _, ok := err.(revisionNotFoundError); ok {
+		return grpc.Errorf(codes.InvalidArgument, "FindMergedBranches: %v", err)
+	} else if err != nil {
+		return grpc.Errorf(codes.Internal, "FindMergedBranches: %v", err)
+	}
+
+	page := newRefsPage([]string{localBranchPrefix}, nil, nil, in.GetPaginationParams(), true)
+	if len(in.GetBranchNames()) > 0 {
+		page.refs = make(map[string]bool)
+		for _, name := range in.GetBranchNames() {
+			page.refs[localBranchPrefix+string(name)] = true
+		}
+	}
+
+	opts := &findRefsOpts{
+		cmdArgs: []string{"--format=%(refname)", "--merged=" + targetID},
+		page:    page,
+	}
+
+	if err := findRefs(ctx, newFindMergedBranchesWriter(stream), in.GetRepository(), nil, opts); err != nil {
+		return grpc.Errorf(codes.Internal, "FindMergedBranches: %v", err)
+	}
+
+	return nil
+}
+
+func newFindMergedBranchesWriter(stream pb.RefService_FindMergedBranchesServer) lines.Sender {
+	return func(refs [][]byte) error {
+		return stream.Send(&pb.FindMergedBranchesResponse{BranchNames: refs})
+	}
+}
@@ -0,0 +1,172 @@
+package ref
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+func findMergedBranches(t *testing.T, client pb.RefServiceClient, request *pb.FindMergedBranchesRequest) ([]string, error) {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.FindMergedBranches(ctx, request)
+	require.NoError(t, err)
+
+	var names []string
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range response.GetBranchNames() {
+			names = append(names, string(name))
+		}
+	}
+
+	return names, nil
+}
+
+func TestSuccessfulFindMergedBranches(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", "refs/heads/merged/a", "master~1")
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", "refs/heads/merged/b", "master")
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", "refs/heads/merged/c", "master")
+	createDatedCommit(t, testRepoPath, "refs/heads/merged/unmerged", 1500000000)
+	// A ref outside refs/heads/ isn't a branch, even if it is merged
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", "refs/merged/c", "master")
+
+	testCases := []struct {
+		desc     string
+		request  *pb.FindMergedBranchesRequest
+		expected []string
+	}{
+		{
+			desc:     "given branches",
+			request:  &pb.FindMergedBranchesRequest{BranchNames: [][]byte{[]byte("merged/a"), []byte("merged/unmerged"), []byte("merged/missing")}},
+			expected: []string{"refs/heads/merged/a"},
+		},
+		{
+			desc:     "target older than the branches",
+			request:  &pb.FindMergedBranchesRequest{Target: []byte("master~1"), BranchNames: [][]byte{[]byte("merged/a"), []byte("merged/b")}},
+			expected: []string{"refs/heads/merged/a"},
+		},
+		{
+			desc: "first page",
+			request: &pb.FindMergedBranchesRequest{
+				BranchNames:      [][]byte{[]byte("merged/a"), []byte("merged/b"), []byte("merged/c")},
+				PaginationParams: &pb.PaginationParameter{Limit: 2},
+			},
+			expected: []string{"refs/heads/merged/a", "refs/heads/merged/b"},
+		},
+		{
+			desc: "next page",
+			request: &pb.FindMergedBranchesRequest{
+				BranchNames:      [][]byte{[]byte("merged/a"), []byte("merged/b"), []byte("merged/c")},
+				PaginationParams: &pb.PaginationParameter{PageToken: []byte("refs/heads/merged/b"), Limit: 2},
+			},
+			expected: []string{"refs/heads/merged/c"},
+		},
+		{
+			// for-each-ref would list the branches below it
+			desc:    "directory of branches",
+			request: &pb.FindMergedBranchesRequest{BranchNames: [][]byte{[]byte("merged")}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.request.Repository = testRepo
+			if tc.request.Target == nil {
+				tc.request.Target = []byte("master")
+			}
+
+			names, err := findMergedBranches(t, client, tc.request)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, names)
+		})
+	}
+
+	t.Run("more given branches than ref patterns", func(t *testing.T) {
+		// refs/heads/ is listed and filtered instead
+		defer func(oldMax int) {
+			maxRefPatterns = oldMax
+		}(maxRefPatterns)
+		maxRefPatterns = 1
+
+		names, err := findMergedBranches(t, client, &pb.FindMergedBranchesRequest{
+			Repository:  testRepo,
+			Target:      []byte("master"),
+			BranchNames: [][]byte{[]byte("merged/a"), []byte("merged/unmerged"), []byte("merged/missing"), []byte("merged")},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"refs/heads/merged/a"}, names)
+	})
+
+	t.Run("all branches", func(t *testing.T) {
+		names, err := findMergedBranches(t, client, &pb.FindMergedBranchesRequest{Repository: testRepo, Target: []byte("master")})
+		require.NoError(t, err)
+
+		require.Contains(t, names, "refs/heads/master")
+		require.Contains(t, names, "refs/heads/merged/a")
+		require.NotContains(t, names, "refs/heads/merged/unmerged")
+		require.NotContains(t, names, "refs/merged/c")
+	})
+}
+
+func TestFailedFindMergedBranches(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, _, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	testCases := []struct {
+		desc    string
+		request *pb.FindMergedBranchesRequest
+	}{
+		{
+			desc:    "empty target",
+			request: &pb.FindMergedBranchesRequest{Repository: testRepo},
+		},
+		{
+			desc:    "target starting with -",
+			request: &pb.FindMergedBranchesRequest{Repository: testRepo, Target: []byte("--all")},
+		},
+		{
+			desc:    "missing target",
+			request: &pb.FindMergedBranchesRequest{Repository: testRepo, Target: []byte("does-not-exist")},
+		},
+		{
+			desc:    "invalid repository",
+			request: &pb.FindMergedBranchesRequest{Repository: &pb.Repository{StorageName: "fake", RelativePath: "repo"}, Target: []byte("master")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := findMergedBranches(t, client, tc.request)
+			testhelper.AssertGrpcError(t, err, codes.InvalidArgument, "")
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package ref
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/helper/lines"
+)
+
+// errPageFull stops the listing of refs once a page has all its refs
+var errPageFull = errors.New("page is full")
+
+// refsPage selects the refs of a listing that match a name filter and are
+// on the requested page. The refs are filtered as they are read from
+// for-each-ref, so the listing is never held in memory.
+type refsPage struct {
+	// The ref prefixes the names are relative to, like refs/heads/
+	bases     []string
+	prefix    []byte
+	search    []byte
+	pageToken []byte
+	// When the refs are sorted by name, the page starts after the page
+	// token even if there is no ref with that name anymore. Otherwise the
+	// page is empty in that case.
+	sortedByName bool
+	limit        int
+	// When set, only these refs can be on the page
+	refs map[string]bool
+}
+
+func newRefsPage(bases []string, prefix, search []byte, params *pb.PaginationParameter, sortedByName bool) *refsPage {
+	// Some RPCs return names without their base, like FindAllTags. A page
+	// token that isn't a full ref name is taken relative to the base.
+	pageToken := params.GetPageToken()
+	if len(pageToken) > 0 && len(bases) == 1 && !bytes.HasPrefix(pageToken, []byte("refs/")) {
+		pageToken = append([]byte(bases[0]), pageToken...)
+	}
+
+	return &refsPage{
+		bases:        bases,
+		prefix:       prefix,
+		search:       search,
+		pageToken:    pageToken,
+		sortedByName: sortedByName,
+		limit:        int(params.GetLimit()),
+	}
+}
+
+// patterns returns the for-each-ref patterns that list all the refs the
+// page can have. The directories of the prefix narrow down the listing.
+func (p *refsPage) patterns() []string {
+	if patterns := p.refPatterns(); patterns != nil {
+		return patterns
+	}
+
+	var dir []byte
+	if i := bytes.LastIndexByte(p.prefix, '/'); i >= 0 {
+		dir = p.prefix[:i+1]
+	}
+
+	// for-each-ref would take these as wildcards
+	if bytes.ContainsAny(dir, "*?[\\") {
+		dir = nil
+	}
+
+	var patterns []string
+	for _, base := range p.bases {
+		patterns = append(patterns, base+string(dir))
+	}
+
+	return patterns
+}
+
+// refPatterns returns the refs of the page as for-each-ref patterns, or
+// nil if the page isn't restricted to some refs, or a ref can't be used as
+// a pattern, or there are more than maxRefPatterns refs. The whole bases
+// are listed then, and matches filters out the other refs.
+func (p *refsPage) refPatterns() []string {
+	if len(p.refs) > maxRefPatterns {
+		return nil
+	}
+
+	var patterns []string
+	for ref := range p.refs {
+		if strings.ContainsAny(ref, "*?[\\") {
+			return nil
+		}
+		patterns = append(patterns, ref)
+	}
+
+	sort.Strings(patterns)
+	return patterns
+}
+
+// refName returns the name of the ref listed on line, which can be
+// followed by other fields separated by NUL bytes.
+func refName(line []byte) []byte {
+	if i := bytes.IndexByte(line, 0); i >= 0 {
+		return line[:i]
+	}
+
+	return line
+}
+
+// matches returns true if the ref passes the name filters of the page
+func (p *refsPage) matches(ref []byte) bool {
+	name := ref
+	for _, base := range p.bases {
+		if bytes.HasPrefix(ref, []byte(base)) {
+			name = ref[len(base):]
+			break
+		}
+	}
+
+	// for-each-ref also lists the refs below the ref patterns
+	if p.refs != nil && !p.refs[string(ref)] {
+		return false
+	}
+
+	return bytes.HasPrefix(name, p.prefix) && bytes.Contains(name, p.search)
+}
+
+// sender wraps send so that it only sends the refs on the page. It returns
+// errPageFull once the page is complete.
+func (p *refsPage) sender(send lines.Sender) lines.Sender {
+	afterToken := len(p.pageToken) == 0
+	count := 0
+
+	return func(refs [][]byte) error {
+		var page [][]byte
+
+		for _, line := range refs {
+			ref := refName(line)
+
+			if !afterToken {
+				if p.sortedByName {
+					if bytes.Compare(ref, p.pageToken) <= 0 {
+						continue
+					}
+				} else {
+					afterToken = bytes.Equal(ref, p.pageToken)
+					continue
+				}
+
+				afterToken = true
+			}
+
+			if !p.matches(ref) {
+				continue
+			}
+
+			page = append(page, line)
+			count++
+
+			if p.limit > 0 && count >= p.limit {
+				break
+			}
+		}
+
+		if len(page) > 0 {
+			if err := send(page); err != nil {
+				return err
+			}
+		}
+
+		if p.limit > 0 && count >= p.limit {
+			return errPageFull
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,403 @@
+package ref
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+// createDatedCommit creates a commit on top of master with the given
+// committer date, and points ref to it
+func createDatedCommit(t *testing.T, repoPath, ref string, timestamp int64) {
+	date := fmt.Sprintf("%d +0000", timestamp)
+
+	cmd := exec.Command("git", "--git-dir", repoPath, "commit-tree", "-p", "master", "-m", ref, "master^{tree}")
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Scrooge McDuck",
+		"GIT_AUTHOR_EMAIL=scrooge@mcduck.com",
+		"GIT_AUTHOR_DATE="+date,
+		"GIT_COMMITTER_NAME=Scrooge McDuck",
+		"GIT_COMMITTER_EMAIL=scrooge@mcduck.com",
+		"GIT_COMMITTER_DATE="+date,
+	)
+
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", repoPath, "update-ref", ref, strings.TrimSpace(string(output)))
+}
+
+func findLocalBranchNames(t *testing.T, client pb.RefServiceClient, request *pb.FindLocalBranchesRequest) []string {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.FindLocalBranches(ctx, request)
+	require.NoError(t, err)
+
+	var names []string
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		for _, branch := range response.GetBranches() {
+			names = append(names, string(branch.Name))
+		}
+	}
+
+	return names
+}
+
+func findAllBranchNames(t *testing.T, client pb.RefServiceClient, request *pb.FindAllBranchesRequest) []string {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.FindAllBranches(ctx, request)
+	require.NoError(t, err)
+
+	var names []string
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		for _, branch := range response.GetBranches() {
+			names = append(names, string(branch.Name))
+		}
+	}
+
+	return names
+}
+
+func findAllTagNames(t *testing.T, client pb.RefServiceClient, request *pb.FindAllTagNamesRequest) []string {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.FindAllTagNames(ctx, request)
+	require.NoError(t, err)
+
+	var names []string
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		for _, name := range response.GetNames() {
+			names = append(names, string(name))
+		}
+	}
+
+	return names
+}
+
+func TestFindLocalBranchesPagination(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	for i := 1; i <= 5; i++ {
+		testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", fmt.Sprintf("refs/heads/pagination/%d", i), "master")
+	}
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", "refs/heads/paginations", "master")
+
+	testCases := []struct {
+		desc     string
+		request  *pb.FindLocalBranchesRequest
+		expected []string
+	}{
+		{
+			desc:     "prefix",
+			request:  &pb.FindLocalBranchesRequest{Prefix: []byte("pagination")},
+			expected: []string{"refs/heads/pagination/1", "refs/heads/pagination/2", "refs/heads/pagination/3", "refs/heads/pagination/4", "refs/heads/pagination/5", "refs/heads/paginations"},
+		},
+		{
+			desc:     "prefix with a directory",
+			request:  &pb.FindLocalBranchesRequest{Prefix: []byte("pagination/")},
+			expected: []string{"refs/heads/pagination/1", "refs/heads/pagination/2", "refs/heads/pagination/3", "refs/heads/pagination/4", "refs/heads/pagination/5"},
+		},
+		{
+			desc:     "search",
+			request:  &pb.FindLocalBranchesRequest{Search: []byte("ation/3")},
+			expected: []string{"refs/heads/pagination/3"},
+		},
+		{
+			desc: "first page",
+			request: &pb.FindLocalBranchesRequest{
+				Prefix:           []byte("pagination/"),
+				PaginationParams: &pb.PaginationParameter{Limit: 2},
+			},
+			expected: []string{"refs/heads/pagination/1", "refs/heads/pagination/2"},
+		},
+		{
+			desc: "next page",
+			request: &pb.FindLocalBranchesRequest{
+				Prefix:           []byte("pagination/"),
+				PaginationParams: &pb.PaginationParameter{PageToken: []byte("refs/heads/pagination/2"), Limit: 2},
+			},
+			expected: []string{"refs/heads/pagination/3", "refs/heads/pagination/4"},
+		},
+		{
+			desc: "last page",
+			request: &pb.FindLocalBranchesRequest{
+				Prefix:           []byte("pagination/"),
+				PaginationParams: &pb.PaginationParameter{PageToken: []byte("refs/heads/pagination/4"), Limit: 2},
+			},
+			expected: []string{"refs/heads/pagination/5"},
+		},
+		{
+			desc: "page token of a deleted branch",
+			request: &pb.FindLocalBranchesRequest{
+				Prefix:           []byte("pagination/"),
+				PaginationParams: &pb.PaginationParameter{PageToken: []byte("refs/heads/pagination/25")},
+			},
+			expected: []string{"refs/heads/pagination/3", "refs/heads/pagination/4", "refs/heads/pagination/5"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.request.Repository = testRepo
+			require.Equal(t, tc.expected, findLocalBranchNames(t, client, tc.request))
+		})
+	}
+}
+
+func TestFindLocalBranchesPaginationSortedByDate(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	createDatedCommit(t, testRepoPath, "refs/heads/dated/a", 1500000003)
+	createDatedCommit(t, testRepoPath, "refs/heads/dated/b", 1500000001)
+	createDatedCommit(t, testRepoPath, "refs/heads/dated/c", 1500000002)
+	// Same date as dated/c, so it comes after it by name
+	createDatedCommit(t, testRepoPath, "refs/heads/dated/d", 1500000002)
+
+	testCases := []struct {
+		desc     string
+		request  *pb.FindLocalBranchesRequest
+		expected []string
+	}{
+		{
+			desc:     "ascending",
+			request:  &pb.FindLocalBranchesRequest{SortBy: pb.FindLocalBranchesRequest_UPDATED_ASC},
+			expected: []string{"refs/heads/dated/b", "refs/heads/dated/c", "refs/heads/dated/d", "refs/heads/dated/a"},
+		},
+		{
+			desc:     "descending",
+			request:  &pb.FindLocalBranchesRequest{SortBy: pb.FindLocalBranchesRequest_UPDATED_DESC},
+			expected: []string{"refs/heads/dated/a", "refs/heads/dated/c", "refs/heads/dated/d", "refs/heads/dated/b"},
+		},
+		{
+			desc: "next page",
+			request: &pb.FindLocalBranchesRequest{
+				SortBy:           pb.FindLocalBranchesRequest_UPDATED_ASC,
+				PaginationParams: &pb.PaginationParameter{PageToken: []byte("refs/heads/dated/c"), Limit: 2},
+			},
+			expected: []string{"refs/heads/dated/d", "refs/heads/dated/a"},
+		},
+		{
+			desc: "page token of a deleted branch",
+			request: &pb.FindLocalBranchesRequest{
+				SortBy:           pb.FindLocalBranchesRequest_UPDATED_ASC,
+				PaginationParams: &pb.PaginationParameter{PageToken: []byte("refs/heads/dated/0")},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.request.Repository = testRepo
+			tc.request.Prefix = []byte("dated/")
+			require.Equal(t, tc.expected, findLocalBranchNames(t, client, tc.request))
+		})
+	}
+}
+
+func TestFindAllBranchesPagination(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", "refs/heads/origin/pagination", "master")
+	createRemoteBranch(t, testRepoPath, "origin", "pagination/1", "master")
+	createRemoteBranch(t, testRepoPath, "origin", "pagination/2", "master")
+
+	testCases := []struct {
+		desc     string
+		request  *pb.FindAllBranchesRequest
+		expected []string
+	}{
+		{
+			desc:     "prefix",
+			request:  &pb.FindAllBranchesRequest{Prefix: []byte("origin/pagination")},
+			expected: []string{"refs/heads/origin/pagination", "refs/remotes/origin/pagination/1", "refs/remotes/origin/pagination/2"},
+		},
+		{
+			desc: "next page",
+			request: &pb.FindAllBranchesRequest{
+				Prefix:           []byte("origin/pagination"),
+				PaginationParams: &pb.PaginationParameter{PageToken: []byte("refs/heads/origin/pagination"), Limit: 1},
+			},
+			expected: []string{"refs/remotes/origin/pagination/1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.request.Repository = testRepo
+			require.Equal(t, tc.expected, findAllBranchNames(t, client, tc.request))
+		})
+	}
+}
+
+func TestFindAllTagNamesPagination(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	for _, version := range []string{"1.9", "1.10", "1.2"} {
+		testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "tag", "pagination/v"+version, "master")
+	}
+
+	testCases := []struct {
+		desc     string
+		request  *pb.FindAllTagNamesRequest
+		expected []string
+	}{
+		{
+			desc:     "sorted by name",
+			request:  &pb.FindAllTagNamesRequest{},
+			expected: []string{"refs/tags/pagination/v1.10", "refs/tags/pagination/v1.2", "refs/tags/pagination/v1.9"},
+		},
+		{
+			desc:     "sorted by version",
+			request:  &pb.FindAllTagNamesRequest{SortBy: pb.FindLocalBranchesRequest_VERSION_ASC},
+			expected: []string{"refs/tags/pagination/v1.2", "refs/tags/pagination/v1.9", "refs/tags/pagination/v1.10"},
+		},
+		{
+			desc:     "sorted by version descending",
+			request:  &pb.FindAllTagNamesRequest{SortBy: pb.FindLocalBranchesRequest_VERSION_DESC},
+			expected: []string{"refs/tags/pagination/v1.10", "refs/tags/pagination/v1.9", "refs/tags/pagination/v1.2"},
+		},
+		{
+			desc: "next page sorted by version",
+			request: &pb.FindAllTagNamesRequest{
+				SortBy:           pb.FindLocalBranchesRequest_VERSION_ASC,
+				PaginationParams: &pb.PaginationParameter{PageToken: []byte("refs/tags/pagination/v1.2"), Limit: 1},
+			},
+			expected: []string{"refs/tags/pagination/v1.9"},
+		},
+		{
+			desc:     "search",
+			request:  &pb.FindAllTagNamesRequest{Search: []byte("v1.1")},
+			expected: []string{"refs/tags/pagination/v1.10"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.request.Repository = testRepo
+			tc.request.Prefix = []byte("pagination/")
+			require.Equal(t, tc.expected, findAllTagNames(t, client, tc.request))
+		})
+	}
+}
+
+func TestInvalidSortBy(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	// Unknown enum values are valid protobuf
+	sortBy := pb.FindLocalBranchesRequest_SortBy(99)
+
+	testCases := []struct {
+		desc string
+		call func(ctx context.Context) error
+	}{
+		{
+			desc: "FindLocalBranches",
+			call: func(ctx context.Context) error {
+				stream, err := client.FindLocalBranches(ctx, &pb.FindLocalBranchesRequest{Repository: testRepo, SortBy: sortBy})
+				require.NoError(t, err)
+				_, err = stream.Recv()
+				return err
+			},
+		},
+		{
+			desc: "FindAllBranches",
+			call: func(ctx context.Context) error {
+				stream, err := client.FindAllBranches(ctx, &pb.FindAllBranchesRequest{Repository: testRepo, SortBy: sortBy})
+				require.NoError(t, err)
+				_, err = stream.Recv()
+				return err
+			},
+		},
+		{
+			desc: "FindAllTagNames",
+			call: func(ctx context.Context) error {
+				stream, err := client.FindAllTagNames(ctx, &pb.FindAllTagNamesRequest{Repository: testRepo, SortBy: sortBy})
+				require.NoError(t, err)
+				_, err = stream.Recv()
+				return err
+			},
+		},
+		{
+			desc: "FindAllTags",
+			call: func(ctx context.Context) error {
+				stream, err := client.FindAllTags(ctx, &pb.FindAllTagsRequest{Repository: testRepo, SortBy: sortBy})
+				require.NoError(t, err)
+				_, err = stream.Recv()
+				return err
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			testhelper.AssertGrpcError(t, tc.call(ctx), codes.InvalidArgument, "unknown sort key")
+		})
+	}
+}
@@ -15,7 +15,6 @@ import (
 	"gitlab.com/gitlab-org/gitaly/internal/command"
 	"gitlab.com/gitlab-org/gitaly/internal/helper"
 	"gitlab.com/gitlab-org/gitaly/internal/helper/lines"
-	"gitlab.com/gitlab-org/gitaly/internal/rubyserver"
 	"golang.org/x/net/context"
 )
 
@@ -31,6 +30,9 @@ var (
 type findRefsOpts struct {
 	cmdArgs []string
 	delim   []byte
+	// When set, only the refs on this page are sent and the patterns are
+	// taken from the page
+	page *refsPage
 }
 
 func findRefs(ctx context.Context, writer lines.Sender, repo *pb.Repository, patterns []string, opts *findRefsOpts) error {
@@ -43,6 +45,16 @@ func findRefs(ctx context.Context, writer lines.Sender, repo *pb.Repository, pat
 		return err
 	}
 
+	if opts.page != nil {
+		patterns = opts.page.patterns()
+		writer = opts.page.sender(writer)
+
+		// Canceling the context stops for-each-ref once the page is full
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+	}
+
 	baseArgs := []string{"--git-dir", repoPath, "for-each-ref"}
 
 	var args []string
@@ -58,7 +70,9 @@ func findRefs(ctx context.Context, writer lines.Sender, repo *pb.Repository, pat
 		return err
 	}
 
-	if err := lines.Send(cmd, writer, opts.delim); err != nil {
+	if err := lines.Send(cmd, writer, opts.delim); err == errPageFull {
+		return nil
+	} else if err != nil {
 		return err
 	}
 
@@ -70,36 +84,22 @@ func (s *server) FindAllBranchNames(in *pb.FindAllBranchNamesRequest, stream pb.
 	return findRefs(stream.Context(), newFindAllBranchNamesWriter(stream), in.Repository, []string{"refs/heads"}, &findRefsOpts{})
 }
 
-// FindAllTagNames creates a stream of ref names for all tags in the given repository
+// FindAllTagNames creates a stream of ref names for all tags in the given
+// repository, or for one page of them
 func (s *server) FindAllTagNames(in *pb.FindAllTagNamesRequest, stream pb.RefService_FindAllTagNamesServer) error {
-	return findRefs(stream.Context(), newFindAllTagNamesWriter(stream), in.Repository, []string{"refs/tags"}, &findRefsOpts{})
-}
-
-func (s *server) FindAllTags(in *pb.FindAllTagsRequest, stream pb.RefService_FindAllTagsServer) error {
-	ctx := stream.Context()
-
-	client, err := s.RefServiceClient(ctx)
+	// Annotated tags are sorted by their own date rather than the date of
+	// the commit they point to
+	sort, err := sortArgs(in.GetSortBy(), "creatordate")
 	if err != nil {
-		return err
+		return grpc.Errorf(codes.InvalidArgument, "FindAllTagNames: %v", err)
 	}
 
-	clientCtx, err := rubyserver.SetHeaders(ctx, in.GetRepository())
-	if err != nil {
-		return err
-	}
-
-	rubyStream, err := client.FindAllTags(clientCtx, in)
-	if err != nil {
-		return err
+	opts := &findRefsOpts{
+		cmdArgs: append([]string{"--format=%(refname)"}, sort...),
+		page:    newRefsPage([]string{"refs/tags/"}, in.GetPrefix(), in.GetSearch(), in.GetPaginationParams(), in.GetSortBy() == pb.FindLocalBranchesRequest_NAME),
 	}
 
-	return rubyserver.Proxy(func() error {
-		resp, err := rubyStream.Recv()
-		if err != nil {
-			return err
-		}
-		return stream.Send(resp)
-	})
+	return findRefs(stream.Context(), newFindAllTagNamesWriter(stream), in.Repository, nil, opts)
 }
 
 func _findBranchNames(ctx context.Context, repoPath string) ([][]byte, error) {
@@ -211,41 +211,72 @@ func (s *server) FindDefaultBranchName(ctx context.Context, in *pb.FindDefaultBr
 	return &pb.FindDefaultBranchNameResponse{Name: defaultBranchName}, nil
 }
 
-func parseSortKey(sortKey pb.FindLocalBranchesRequest_SortBy) string {
+func parseSortKey(sortKey pb.FindLocalBranchesRequest_SortBy, dateField string) (string, error) {
 	switch sortKey {
 	case pb.FindLocalBranchesRequest_NAME:
-		return "refname"
+		return "refname", nil
 	case pb.FindLocalBranchesRequest_UPDATED_ASC:
-		return "committerdate"
+		return dateField, nil
 	case pb.FindLocalBranchesRequest_UPDATED_DESC:
-		return "-committerdate"
+		return "-" + dateField, nil
+	case pb.FindLocalBranchesRequest_VERSION_ASC:
+		return "version:refname", nil
+	case pb.FindLocalBranchesRequest_VERSION_DESC:
+		return "-version:refname", nil
 	}
 
-	panic("never reached") // famous last words
+	return "", fmt.Errorf("unknown sort key: %v", sortKey)
+}
+
+// sortArgs returns the for-each-ref arguments to sort by sortKey. Refs that
+// sort equally are sorted by name, so that pages don't overlap.
+func sortArgs(sortKey pb.FindLocalBranchesRequest_SortBy, dateField string) ([]string, error) {
+	key, err := parseSortKey(sortKey, dateField)
+	if err != nil {
+		return nil, err
+	}
+
+	if sortKey == pb.FindLocalBranchesRequest_NAME {
+		return []string{"--sort=" + key}, nil
+	}
+
+	// The last --sort is the primary key
+	return []string{"--sort=refname", "--sort=" + key}, nil
 }
 
 // FindLocalBranches creates a stream of branches for all local branches in the given repository
 func (s *server) FindLocalBranches(in *pb.FindLocalBranchesRequest, stream pb.RefService_FindLocalBranchesServer) error {
+	sort, err := sortArgs(in.GetSortBy(), "committerdate")
+	if err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "FindLocalBranches: %v", err)
+	}
+
 	writer := newFindLocalBranchesWriter(stream)
 	opts := &findRefsOpts{
-		cmdArgs: []string{
+		cmdArgs: append([]string{
 			// %00 inserts the null character into the output (see for-each-ref docs)
 			"--format=" + strings.Join(localBranchFormatFields, "%00"),
-			"--sort=" + parseSortKey(in.GetSortBy()),
-		},
+		}, sort...),
+		page: newRefsPage([]string{"refs/heads/"}, in.GetPrefix(), in.GetSearch(), in.GetPaginationParams(), in.GetSortBy() == pb.FindLocalBranchesRequest_NAME),
 	}
 
-	return findRefs(stream.Context(), writer, in.Repository, []string{"refs/heads"}, opts)
+	return findRefs(stream.Context(), writer, in.Repository, nil, opts)
 }
 
 func (s *server) FindAllBranches(in *pb.FindAllBranchesRequest, stream pb.RefService_FindAllBranchesServer) error {
+	sort, err := sortArgs(in.GetSortBy(), "committerdate")
+	if err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "FindAllBranches: %v", err)
+	}
+
 	opts := &findRefsOpts{
-		cmdArgs: []string{
+		cmdArgs: append([]string{
 			// %00 inserts the null character into the output (see for-each-ref docs)
 			"--format=" + strings.Join(localBranchFormatFields, "%00"),
-		},
+		}, sort...),
+		page: newRefsPage([]string{"refs/heads/", "refs/remotes/"}, in.GetPrefix(), in.GetSearch(), in.GetPaginationParams(), in.GetSortBy() == pb.FindLocalBranchesRequest_NAME),
 	}
 	writer := newFindAllBranchesWriter(stream)
 
-	return findRefs(stream.Context(), writer, in.Repository, []string{"refs/heads", "refs/remotes"}, opts)
+	return findRefs(stream.Context(), writer, in.Repository, nil, opts)
 }
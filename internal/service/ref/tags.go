@@ -0,0 +1,255 @@
+package ref
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/git/catfile"
+	"gitlab.com/gitlab-org/gitaly/internal/git/log"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+	"gitlab.com/gitlab-org/gitaly/internal/helper/lines"
+	"gitlab.com/gitlab-org/gitaly/streamio"
+)
+
+var tagsPrefix = []byte("refs/tags/")
+
+// FindAllTags streams the tags of the repository, or one page of them,
+// with the commits they point to. The tag objects and commits are read by
+// a single cat-file process as for-each-ref lists the tags.
+func (s *server) FindAllTags(in *pb.FindAllTagsRequest, stream pb.RefService_FindAllTagsServer) error {
+	sort, err := sortArgs(in.GetSortBy(), "creatordate")
+	if err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "FindAllTags: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+
+	err = catfile.CatFile(ctx, repoPath, func(stdin io.Writer, stdout *bufio.Reader) error {
+		resolver := &tagResolver{stdin: stdin, stdout: stdout, maxMessageSize: in.GetMaxMessageSize()}
+
+		opts := &findRefsOpts{
+			cmdArgs: append([]string{"--format=%(refname)%00%(objectname)%00%(objecttype)"}, sort...),
+			page:    newRefsPage([]string{string(tagsPrefix)}, in.GetPrefix(), in.GetSearch(), in.GetPaginationParams(), in.GetSortBy() == pb.FindLocalBranchesRequest_NAME),
+		}
+
+		return findRefs(ctx, newFindAllTagsWriter(stream, resolver), in.GetRepository(), nil, opts)
+	})
+	if err != nil {
+		return grpc.Errorf(codes.Internal, "FindAllTags: %v", err)
+	}
+
+	return nil
+}
+
+func newFindAllTagsWriter(stream pb.RefService_FindAllTagsServer, resolver *tagResolver) lines.Sender {
+	return func(refs [][]byte) error {
+		var tags []*pb.FindAllTagsResponse_Tag
+		size := 0
+
+		for _, ref := range refs {
+			tag, err := resolver.resolve(ref)
+			if err != nil {
+				return err
+			}
+
+			tags = append(tags, tag)
+			size += proto.Size(tag)
+
+			// Messages and commits can make a tag much larger than its ref
+			if size > lines.MaxMsgSize {
+				if err := stream.Send(&pb.FindAllTagsResponse{Tags: tags}); err != nil {
+					return err
+				}
+				tags, size = nil, 0
+			}
+		}
+
+		if len(tags) == 0 {
+			return nil
+		}
+
+		return stream.Send(&pb.FindAllTagsResponse{Tags: tags})
+	}
+}
+
+// tagResolver reads the tag objects and target commits of tags from
+// git cat-file --batch
+type tagResolver struct {
+	stdin          io.Writer
+	stdout         *bufio.Reader
+	maxMessageSize int64
+}
+
+// resolve returns the tag of a ref listed with the fields refname,
+// objectname and objecttype. Annotated tags are peeled until they reach an
+// object that isn't a tag; the tag only has a target commit if that object
+// is a commit.
+func (r *tagResolver) resolve(ref []byte) (*pb.FindAllTagsResponse_Tag, error) {
+	fields := bytes.Split(ref, []byte{0})
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("invalid tag ref: %q", ref)
+	}
+
+	tag := &pb.FindAllTagsResponse_Tag{
+		Name: bytes.TrimPrefix(fields[0], tagsPrefix),
+		Id:   string(fields[1]),
+	}
+
+	objectID, objectType := string(fields[1]), string(fields[2])
+	for annotated := false; objectType == "tag"; annotated = true {
+		content, err := r.readObject(objectID, "tag")
+		if err != nil {
+			return nil, err
+		}
+
+		tagObject := parseTagObject(content)
+		if !annotated {
+			// The message and tagger are the ones of the outermost tag
+			tag.Message, tag.MessageSize = truncateMessage(tagObject.message, r.maxMessageSize)
+			tag.Tagger = tagObject.tagger
+		}
+
+		objectID, objectType = tagObject.object, tagObject.objectType
+	}
+
+	if objectType == "commit" {
+		content, err := r.readObject(objectID, "commit")
+		if err != nil {
+			return nil, err
+		}
+
+		if tag.TargetCommit, err = log.ParseRawCommit(objectID, content); err != nil {
+			return nil, err
+		}
+	}
+
+	return tag, nil
+}
+
+func (r *tagResolver) readObject(objectID, objectType string) ([]byte, error) {
+	info, content, err := catfile.ReadObject(r.stdin, r.stdout, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Type != objectType {
+		return nil, fmt.Errorf("%s is not a %s", objectID, objectType)
+	}
+
+	return content, nil
+}
+
+func truncateMessage(message []byte, maxSize int64) ([]byte, int64) {
+	size := int64(len(message))
+	if maxSize > 0 && size > maxSize {
+		return message[:maxSize], size
+	}
+
+	return message, size
+}
+
+type tagObject struct {
+	object     string
+	objectType string
+	tagger     *pb.CommitAuthor
+	message    []byte
+}
+
+// parseTagObject parses the content of an annotated tag object. Like
+// Gitlab::Git, one trailing newline is removed from the message.
+func parseTagObject(content []byte) *tagObject {
+	tag := &tagObject{}
+
+	header, message := content, []byte(nil)
+	if i := bytes.Index(content, []byte("\n\n")); i >= 0 {
+		header, message = content[:i], content[i+2:]
+	}
+
+	for _, line := range bytes.Split(header, []byte("\n")) {
+		fields := bytes.SplitN(line, []byte(" "), 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		switch string(fields[0]) {
+		case "object":
+			tag.object = string(fields[1])
+		case "type":
+			tag.objectType = string(fields[1])
+		case "tagger":
+			tag.tagger = log.ParseCommitAuthor(fields[1])
+		}
+	}
+
+	message = bytes.TrimSuffix(message, []byte("\n"))
+	tag.message = bytes.TrimSuffix(message, []byte("\r"))
+
+	return tag
+}
+
+// GetTagMessages streams the full messages of annotated tags, which can be
+// truncated by FindAllTags
+func (s *server) GetTagMessages(in *pb.GetTagMessagesRequest, stream pb.RefService_GetTagMessagesServer) error {
+	for _, tagID := range in.GetTagIds() {
+		if !objectIDRegex.MatchString(tagID) {
+			return grpc.Errorf(codes.InvalidArgument, "GetTagMessages: invalid tag ID: %q", tagID)
+		}
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return err
+	}
+
+	return catfile.CatFile(stream.Context(), repoPath, func(stdin io.Writer, stdout *bufio.Reader) error {
+		for _, tagID := range in.GetTagIds() {
+			info, content, err := catfile.ReadObject(stdin, stdout, tagID)
+			if err != nil {
+				return grpc.Errorf(codes.Internal, "GetTagMessages: %v", err)
+			}
+
+			if info.Type != "tag" {
+				return grpc.Errorf(codes.NotFound, "GetTagMessages: tag not found: %s", tagID)
+			}
+
+			if err := sendTagMessage(stream, tagID, parseTagObject(content).message); err != nil {
+				return grpc.Errorf(codes.Unavailable, "GetTagMessages: send: %v", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func sendTagMessage(stream pb.RefService_GetTagMessagesServer, tagID string, message []byte) error {
+	firstResponse := &pb.GetTagMessagesResponse{TagId: tagID}
+	if len(message) == 0 {
+		return stream.Send(firstResponse)
+	}
+
+	sw := streamio.NewWriter(func(p []byte) error {
+		response := &pb.GetTagMessagesResponse{}
+		if firstResponse != nil {
+			response = firstResponse
+			firstResponse = nil
+		}
+		response.Message = p
+
+		return stream.Send(response)
+	})
+
+	_, err := sw.Write(message)
+	return err
+}
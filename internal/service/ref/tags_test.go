@@ -0,0 +1,315 @@
+package ref
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/git/log"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+	"gitlab.com/gitlab-org/gitaly/streamio"
+)
+
+// createAnnotatedTag creates an annotated tag of target by Scrooge McDuck
+// and returns the ID of the tag object
+func createAnnotatedTag(t *testing.T, repoPath, name, target, message string) string {
+	cmd := exec.Command("git", "--git-dir", repoPath, "tag", "-F", "-", name, target)
+	cmd.Stdin = strings.NewReader(message)
+	cmd.Env = append(os.Environ(),
+		"GIT_COMMITTER_NAME=Scrooge McDuck",
+		"GIT_COMMITTER_EMAIL=scrooge@mcduck.com",
+		"GIT_COMMITTER_DATE=1500000000 +0200",
+	)
+
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	return showRef(t, repoPath, "refs/tags/"+name)
+}
+
+func findAllTags(t *testing.T, client pb.RefServiceClient, request *pb.FindAllTagsRequest) []*pb.FindAllTagsResponse_Tag {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.FindAllTags(ctx, request)
+	require.NoError(t, err)
+
+	var tags []*pb.FindAllTagsResponse_Tag
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		tags = append(tags, response.GetTags()...)
+	}
+
+	return tags
+}
+
+func TestFindAllTagsAnnotatedTags(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	masterID := showRef(t, testRepoPath, "master")
+	masterCommit, err := log.GetCommit(ctx, testRepo, masterID, "")
+	require.NoError(t, err)
+
+	blobID := showRef(t, testRepoPath, "master:README.md")
+
+	annotatedID := createAnnotatedTag(t, testRepoPath, "annotated/commit", "master", "Commit tag\n\nWith a body\n")
+	nestedID := createAnnotatedTag(t, testRepoPath, "annotated/nested", annotatedID, "Nested tag")
+	blobTagID := createAnnotatedTag(t, testRepoPath, "annotated/blob", blobID, "Blob tag")
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "tag", "annotated/lightweight", "master")
+
+	tagger := &pb.CommitAuthor{
+		Name:  []byte("Scrooge McDuck"),
+		Email: []byte("scrooge@mcduck.com"),
+		Date:  &timestamp.Timestamp{Seconds: 1500000000},
+	}
+
+	expected := []*pb.FindAllTagsResponse_Tag{
+		{
+			Name:        []byte("annotated/blob"),
+			Id:          blobTagID,
+			Message:     []byte("Blob tag"),
+			MessageSize: 8,
+			Tagger:      tagger,
+		},
+		{
+			Name:         []byte("annotated/commit"),
+			Id:           annotatedID,
+			TargetCommit: masterCommit,
+			Message:      []byte("Commit tag\n\nWith a body"),
+			MessageSize:  23,
+			Tagger:       tagger,
+		},
+		{
+			Name:         []byte("annotated/lightweight"),
+			Id:           masterID,
+			TargetCommit: masterCommit,
+		},
+		{
+			Name:         []byte("annotated/nested"),
+			Id:           nestedID,
+			TargetCommit: masterCommit,
+			Message:      []byte("Nested tag"),
+			MessageSize:  10,
+			Tagger:       tagger,
+		},
+	}
+
+	tags := findAllTags(t, client, &pb.FindAllTagsRequest{Repository: testRepo, Prefix: []byte("annotated/")})
+	require.Equal(t, expected, tags)
+}
+
+func TestFindAllTagsMaxMessageSize(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	createAnnotatedTag(t, testRepoPath, "truncated/long", "master", "A long tag message")
+	createAnnotatedTag(t, testRepoPath, "truncated/short", "master", "Short")
+
+	request := &pb.FindAllTagsRequest{
+		Repository:     testRepo,
+		Prefix:         []byte("truncated/"),
+		MaxMessageSize: 6,
+	}
+	tags := findAllTags(t, client, request)
+	require.Len(t, tags, 2)
+
+	require.Equal(t, []byte("A long"), tags[0].Message)
+	require.Equal(t, int64(18), tags[0].MessageSize)
+	require.Equal(t, []byte("Short"), tags[1].Message)
+	require.Equal(t, int64(5), tags[1].MessageSize)
+}
+
+func TestFindAllTagsPagination(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	for _, name := range []string{"a", "b", "c"} {
+		createAnnotatedTag(t, testRepoPath, "pagination/"+name, "master", name)
+	}
+
+	request := &pb.FindAllTagsRequest{
+		Repository:       testRepo,
+		Prefix:           []byte("pagination/"),
+		PaginationParams: &pb.PaginationParameter{PageToken: []byte("refs/tags/pagination/a"), Limit: 1},
+	}
+	tags := findAllTags(t, client, request)
+	require.Len(t, tags, 1)
+	require.Equal(t, []byte("pagination/b"), tags[0].Name)
+	require.Equal(t, []byte("b"), tags[0].Message)
+}
+
+func TestFindAllTagsPaginationWithReturnedNames(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	// Names sorting before and after "refs"
+	for _, name := range []string{"a-tag", "z-tag"} {
+		createAnnotatedTag(t, testRepoPath, name, "master", name)
+	}
+
+	var expected [][]byte
+	for _, tag := range findAllTags(t, client, &pb.FindAllTagsRequest{Repository: testRepo}) {
+		expected = append(expected, tag.Name)
+	}
+
+	// The name of the last tag of a page is the token of the next page
+	var names [][]byte
+	params := &pb.PaginationParameter{Limit: 1}
+	for len(names) <= len(expected) {
+		tags := findAllTags(t, client, &pb.FindAllTagsRequest{Repository: testRepo, PaginationParams: params})
+		if len(tags) == 0 {
+			break
+		}
+
+		names = append(names, tags[0].Name)
+		params = &pb.PaginationParameter{PageToken: tags[0].Name, Limit: 1}
+	}
+
+	require.Equal(t, expected, names)
+}
+
+func TestSuccessfulGetTagMessages(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	defer func(oldBufferSize int) {
+		streamio.WriteBufferSize = oldBufferSize
+	}(streamio.WriteBufferSize)
+	streamio.WriteBufferSize = 5
+
+	// git tag strips the trailing whitespace of the lines of the message
+	longMessage := strings.TrimSpace(strings.Repeat("A tag message that spans several responses. ", 3))
+	longTagID := createAnnotatedTag(t, testRepoPath, "messages/long", "master", longMessage+"\n")
+	emptyTagID := createAnnotatedTag(t, testRepoPath, "messages/empty", "master", "")
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	request := &pb.GetTagMessagesRequest{Repository: testRepo, TagIds: []string{longTagID, emptyTagID}}
+	stream, err := client.GetTagMessages(ctx, request)
+	require.NoError(t, err)
+
+	messages := make(map[string]string)
+	var tagIDs []string
+	var currentID string
+	for {
+		response, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if response.TagId != "" {
+			currentID = response.TagId
+			tagIDs = append(tagIDs, currentID)
+		}
+		messages[currentID] += string(response.Message)
+	}
+
+	require.Equal(t, []string{longTagID, emptyTagID}, tagIDs)
+	require.Equal(t, longMessage, messages[longTagID])
+	require.Equal(t, "", messages[emptyTagID])
+}
+
+func TestFailedGetTagMessages(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	masterID := showRef(t, testRepoPath, "master")
+
+	testCases := []struct {
+		desc    string
+		request *pb.GetTagMessagesRequest
+		code    codes.Code
+	}{
+		{
+			desc:    "invalid repository",
+			request: &pb.GetTagMessagesRequest{Repository: &pb.Repository{StorageName: "fake", RelativePath: "repo"}},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "invalid tag ID",
+			request: &pb.GetTagMessagesRequest{Repository: testRepo, TagIds: []string{"master"}},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "commit ID",
+			request: &pb.GetTagMessagesRequest{Repository: testRepo, TagIds: []string{masterID}},
+			code:    codes.NotFound,
+		},
+		{
+			desc:    "missing object",
+			request: &pb.GetTagMessagesRequest{Repository: testRepo, TagIds: []string{strings.Repeat("1", 40)}},
+			code:    codes.NotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			stream, err := client.GetTagMessages(ctx, tc.request)
+			require.NoError(t, err)
+
+			var recvError error
+			for recvError == nil {
+				_, recvError = stream.Recv()
+			}
+
+			testhelper.AssertGrpcError(t, recvError, tc.code, "")
+		})
+	}
+}
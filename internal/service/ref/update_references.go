@@ -0,0 +1,120 @@
+package ref
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+var (
+	objectIDRegex = regexp.MustCompile(`\A[0-9a-f]{40}\z`)
+	// update-ref names the reference it failed on in quotes
+	failedReferenceRegex = regexp.MustCompile(`'(refs/[^']*)'`)
+)
+
+// UpdateReferences applies a batch of reference updates in a single
+// transaction: if any old value doesn't match, no reference is updated.
+func (s *server) UpdateReferences(stream pb.RefService_UpdateReferencesServer) error {
+	firstRequest, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	repoPath, err := helper.GetRepoPath(firstRequest.GetRepository())
+	if err != nil {
+		return err
+	}
+
+	var stdin bytes.Buffer
+	for request := firstRequest; ; {
+		for _, update := range request.GetUpdates() {
+			if err := validateReferenceUpdate(update); err != nil {
+				return grpc.Errorf(codes.InvalidArgument, "UpdateReferences: %v", err)
+			}
+
+			// update SP <ref> NUL <newvalue> NUL [<oldvalue>] NUL
+			fmt.Fprintf(&stdin, "update %s\x00%s\x00%s\x00", update.GetReference(), update.GetNewObjectId(), update.GetOldObjectId())
+		}
+
+		request, err = stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if stdin.Len() == 0 {
+		return stream.SendAndClose(&pb.UpdateReferencesResponse{})
+	}
+
+	err = runUpdateRef(stream.Context(), repoPath, &stdin)
+	if updateErr, ok := err.(updateRefError); ok {
+		if match := failedReferenceRegex.FindStringSubmatch(updateErr.message); match != nil {
+			return grpc.Errorf(codes.FailedPrecondition, "UpdateReferences: update of %s failed: %s", match[1], updateErr.message)
+		}
+		return grpc.Errorf(codes.FailedPrecondition, "UpdateReferences: %s", updateErr.message)
+	} else if err != nil {
+		return grpc.Errorf(codes.Internal, "UpdateReferences: %v", err)
+	}
+
+	return stream.SendAndClose(&pb.UpdateReferencesResponse{})
+}
+
+func validateReferenceUpdate(update *pb.UpdateReferencesRequest_Update) error {
+	reference := string(update.GetReference())
+	if !isValidRefName(reference) || strings.ContainsRune(reference, 0) {
+		return fmt.Errorf("invalid reference name: %q", reference)
+	}
+
+	if !objectIDRegex.MatchString(update.GetNewObjectId()) {
+		return fmt.Errorf("%s: invalid new object ID: %q", reference, update.GetNewObjectId())
+	}
+
+	if oldObjectID := update.GetOldObjectId(); oldObjectID != "" && !objectIDRegex.MatchString(oldObjectID) {
+		return fmt.Errorf("%s: invalid old object ID: %q", reference, oldObjectID)
+	}
+
+	return nil
+}
+
+// updateRefError is the reason git update-ref rejected a transaction
+type updateRefError struct {
+	message string
+}
+
+func (e updateRefError) Error() string {
+	return e.message
+}
+
+// runUpdateRef applies the commands read from stdin, in the NUL-separated
+// format of git update-ref -z --stdin, in a single transaction.
+func runUpdateRef(ctx context.Context, repoPath string, stdin io.Reader) error {
+	var stderr bytes.Buffer
+
+	args := []string{"--git-dir", repoPath, "update-ref", "-z", "--stdin"}
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), args...), stdin, nil, &stderr)
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := command.ExitStatus(err); ok {
+			return updateRefError{message: string(bytes.TrimSpace(stderr.Bytes()))}
+		}
+		return err
+	}
+
+	return nil
+}
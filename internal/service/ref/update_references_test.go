@@ -0,0 +1,153 @@
+package ref
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+const nullID = "0000000000000000000000000000000000000000"
+
+func updateReferences(t *testing.T, client pb.RefServiceClient, repo *pb.Repository, batches ...[]*pb.UpdateReferencesRequest_Update) error {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.UpdateReferences(ctx)
+	require.NoError(t, err)
+
+	request := &pb.UpdateReferencesRequest{Repository: repo}
+	for _, updates := range batches {
+		request.Updates = updates
+		require.NoError(t, stream.Send(request))
+		request = &pb.UpdateReferencesRequest{}
+	}
+	if len(batches) == 0 {
+		require.NoError(t, stream.Send(request))
+	}
+
+	_, err = stream.CloseAndRecv()
+	return err
+}
+
+func showRef(t *testing.T, repoPath, ref string) string {
+	return strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", repoPath, "rev-parse", "--verify", "--quiet", ref)))
+}
+
+func TestSuccessfulUpdateReferencesRequest(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	masterID := showRef(t, testRepoPath, "refs/heads/master")
+	featureID := showRef(t, testRepoPath, "refs/heads/feature")
+
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "update-ref", "refs/keep-around/"+featureID, featureID)
+
+	err := updateReferences(t, client, testRepo,
+		[]*pb.UpdateReferencesRequest_Update{
+			{Reference: []byte("refs/keep-around/" + masterID), OldObjectId: nullID, NewObjectId: masterID},
+			{Reference: []byte("refs/merge-requests/1/head"), NewObjectId: featureID},
+		},
+		[]*pb.UpdateReferencesRequest_Update{
+			{Reference: []byte("refs/heads/master"), OldObjectId: masterID, NewObjectId: featureID},
+			{Reference: []byte("refs/keep-around/" + featureID), OldObjectId: featureID, NewObjectId: nullID},
+			{Reference: []byte("refs/environments/production/deployments/1"), NewObjectId: masterID},
+		},
+	)
+	require.NoError(t, err)
+
+	require.Equal(t, masterID, showRef(t, testRepoPath, "refs/keep-around/"+masterID))
+	require.Equal(t, featureID, showRef(t, testRepoPath, "refs/merge-requests/1/head"))
+	require.Equal(t, featureID, showRef(t, testRepoPath, "refs/heads/master"))
+	require.Equal(t, masterID, showRef(t, testRepoPath, "refs/environments/production/deployments/1"))
+
+	refs := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "for-each-ref", "refs/keep-around/"+featureID))
+	require.Empty(t, refs)
+}
+
+func TestSuccessfulUpdateReferencesRequestWithoutUpdates(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, _, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	require.NoError(t, updateReferences(t, client, testRepo))
+}
+
+func TestFailedUpdateReferencesRequest(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	masterID := showRef(t, testRepoPath, "refs/heads/master")
+	featureID := showRef(t, testRepoPath, "refs/heads/feature")
+
+	validUpdate := &pb.UpdateReferencesRequest_Update{Reference: []byte("refs/heads/gitaly-batch"), NewObjectId: masterID}
+
+	testCases := []struct {
+		desc          string
+		update        *pb.UpdateReferencesRequest_Update
+		code          codes.Code
+		failedRefName string
+	}{
+		{
+			desc:   "reference outside of refs/",
+			update: &pb.UpdateReferencesRequest_Update{Reference: []byte("HEAD"), NewObjectId: masterID},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "invalid new object ID",
+			update: &pb.UpdateReferencesRequest_Update{Reference: []byte("refs/heads/feature"), NewObjectId: "master"},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:   "invalid old object ID",
+			update: &pb.UpdateReferencesRequest_Update{Reference: []byte("refs/heads/feature"), OldObjectId: "abc", NewObjectId: masterID},
+			code:   codes.InvalidArgument,
+		},
+		{
+			desc:          "old value doesn't match",
+			update:        &pb.UpdateReferencesRequest_Update{Reference: []byte("refs/heads/feature"), OldObjectId: masterID, NewObjectId: masterID},
+			code:          codes.FailedPrecondition,
+			failedRefName: "refs/heads/feature",
+		},
+		{
+			desc:          "reference already exists",
+			update:        &pb.UpdateReferencesRequest_Update{Reference: []byte("refs/heads/master"), OldObjectId: nullID, NewObjectId: featureID},
+			code:          codes.FailedPrecondition,
+			failedRefName: "refs/heads/master",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := updateReferences(t, client, testRepo, []*pb.UpdateReferencesRequest_Update{validUpdate, tc.update})
+			testhelper.AssertGrpcError(t, err, tc.code, tc.failedRefName)
+
+			// Nothing of the batch was applied
+			refs := string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "for-each-ref", "refs/heads/gitaly-batch"))
+			require.Empty(t, refs)
+			require.Equal(t, masterID, showRef(t, testRepoPath, "refs/heads/master"))
+			require.Equal(t, featureID, showRef(t, testRepoPath, "refs/heads/feature"))
+		})
+	}
+}
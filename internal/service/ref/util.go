@@ -2,6 +2,7 @@ package ref
 
 import (
 	"bytes"
+	"strings"
 
 	pb "gitlab.com/gitlab-org/gitaly-proto/go"
 	"gitlab.com/gitlab-org/gitaly/internal/git"
@@ -16,6 +17,43 @@ var localBranchFormatFields = []string{
 	"%(committeremail)", "%(committerdate:iso-strict)",
 }
 
+// branchFormatFields reads the full target commit of a branch, with its
+// parents and message
+var branchFormatFields = append(append([]string{}, localBranchFormatFields...), "%(parent)", "%(contents)")
+
+// maxRefPatterns is the number of refs above which they aren't passed to
+// for-each-ref one by one, so that its arguments stay far below the
+// argument size limit.
+var maxRefPatterns = 100
+
+// refListPatterns returns for-each-ref patterns that list at least the
+// given refs. Above maxRefPatterns refs, it returns the namespaces they are
+// in, like refs/heads, and the caller filters out the other refs.
+func refListPatterns(refs []string) []string {
+	if len(refs) <= maxRefPatterns {
+		return refs
+	}
+
+	namespaces := make(map[string]bool)
+	var patterns []string
+	for _, ref := range refs {
+		namespace := ref
+		// refs/<namespace>/... lists as refs/<namespace>
+		if i := strings.IndexByte(ref, '/'); i >= 0 {
+			if j := strings.IndexByte(ref[i+1:], '/'); j >= 0 {
+				namespace = ref[:i+1+j]
+			}
+		}
+
+		if !namespaces[namespace] {
+			namespaces[namespace] = true
+			patterns = append(patterns, namespace)
+		}
+	}
+
+	return patterns
+}
+
 func parseRef(ref []byte) ([][]byte, error) {
 	elements := bytes.Split(ref, []byte("\x00"))
 	if len(elements) != 9 {
@@ -29,6 +67,23 @@ func buildCommitFromBranchInfo(elements [][]byte) (*pb.GitCommit, error) {
 		elements[3], elements[4], elements[5], elements[6], elements[7])
 }
 
+// buildBranchCommit returns the target commit of a branch read with
+// branchFormatFields. The emails are returned without angle brackets like
+// log.GetCommit does.
+func buildBranchCommit(elements [][]byte) (*pb.GitCommit, error) {
+	commit, err := buildCommitFromBranchInfo(elements[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	commit.Author.Email = bytes.TrimSuffix(bytes.TrimPrefix(commit.Author.Email, []byte("<")), []byte(">"))
+	commit.Committer.Email = bytes.TrimSuffix(bytes.TrimPrefix(commit.Committer.Email, []byte("<")), []byte(">"))
+	commit.ParentIds = strings.Fields(string(elements[len(localBranchFormatFields)]))
+	commit.Body = elements[len(localBranchFormatFields)+1]
+
+	return commit, nil
+}
+
 func buildLocalBranch(elements [][]byte) (*pb.FindLocalBranchResponse, error) {
 	target, err := buildCommitFromBranchInfo(elements[1:])
 	if err != nil {
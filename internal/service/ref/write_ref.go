@@ -0,0 +1,209 @@
+package ref
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+// WriteRef points a reference at a revision. HEAD is written as a symbolic
+// reference, which changes the default branch of the repository.
+func (s *server) WriteRef(ctx context.Context, in *pb.WriteRefRequest) (*pb.WriteRefResponse, error) {
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateWriteRefRequest(ctx, repoPath, in); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "WriteRef: %v", err)
+	}
+
+	ref, revision, oldRevision := string(in.GetRef()), string(in.GetRevision()), string(in.GetOldRevision())
+
+	if ref == "HEAD" {
+		err = writeHead(ctx, repoPath, revision, oldRevision)
+	} else {
+		err = writeRef(ctx, repoPath, ref, revision, oldRevision)
+	}
+
+	switch err.(type) {
+	case nil:
+		return &pb.WriteRefResponse{}, nil
+	case updateRefError:
+		return nil, grpc.Errorf(codes.FailedPrecondition, "WriteRef: %v", err)
+	case revisionNotFoundError:
+		return nil, grpc.Errorf(codes.InvalidArgument, "WriteRef: %v", err)
+	default:
+		return nil, grpc.Errorf(codes.Internal, "WriteRef: %v", err)
+	}
+}
+
+func validateWriteRefRequest(ctx context.Context, repoPath string, in *pb.WriteRefRequest) error {
+	ref, revision := string(in.GetRef()), string(in.GetRevision())
+
+	if ref != "HEAD" {
+		if err := checkRefFormat(ctx, repoPath, ref); err != nil {
+			return err
+		}
+	}
+
+	if len(revision) == 0 {
+		return fmt.Errorf("empty revision")
+	}
+
+	// A leading dash would be parsed as an option by git
+	if strings.HasPrefix(revision, "-") || strings.ContainsRune(revision, 0) {
+		return fmt.Errorf("invalid revision: %q", revision)
+	}
+
+	if ref == "HEAD" {
+		// HEAD can only point to a branch
+		if !strings.HasPrefix(revision, localBranchPrefix) {
+			return fmt.Errorf("HEAD must point to a branch: %q", revision)
+		}
+
+		if err := checkRefFormat(ctx, repoPath, revision); err != nil {
+			return err
+		}
+
+		if oldRevision := in.GetOldRevision(); len(oldRevision) > 0 && !bytes.HasPrefix(oldRevision, []byte("refs/")) {
+			return fmt.Errorf("invalid old revision for HEAD: %q", oldRevision)
+		}
+	} else if oldRevision := string(in.GetOldRevision()); oldRevision != "" && !objectIDRegex.MatchString(oldRevision) {
+		return fmt.Errorf("invalid old revision: %q", oldRevision)
+	}
+
+	return nil
+}
+
+// checkRefFormat validates a full reference name with the same rules as
+// git uses when creating references.
+func checkRefFormat(ctx context.Context, repoPath, ref string) error {
+	if !isValidRefName(ref) || strings.ContainsRune(ref, 0) {
+		return invalidRefNameError{ref: ref}
+	}
+
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "check-ref-format", ref)
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := command.ExitStatus(err); ok {
+			return invalidRefNameError{ref: ref}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// invalidRefNameError means a reference name was rejected by checkRefFormat
+type invalidRefNameError struct {
+	ref string
+}
+
+func (e invalidRefNameError) Error() string {
+	return fmt.Sprintf("invalid reference name: %q", e.ref)
+}
+
+// revisionNotFoundError means the revision to write doesn't exist
+type revisionNotFoundError struct {
+	revision string
+}
+
+func (e revisionNotFoundError) Error() string {
+	return fmt.Sprintf("revision not found: %q", e.revision)
+}
+
+func writeRef(ctx context.Context, repoPath, ref, revision, oldRevision string) error {
+	objectID, err := resolveRevision(ctx, repoPath, revision)
+	if err != nil {
+		return err
+	}
+
+	// update SP <ref> NUL <newvalue> NUL [<oldvalue>] NUL
+	stdin := fmt.Sprintf("update %s\x00%s\x00%s\x00", ref, objectID, oldRevision)
+	return runUpdateRef(ctx, repoPath, strings.NewReader(stdin))
+}
+
+// resolveRevision returns the object ID revision points to
+func resolveRevision(ctx context.Context, repoPath, revision string) (string, error) {
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "rev-parse", "--verify", "--quiet", revision)
+	if err != nil {
+		return "", err
+	}
+
+	var stdout bytes.Buffer
+	if _, err := stdout.ReadFrom(cmd); err != nil {
+		return "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		if _, ok := command.ExitStatus(err); ok {
+			return "", revisionNotFoundError{revision: revision}
+		}
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(stdout.Bytes())), nil
+}
+
+// writeHead makes HEAD a symbolic reference to branch. git has no
+// compare-and-swap for symbolic references, so oldBranch is checked just
+// before HEAD is written.
+func writeHead(ctx context.Context, repoPath, branch, oldBranch string) error {
+	if _, err := resolveRevision(ctx, repoPath, branch); err != nil {
+		return err
+	}
+
+	if oldBranch != "" {
+		currentBranch, err := symbolicHead(ctx, repoPath)
+		if err != nil {
+			return err
+		}
+
+		if currentBranch != oldBranch {
+			return updateRefError{message: fmt.Sprintf("HEAD points to %q instead of %q", currentBranch, oldBranch)}
+		}
+	}
+
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "symbolic-ref", "HEAD", branch)
+	if err != nil {
+		return err
+	}
+
+	return cmd.Wait()
+}
+
+// symbolicHead returns the reference HEAD points to, or an empty string if
+// HEAD is detached.
+func symbolicHead(ctx context.Context, repoPath string) (string, error) {
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "symbolic-ref", "--quiet", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	var stdout bytes.Buffer
+	if _, err := stdout.ReadFrom(cmd); err != nil {
+		return "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		// Exit code 1: HEAD is not a symbolic reference
+		if code, ok := command.ExitStatus(err); ok && code == 1 {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return string(bytes.TrimSpace(stdout.Bytes())), nil
+}
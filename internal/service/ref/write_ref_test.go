@@ -0,0 +1,233 @@
+package ref
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+func symbolicRef(t *testing.T, repoPath, ref string) string {
+	return strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--git-dir", repoPath, "symbolic-ref", ref)))
+}
+
+func TestSuccessfulWriteRefRequest(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	masterID := showRef(t, testRepoPath, "refs/heads/master")
+	featureID := showRef(t, testRepoPath, "refs/heads/feature")
+	tagID := showRef(t, testRepoPath, "refs/tags/v1.0.0")
+
+	testCases := []struct {
+		desc        string
+		ref         string
+		revision    string
+		oldRevision string
+		expectedID  string
+	}{
+		{
+			desc:       "create a reference from an object ID",
+			ref:        "refs/keep-around/" + masterID,
+			revision:   masterID,
+			expectedID: masterID,
+		},
+		{
+			desc:       "create a reference from another reference",
+			ref:        "refs/heads/feature-copy",
+			revision:   "refs/heads/feature",
+			expectedID: featureID,
+		},
+		{
+			desc:       "a tag is not peeled",
+			ref:        "refs/environments/production/deployments/1",
+			revision:   "v1.0.0",
+			expectedID: tagID,
+		},
+		{
+			desc:        "only create a new reference",
+			ref:         "refs/heads/new-branch",
+			revision:    featureID,
+			oldRevision: nullID,
+			expectedID:  featureID,
+		},
+		{
+			desc:        "update with the expected old value",
+			ref:         "refs/heads/feature-copy",
+			revision:    masterID,
+			oldRevision: featureID,
+			expectedID:  masterID,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			_, err := client.WriteRef(ctx, &pb.WriteRefRequest{
+				Repository:  testRepo,
+				Ref:         []byte(tc.ref),
+				Revision:    []byte(tc.revision),
+				OldRevision: []byte(tc.oldRevision),
+			})
+			require.NoError(t, err)
+
+			require.Equal(t, tc.expectedID, showRef(t, testRepoPath, tc.ref))
+		})
+	}
+}
+
+func TestSuccessfulWriteRefRequestForHead(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	_, err := client.WriteRef(ctx, &pb.WriteRefRequest{
+		Repository:  testRepo,
+		Ref:         []byte("HEAD"),
+		Revision:    []byte("refs/heads/feature"),
+		OldRevision: []byte("refs/heads/master"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "refs/heads/feature", symbolicRef(t, testRepoPath, "HEAD"))
+
+	response, err := client.FindDefaultBranchName(ctx, &pb.FindDefaultBranchNameRequest{Repository: testRepo})
+	require.NoError(t, err)
+	require.Equal(t, "refs/heads/feature", string(response.Name))
+
+	_, err = client.WriteRef(ctx, &pb.WriteRefRequest{
+		Repository: testRepo,
+		Ref:        []byte("HEAD"),
+		Revision:   []byte("refs/heads/merged-branch"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "refs/heads/merged-branch", symbolicRef(t, testRepoPath, "HEAD"))
+}
+
+func TestFailedWriteRefRequest(t *testing.T) {
+	server := runRefServiceServer(t)
+	defer server.Stop()
+
+	client, conn := newRefServiceClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	masterID := showRef(t, testRepoPath, "refs/heads/master")
+	featureID := showRef(t, testRepoPath, "refs/heads/feature")
+
+	testCases := []struct {
+		desc    string
+		request *pb.WriteRefRequest
+		code    codes.Code
+	}{
+		{
+			desc:    "empty reference",
+			request: &pb.WriteRefRequest{Repository: testRepo, Revision: []byte(masterID)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "reference outside of refs/",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("FETCH_HEAD"), Revision: []byte(masterID)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "reference rejected by check-ref-format",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("refs/heads/a..b"), Revision: []byte(masterID)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "reference ending with .lock",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("refs/heads/feature.lock"), Revision: []byte(masterID)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "empty revision",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("refs/heads/feature")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "revision looking like an option",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("refs/heads/feature"), Revision: []byte("--all")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "revision not found",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("refs/heads/feature"), Revision: []byte("does-not-exist")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "invalid old revision",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("refs/heads/feature"), Revision: []byte(masterID), OldRevision: []byte("master")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "old revision doesn't match",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("refs/heads/feature"), Revision: []byte(masterID), OldRevision: []byte(masterID)},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "reference already exists",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("refs/heads/feature"), Revision: []byte(masterID), OldRevision: []byte(nullID)},
+			code:    codes.FailedPrecondition,
+		},
+		{
+			desc:    "HEAD to an invalid reference",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("HEAD"), Revision: []byte("feature")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "HEAD to a tag",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("HEAD"), Revision: []byte("refs/tags/v1.0.0")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "HEAD to a remote branch",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("HEAD"), Revision: []byte("refs/remotes/origin/master")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "HEAD to a missing branch",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("HEAD"), Revision: []byte("refs/heads/does-not-exist")},
+			code:    codes.InvalidArgument,
+		},
+		{
+			desc:    "HEAD doesn't point to the old branch",
+			request: &pb.WriteRefRequest{Repository: testRepo, Ref: []byte("HEAD"), Revision: []byte("refs/heads/feature"), OldRevision: []byte("refs/heads/feature")},
+			code:    codes.FailedPrecondition,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			_, err := client.WriteRef(ctx, tc.request)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+
+	require.Equal(t, featureID, showRef(t, testRepoPath, "refs/heads/feature"))
+	require.Equal(t, "refs/heads/master", symbolicRef(t, testRepoPath, "HEAD"))
+}
@@ -2,26 +2,38 @@ package service
 
 import (
 	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/healthcheck"
 	"gitlab.com/gitlab-org/gitaly/internal/rubyserver"
 	"gitlab.com/gitlab-org/gitaly/internal/service/blob"
 	"gitlab.com/gitlab-org/gitaly/internal/service/commit"
+	"gitlab.com/gitlab-org/gitaly/internal/service/conflicts"
 	"gitlab.com/gitlab-org/gitaly/internal/service/diff"
 	"gitlab.com/gitlab-org/gitaly/internal/service/namespace"
 	"gitlab.com/gitlab-org/gitaly/internal/service/notifications"
+	"gitlab.com/gitlab-org/gitaly/internal/service/operations"
 	"gitlab.com/gitlab-org/gitaly/internal/service/ref"
 	"gitlab.com/gitlab-org/gitaly/internal/service/renameadapter"
 	"gitlab.com/gitlab-org/gitaly/internal/service/repository"
+	"gitlab.com/gitlab-org/gitaly/internal/service/server"
 	"gitlab.com/gitlab-org/gitaly/internal/service/smarthttp"
 	"gitlab.com/gitlab-org/gitaly/internal/service/ssh"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// Services that proxy some of their RPCs to gitaly-ruby, including the
+// deprecated ones
+var rubyServices = []string{
+	"gitaly.DiffService",
+	"gitaly.CommitService",
+	"gitaly.Diff",
+	"gitaly.Commit",
+}
+
 // RegisterAll will register all the known grpc services with
 // the specified grpc service instance
-func RegisterAll(grpcServer *grpc.Server, rubyServer *rubyserver.Server) {
+func RegisterAll(grpcServer *grpc.Server, rubyServer *rubyserver.Server, healthReporter *healthcheck.Reporter) {
 	notificationsService := notifications.NewServer()
 	pb.RegisterNotificationServiceServer(grpcServer, notificationsService)
 
@@ -49,6 +61,16 @@ func RegisterAll(grpcServer *grpc.Server, rubyServer *rubyserver.Server) {
 	namespaceService := namespace.NewServer()
 	pb.RegisterNamespaceServiceServer(grpcServer, namespaceService)
 
+	operationService := operations.NewServer()
+	pb.RegisterOperationServiceServer(grpcServer, operationService)
+
+	conflictsService := conflicts.NewServer()
+	pb.RegisterConflictsServiceServer(grpcServer, conflictsService)
+
+	// Only reports the status of gitaly-ruby
+	serverService := server.NewServer(rubyServer)
+	pb.RegisterServerServiceServer(grpcServer, serverService)
+
 	// Deprecated Services
 	pb.RegisterNotificationsServer(grpcServer, renameadapter.NewNotificationAdapter(notificationsService))
 	pb.RegisterRefServer(grpcServer, renameadapter.NewRefAdapter(refService))
@@ -57,5 +79,10 @@ func RegisterAll(grpcServer *grpc.Server, rubyServer *rubyserver.Server) {
 	pb.RegisterCommitServer(grpcServer, renameadapter.NewCommitAdapter(commitService))
 	pb.RegisterSSHServer(grpcServer, renameadapter.NewSSHAdapter(sshService))
 
-	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	var services []string
+	for name := range grpcServer.GetServiceInfo() {
+		services = append(services, name)
+	}
+	healthReporter.SetServices(services, rubyServices)
+	healthpb.RegisterHealthServer(grpcServer, healthReporter)
 }
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"gitlab.com/gitlab-org/gitaly/internal/healthcheck"
+)
+
+func TestRubyServicesAreRegistered(t *testing.T) {
+	grpcServer := grpc.NewServer()
+	RegisterAll(grpcServer, nil, healthcheck.NewReporter(nil))
+
+	registered := grpcServer.GetServiceInfo()
+	for _, name := range rubyServices {
+		_, ok := registered[name]
+		require.True(t, ok, "service %q is not registered", name)
+	}
+
+	require.NotContains(t, rubyServices, "gitaly.RefService")
+	require.NotContains(t, rubyServices, "gitaly.Ref")
+}
@@ -0,0 +1,249 @@
+package repository
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+const listRepositoriesBatchSize = 100
+
+// listRepositoriesDirsPerSecond limits how many directories ListRepositories
+// reads per second, so that walking a large storage doesn't starve other
+// requests of disk I/O. Zero means no limit.
+var listRepositoriesDirsPerSecond = 1000
+
+// Suffixes GitLab gives to repositories that are about to be removed or are
+// being moved
+var trashSuffixes = []string{"+deleted", "+moved", "+tmp"}
+
+func (s *server) ListRepositories(in *pb.ListRepositoriesRequest, stream pb.RepositoryService_ListRepositoriesServer) error {
+	storagePath, err := helper.GetStorageByName(in.GetStorageName())
+	if err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	walker := &repositoryWalker{
+		ctx:         ctx,
+		storagePath: storagePath,
+		req:         in,
+		sender:      &repositoryInfoSender{stream: stream},
+	}
+
+	if listRepositoriesDirsPerSecond > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(listRepositoriesDirsPerSecond))
+		defer ticker.Stop()
+		walker.throttle = ticker.C
+	}
+
+	if err := walker.walk(storagePath); err != nil {
+		// Failed sends already carry their status
+		if grpc.Code(err) == codes.Unavailable {
+			return err
+		}
+		if ctx.Err() != nil {
+			return grpc.Errorf(codes.Canceled, "ListRepositories: %v", ctx.Err())
+		}
+		return grpc.Errorf(codes.Internal, "ListRepositories: %v", err)
+	}
+
+	return walker.sender.flush()
+}
+
+type repositoryWalker struct {
+	ctx         context.Context
+	storagePath string
+	req         *pb.ListRepositoriesRequest
+	sender      *repositoryInfoSender
+	// Receives a value each time a directory may be read, nil if unlimited
+	throttle <-chan time.Time
+}
+
+// walk looks for repositories in dir and its subdirectories. Directories
+// that can't be read are logged and skipped, except for the storage itself.
+func (w *repositoryWalker) walk(dir string) error {
+	names, err := w.readDirNames(dir)
+	if err != nil {
+		if dir == w.storagePath || w.ctx.Err() != nil {
+			return err
+		}
+
+		grpc_logrus.Extract(w.ctx).WithError(err).WithField("path", dir).Warn("ListRepositories: skipping unreadable directory")
+		return nil
+	}
+
+	for _, name := range names {
+		if skipDirectory(name) {
+			continue
+		}
+
+		subdir := path.Join(dir, name)
+		if fi, err := os.Lstat(subdir); err != nil || !fi.IsDir() {
+			continue
+		}
+
+		if !helper.IsGitDirectory(subdir) {
+			if err := w.walk(subdir); err != nil {
+				return err
+			}
+			continue
+		}
+
+		info, err := w.repositoryInfo(subdir)
+		if err != nil {
+			return err
+		}
+
+		if err := w.sender.send(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *repositoryWalker) readDirNames(dir string) ([]string, error) {
+	if w.throttle != nil {
+		select {
+		case <-w.ctx.Done():
+			return nil, w.ctx.Err()
+		case <-w.throttle:
+		}
+	} else if err := w.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdirnames(-1)
+}
+
+func (w *repositoryWalker) repositoryInfo(repoPath string) (*pb.ListRepositoriesResponse_RepositoryInfo, error) {
+	relativePath, err := filepath.Rel(w.storagePath, repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &pb.ListRepositoriesResponse_RepositoryInfo{RelativePath: relativePath}
+
+	if w.req.GetIncludeMtime() {
+		info.ModifiedAt = &timestamp.Timestamp{Seconds: repositoryModTime(repoPath).Unix()}
+	}
+
+	if w.req.GetIncludeSize() {
+		if info.Size, err = w.diskUsage(repoPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// diskUsage returns the total size in bytes of the files in dir and its
+// subdirectories.
+func (w *repositoryWalker) diskUsage(dir string) (int64, error) {
+	names, err := w.readDirNames(dir)
+	if err != nil {
+		if w.ctx.Err() != nil {
+			return 0, err
+		}
+		// Files can disappear while git is running, e.g. during a repack
+		return 0, nil
+	}
+
+	var size int64
+	for _, name := range names {
+		fi, err := os.Lstat(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		if !fi.IsDir() {
+			size += fi.Size()
+			continue
+		}
+
+		subdirSize, err := w.diskUsage(path.Join(dir, name))
+		if err != nil {
+			return 0, err
+		}
+		size += subdirSize
+	}
+
+	return size, nil
+}
+
+// repositoryModTime approximates the last time a repository was written to
+// by the most recent modification time of its directory and its refs.
+func repositoryModTime(repoPath string) time.Time {
+	var modTime time.Time
+
+	for _, p := range []string{repoPath, path.Join(repoPath, "packed-refs"), path.Join(repoPath, "refs", "heads")} {
+		if fi, err := os.Stat(p); err == nil && fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+
+	return modTime
+}
+
+// skipDirectory returns true for hidden directories, directories used by
+// Gitaly itself and repositories that are being deleted or moved.
+func skipDirectory(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "+") {
+		return true
+	}
+
+	name = strings.TrimSuffix(name, ".git")
+	for _, suffix := range trashSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type repositoryInfoSender struct {
+	stream pb.RepositoryService_ListRepositoriesServer
+	batch  []*pb.ListRepositoriesResponse_RepositoryInfo
+}
+
+func (s *repositoryInfoSender) send(info *pb.ListRepositoriesResponse_RepositoryInfo) error {
+	s.batch = append(s.batch, info)
+	if len(s.batch) < listRepositoriesBatchSize {
+		return nil
+	}
+
+	return s.flush()
+}
+
+func (s *repositoryInfoSender) flush() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+
+	err := s.stream.Send(&pb.ListRepositoriesResponse{Repositories: s.batch})
+	s.batch = nil
+	if err != nil {
+		return grpc.Errorf(codes.Unavailable, "ListRepositories: send: %v", err)
+	}
+
+	return nil
+}
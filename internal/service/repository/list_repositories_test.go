@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/config"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+func setupListRepositoriesStorage(t *testing.T) (string, func()) {
+	storagePath, err := ioutil.TempDir("", "gitaly-list-repositories")
+	require.NoError(t, err)
+
+	for _, repo := range []string{
+		"top-level.git",
+		"group/project.git",
+		"group/project.wiki.git",
+		"group/subgroup/project.git",
+		"group/removed+123+deleted.git",
+		"group/transferred+moved.git",
+		".hidden/project.git",
+		"+gitaly/tmp/project.git",
+	} {
+		testhelper.MustRunCommand(t, nil, "git", "init", "--bare", "--quiet", path.Join(storagePath, repo))
+	}
+
+	require.NoError(t, os.MkdirAll(path.Join(storagePath, "empty-group"), 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(storagePath, "group", "file.txt"), []byte("not a repository"), 0644))
+
+	oldStorages := config.Config.Storages
+	config.Config.Storages = append(config.Config.Storages, config.Storage{Name: "list-repositories", Path: storagePath})
+
+	return storagePath, func() {
+		config.Config.Storages = oldStorages
+		os.RemoveAll(storagePath)
+	}
+}
+
+func TestSuccessfulListRepositories(t *testing.T) {
+	_, cleanupFn := setupListRepositoriesStorage(t)
+	defer cleanupFn()
+
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	testCases := []struct {
+		desc         string
+		includeMtime bool
+		includeSize  bool
+	}{
+		{desc: "paths only"},
+		{desc: "with mtime", includeMtime: true},
+		{desc: "with size", includeSize: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			request := &pb.ListRepositoriesRequest{
+				StorageName:  "list-repositories",
+				IncludeMtime: tc.includeMtime,
+				IncludeSize:  tc.includeSize,
+			}
+			stream, err := client.ListRepositories(ctx, request)
+			require.NoError(t, err)
+
+			var paths []string
+			for {
+				resp, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+
+				for _, repo := range resp.Repositories {
+					paths = append(paths, repo.RelativePath)
+
+					if tc.includeMtime {
+						require.NotNil(t, repo.ModifiedAt)
+						require.NotZero(t, repo.ModifiedAt.Seconds)
+					} else {
+						require.Nil(t, repo.ModifiedAt)
+					}
+
+					if tc.includeSize {
+						require.NotZero(t, repo.Size)
+					} else {
+						require.Zero(t, repo.Size)
+					}
+				}
+			}
+
+			sort.Strings(paths)
+			expected := []string{
+				"group/project.git",
+				"group/project.wiki.git",
+				"group/subgroup/project.git",
+				"top-level.git",
+			}
+			require.Equal(t, expected, paths)
+		})
+	}
+}
+
+func TestFailedListRepositoriesDueToInvalidStorage(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	stream, err := client.ListRepositories(ctx, &pb.ListRepositoriesRequest{StorageName: "does-not-exist"})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	require.Error(t, err)
+}
+
+func TestListRepositoriesStopsWhenCanceled(t *testing.T) {
+	storagePath, cleanupFn := setupListRepositoriesStorage(t)
+	defer cleanupFn()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	walker := &repositoryWalker{
+		ctx:         ctx,
+		storagePath: storagePath,
+		req:         &pb.ListRepositoriesRequest{},
+		sender:      &repositoryInfoSender{},
+	}
+
+	require.Equal(t, context.Canceled, walker.walk(storagePath))
+	require.Empty(t, walker.sender.batch)
+}
+
+type failingListRepositoriesStream struct {
+	pb.RepositoryService_ListRepositoriesServer
+}
+
+func (failingListRepositoriesStream) Send(*pb.ListRepositoriesResponse) error {
+	return io.ErrClosedPipe
+}
+
+func TestListRepositoriesSendFailureIsUnavailable(t *testing.T) {
+	// Both full batches sent during the walk and the final partial batch
+	for _, count := range []int{listRepositoriesBatchSize, 1} {
+		sender := &repositoryInfoSender{stream: failingListRepositoriesStream{}}
+
+		var err error
+		for i := 0; i < count && err == nil; i++ {
+			err = sender.send(&pb.ListRepositoriesResponse_RepositoryInfo{RelativePath: "repo.git"})
+		}
+		if err == nil {
+			err = sender.flush()
+		}
+
+		require.Equal(t, codes.Unavailable, grpc.Code(err))
+	}
+}
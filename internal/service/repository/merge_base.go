@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/git"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// FindMergeBase returns the best common ancestor of the revisions, or all
+// of them when requested. A missing common ancestor is reported as
+// NotFound.
+func (s *server) FindMergeBase(ctx context.Context, in *pb.FindMergeBaseRequest) (*pb.FindMergeBaseResponse, error) {
+	if err := validateFindMergeBaseRequest(in); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "FindMergeBase: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"--git-dir", repoPath, "merge-base"}
+	if in.GetOctopus() {
+		args = append(args, "--octopus")
+	}
+	if in.GetAll() {
+		args = append(args, "--all")
+	}
+	for _, revision := range in.GetRevisions() {
+		args = append(args, string(revision))
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd, err := command.New(ctx, exec.Command(command.GitPath(), args...), nil, &stdout, &stderr)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "FindMergeBase: cmd: %v", err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		status, ok := command.ExitStatus(err)
+		switch {
+		case ok && status == 1:
+			// merge-base exits with 1 and prints nothing when there is no
+			// common ancestor
+			return nil, grpc.Errorf(codes.NotFound, "FindMergeBase: no common ancestor")
+		case ok:
+			return nil, grpc.Errorf(codes.InvalidArgument, "FindMergeBase: %s", strings.TrimSpace(stderr.String()))
+		default:
+			return nil, grpc.Errorf(codes.Internal, "FindMergeBase: %v", err)
+		}
+	}
+
+	return &pb.FindMergeBaseResponse{Bases: strings.Fields(stdout.String())}, nil
+}
+
+func validateFindMergeBaseRequest(in *pb.FindMergeBaseRequest) error {
+	if len(in.GetRevisions()) < 2 {
+		return fmt.Errorf("at least 2 revisions are required")
+	}
+
+	for _, revision := range in.GetRevisions() {
+		if err := git.ValidateRevision(revision); err != nil {
+			return fmt.Errorf("revision: %v", err)
+		}
+	}
+
+	return nil
+}
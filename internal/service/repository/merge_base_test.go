@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+// commitTree creates a commit with the tree of master and the given
+// parents, and returns its ID
+func commitTree(t *testing.T, repoPath, message string, parents ...string) string {
+	args := []string{"--git-dir", repoPath, "-c", "user.name=Scrooge McDuck", "-c", "user.email=scrooge@mcduck.com", "commit-tree", "-m", message}
+	for _, parent := range parents {
+		args = append(args, "-p", parent)
+	}
+	args = append(args, "master^{tree}")
+
+	return strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", args...)))
+}
+
+func TestSuccessfulFindMergeBase(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	onMaster := commitTree(t, testRepoPath, "onMaster", "master")
+	onMaster1 := commitTree(t, testRepoPath, "onMaster1", "master~1")
+	onMaster2 := commitTree(t, testRepoPath, "onMaster2", "master~2")
+
+	// A criss-cross history has two best common ancestors
+	left := commitTree(t, testRepoPath, "left", "master")
+	right := commitTree(t, testRepoPath, "right", "master")
+	crissCross1 := commitTree(t, testRepoPath, "crissCross1", left, right)
+	crissCross2 := commitTree(t, testRepoPath, "crissCross2", right, left)
+	crissCrossBases := []string{left, right}
+	sort.Strings(crissCrossBases)
+
+	testCases := []struct {
+		desc    string
+		request *pb.FindMergeBaseRequest
+		bases   []string
+	}{
+		{
+			desc:    "two revisions",
+			request: &pb.FindMergeBaseRequest{Revisions: [][]byte{[]byte(onMaster), []byte(onMaster1)}},
+			bases:   []string{testhelper.RevParse(t, testRepoPath, "master~1")},
+		},
+		{
+			desc:    "more than two revisions",
+			request: &pb.FindMergeBaseRequest{Revisions: [][]byte{[]byte(onMaster), []byte(onMaster1), []byte(onMaster2)}},
+			bases:   []string{testhelper.RevParse(t, testRepoPath, "master~1")},
+		},
+		{
+			desc:    "octopus",
+			request: &pb.FindMergeBaseRequest{Revisions: [][]byte{[]byte(onMaster), []byte(onMaster1), []byte(onMaster2)}, Octopus: true},
+			bases:   []string{testhelper.RevParse(t, testRepoPath, "master~2")},
+		},
+		{
+			desc:    "all bases",
+			request: &pb.FindMergeBaseRequest{Revisions: [][]byte{[]byte(crissCross1), []byte(crissCross2)}, All: true},
+			bases:   crissCrossBases,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tc.request.Repository = testRepo
+			response, err := client.FindMergeBase(ctx, tc.request)
+			require.NoError(t, err)
+
+			bases := response.GetBases()
+			sort.Strings(bases)
+			require.Equal(t, tc.bases, bases)
+		})
+	}
+}
+
+func TestFailedFindMergeBase(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	orphan := commitTree(t, testRepoPath, "orphan")
+
+	testCases := []struct {
+		desc      string
+		revisions []string
+		code      codes.Code
+	}{
+		{
+			desc:      "one revision",
+			revisions: []string{"master"},
+			code:      codes.InvalidArgument,
+		},
+		{
+			desc:      "revision starting with -",
+			revisions: []string{"master", "--all"},
+			code:      codes.InvalidArgument,
+		},
+		{
+			desc:      "missing revision",
+			revisions: []string{"master", "does-not-exist"},
+			code:      codes.InvalidArgument,
+		},
+		{
+			desc:      "no common ancestor",
+			revisions: []string{"master", orphan},
+			code:      codes.NotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			request := &pb.FindMergeBaseRequest{Repository: testRepo}
+			for _, revision := range tc.revisions {
+				request.Revisions = append(request.Revisions, []byte(revision))
+			}
+
+			_, err := client.FindMergeBase(ctx, request)
+			testhelper.AssertGrpcError(t, err, tc.code, "")
+		})
+	}
+}
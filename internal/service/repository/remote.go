@@ -0,0 +1,334 @@
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+var (
+	validRemoteName = regexp.MustCompile(`\A[a-zA-Z0-9_][a-zA-Z0-9_.-]*\z`)
+	// scp-like syntax understood by git, e.g. git@gitlab.com:gitlab-org/gitaly.git
+	scpLikeURL = regexp.MustCompile(`\A[a-zA-Z0-9_.-]+@[a-zA-Z0-9_.-]+:[^-]`)
+)
+
+var allowedURLSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+	"ssh":   true,
+	"git":   true,
+}
+
+// git config exits with this status when unsetting a key that doesn't exist
+const gitConfigNoSuchKey = 5
+
+func (s *server) AddRemote(ctx context.Context, in *pb.AddRemoteRequest) (*pb.AddRemoteResponse, error) {
+	grpc_logrus.Extract(ctx).WithFields(log.Fields{
+		"Name":   in.GetName(),
+		"Mirror": in.GetMirror(),
+	}).Debug("AddRemote")
+
+	if err := validateAddRemoteRequest(in); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "AddRemote: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	name := in.GetName()
+	exists, err := remoteExists(ctx, repoPath, name)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "AddRemote: %v", err)
+	}
+
+	// Make idempotent, as it's called through Sidekiq: an existing remote is
+	// updated to match the request instead of failing. A remote can have
+	// several URLs, which are all replaced.
+	if exists {
+		err = runGitConfig(ctx, repoPath, "--replace-all", "remote."+name+".url", in.GetUrl())
+	} else {
+		err = runGit(ctx, repoPath, "remote", "add", name, in.GetUrl())
+	}
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "AddRemote: %v", err)
+	}
+
+	refspecs := in.GetFetchRefspecs()
+	if len(refspecs) == 0 {
+		refspecs = []string{defaultFetchRefspec(name, in.GetMirror())}
+	}
+
+	if err := unsetGitConfig(ctx, repoPath, "--unset-all", "remote."+name+".fetch"); err != nil {
+		return nil, grpc.Errorf(codes.Internal, "AddRemote: %v", err)
+	}
+	for _, refspec := range refspecs {
+		if err := runGitConfig(ctx, repoPath, "--add", "remote."+name+".fetch", refspec); err != nil {
+			return nil, grpc.Errorf(codes.Internal, "AddRemote: %v", err)
+		}
+	}
+
+	if in.GetMirror() {
+		err = runGitConfig(ctx, repoPath, "--bool", "remote."+name+".mirror", "true")
+	} else {
+		err = unsetGitConfig(ctx, repoPath, "--unset", "remote."+name+".mirror")
+	}
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "AddRemote: %v", err)
+	}
+
+	return &pb.AddRemoteResponse{}, nil
+}
+
+func (s *server) RemoveRemote(ctx context.Context, in *pb.RemoveRemoteRequest) (*pb.RemoveRemoteResponse, error) {
+	if err := validateRemoteName(in.GetName()); err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "RemoveRemote: %v", err)
+	}
+
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := remoteExists(ctx, repoPath, in.GetName())
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "RemoveRemote: %v", err)
+	}
+	if !exists {
+		return &pb.RemoveRemoteResponse{Result: false}, nil
+	}
+
+	if err := runGit(ctx, repoPath, "remote", "remove", in.GetName()); err != nil {
+		return nil, grpc.Errorf(codes.Internal, "RemoveRemote: %v", err)
+	}
+
+	return &pb.RemoveRemoteResponse{Result: true}, nil
+}
+
+func (s *server) ListRemotes(ctx context.Context, in *pb.ListRemotesRequest) (*pb.ListRemotesResponse, error) {
+	repoPath, err := helper.GetRepoPath(in.GetRepository())
+	if err != nil {
+		return nil, err
+	}
+
+	remotes, err := listRemotes(ctx, repoPath)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "ListRemotes: %v", err)
+	}
+
+	return &pb.ListRemotesResponse{Remotes: remotes}, nil
+}
+
+func validateAddRemoteRequest(in *pb.AddRemoteRequest) error {
+	if err := validateRemoteName(in.GetName()); err != nil {
+		return err
+	}
+
+	if err := validateRemoteURL(in.GetUrl()); err != nil {
+		return err
+	}
+
+	for _, refspec := range in.GetFetchRefspecs() {
+		if err := validateRefspec(refspec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateRemoteName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty remote name")
+	}
+
+	if !validRemoteName.MatchString(name) || strings.Contains(name, "..") || strings.HasSuffix(name, ".lock") {
+		return fmt.Errorf("invalid remote name %q", name)
+	}
+
+	return nil
+}
+
+func validateRemoteURL(remoteURL string) error {
+	if remoteURL == "" {
+		return fmt.Errorf("empty remote URL")
+	}
+
+	if strings.HasPrefix(remoteURL, "-") || strings.IndexFunc(remoteURL, isSpaceOrControl) >= 0 {
+		return fmt.Errorf("invalid remote URL")
+	}
+
+	if scpLikeURL.MatchString(remoteURL) {
+		return nil
+	}
+
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return fmt.Errorf("invalid remote URL")
+	}
+
+	if !allowedURLSchemes[u.Scheme] {
+		return fmt.Errorf("unsupported remote URL scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("remote URL has no host")
+	}
+
+	return nil
+}
+
+func validateRefspec(refspec string) error {
+	spec := strings.TrimPrefix(refspec, "+")
+	if spec == "" || strings.HasPrefix(spec, "-") || strings.Count(spec, ":") > 1 || strings.IndexFunc(spec, isSpaceOrControl) >= 0 {
+		return fmt.Errorf("invalid fetch refspec %q", refspec)
+	}
+
+	return nil
+}
+
+func isSpaceOrControl(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r)
+}
+
+func defaultFetchRefspec(name string, mirror bool) string {
+	if mirror {
+		return "+refs/*:refs/*"
+	}
+
+	return fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", name)
+}
+
+func remoteExists(ctx context.Context, repoPath, name string) (bool, error) {
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "remote")
+	if err != nil {
+		return false, err
+	}
+
+	names, err := ioutil.ReadAll(cmd)
+	if err != nil {
+		return false, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return false, err
+	}
+
+	for _, remote := range strings.Split(string(names), "\n") {
+		if remote == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// listRemotes parses the remote sections of the repository config, in the
+// order they appear in.
+func listRemotes(ctx context.Context, repoPath string) ([]*pb.ListRemotesResponse_Remote, error) {
+	cmd, err := command.Git(ctx, "--git-dir", repoPath, "config", "-z", "--get-regexp", `^remote\..*\.(url|fetch|mirror)$`)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := ioutil.ReadAll(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		// Exit status 1 means no matching keys were found
+		if status, ok := command.ExitStatus(err); ok && status == 1 {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var remotes []*pb.ListRemotesResponse_Remote
+	byName := make(map[string]*pb.ListRemotesResponse_Remote)
+
+	for _, entry := range bytes.Split(output, []byte{0}) {
+		if len(entry) == 0 {
+			continue
+		}
+
+		var key, value string
+		if i := bytes.IndexByte(entry, '\n'); i >= 0 {
+			key, value = string(entry[:i]), string(entry[i+1:])
+		} else {
+			key = string(entry)
+		}
+
+		// Remote names may contain dots, so the variable is everything after
+		// the last one.
+		i := strings.LastIndex(key, ".")
+		name, variable := strings.TrimPrefix(key[:i], "remote."), key[i+1:]
+
+		remote, ok := byName[name]
+		if !ok {
+			remote = &pb.ListRemotesResponse_Remote{Name: name}
+			byName[name] = remote
+			remotes = append(remotes, remote)
+		}
+
+		switch variable {
+		case "url":
+			remote.Url = value
+		case "fetch":
+			remote.FetchRefspecs = append(remote.FetchRefspecs, value)
+		case "mirror":
+			remote.Mirror = parseGitBool(value)
+		}
+	}
+
+	return remotes, nil
+}
+
+func parseGitBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "", "true", "yes", "on", "1":
+		// A key without a value is true
+		return true
+	default:
+		return false
+	}
+}
+
+func runGit(ctx context.Context, repoPath string, args ...string) error {
+	cmd, err := command.Git(ctx, append([]string{"--git-dir", repoPath}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	return cmd.Wait()
+}
+
+func runGitConfig(ctx context.Context, repoPath string, args ...string) error {
+	return runGit(ctx, repoPath, append([]string{"config"}, args...)...)
+}
+
+// unsetGitConfig runs `git config` with an --unset or --unset-all argument,
+// ignoring the error returned when the key was not set to begin with.
+func unsetGitConfig(ctx context.Context, repoPath string, args ...string) error {
+	err := runGitConfig(ctx, repoPath, args...)
+	if status, ok := command.ExitStatus(err); ok && status == gitConfigNoSuchKey {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,260 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+func TestSuccessfulAddRemote(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	testRepo, _, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	testCases := []struct {
+		description string
+		req         *pb.AddRemoteRequest
+		expected    *pb.ListRemotesResponse_Remote
+	}{
+		{
+			description: "regular remote",
+			req:         &pb.AddRemoteRequest{Name: "my-remote", Url: "https://gitlab.com/gitlab-org/gitaly.git"},
+			expected: &pb.ListRemotesResponse_Remote{
+				Name:          "my-remote",
+				Url:           "https://gitlab.com/gitlab-org/gitaly.git",
+				FetchRefspecs: []string{"+refs/heads/*:refs/remotes/my-remote/*"},
+			},
+		},
+		{
+			description: "mirror remote",
+			req:         &pb.AddRemoteRequest{Name: "my.mirror", Url: "git@gitlab.com:gitlab-org/gitaly.git", Mirror: true},
+			expected: &pb.ListRemotesResponse_Remote{
+				Name:          "my.mirror",
+				Url:           "git@gitlab.com:gitlab-org/gitaly.git",
+				FetchRefspecs: []string{"+refs/*:refs/*"},
+				Mirror:        true,
+			},
+		},
+		{
+			description: "custom fetch refspecs",
+			req: &pb.AddRemoteRequest{
+				Name:          "custom",
+				Url:           "ssh://git@gitlab.com/gitlab-org/gitaly.git",
+				FetchRefspecs: []string{"+refs/heads/*:refs/remotes/custom/*", "+refs/tags/*:refs/tags/*"},
+			},
+			expected: &pb.ListRemotesResponse_Remote{
+				Name:          "custom",
+				Url:           "ssh://git@gitlab.com/gitlab-org/gitaly.git",
+				FetchRefspecs: []string{"+refs/heads/*:refs/remotes/custom/*", "+refs/tags/*:refs/tags/*"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			tc.req.Repository = testRepo
+
+			// Adding the same remote twice must be harmless
+			for i := 0; i < 2; i++ {
+				_, err := client.AddRemote(ctx, tc.req)
+				require.NoError(t, err)
+			}
+
+			require.Equal(t, tc.expected, findRemote(t, client, testRepo, tc.req.Name))
+		})
+	}
+}
+
+func TestAddRemoteUpdatesExistingRemote(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	req := &pb.AddRemoteRequest{Repository: testRepo, Name: "upstream", Url: "https://gitlab.com/old/path.git", Mirror: true}
+	_, err := client.AddRemote(ctx, req)
+	require.NoError(t, err)
+
+	// A remote with several URLs pushes to all of them
+	testhelper.MustRunCommand(t, nil, "git", "--git-dir", testRepoPath, "config", "--add", "remote.upstream.url", "https://gitlab.com/other/path.git")
+
+	req = &pb.AddRemoteRequest{Repository: testRepo, Name: "upstream", Url: "https://gitlab.com/new/path.git"}
+	_, err = client.AddRemote(ctx, req)
+	require.NoError(t, err)
+
+	expected := &pb.ListRemotesResponse_Remote{
+		Name:          "upstream",
+		Url:           "https://gitlab.com/new/path.git",
+		FetchRefspecs: []string{"+refs/heads/*:refs/remotes/upstream/*"},
+	}
+	require.Equal(t, expected, findRemote(t, client, testRepo, "upstream"))
+}
+
+func TestFailedAddRemoteDueToValidation(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	testCases := []struct {
+		description string
+		req         *pb.AddRemoteRequest
+	}{
+		{
+			description: "empty name",
+			req:         &pb.AddRemoteRequest{Repository: testRepo, Url: "https://gitlab.com/gitlab-org/gitaly.git"},
+		},
+		{
+			description: "name starting with a dash",
+			req:         &pb.AddRemoteRequest{Repository: testRepo, Name: "-foo", Url: "https://gitlab.com/gitlab-org/gitaly.git"},
+		},
+		{
+			description: "name with spaces",
+			req:         &pb.AddRemoteRequest{Repository: testRepo, Name: "my remote", Url: "https://gitlab.com/gitlab-org/gitaly.git"},
+		},
+		{
+			description: "name with double dots",
+			req:         &pb.AddRemoteRequest{Repository: testRepo, Name: "foo..bar", Url: "https://gitlab.com/gitlab-org/gitaly.git"},
+		},
+		{
+			description: "empty URL",
+			req:         &pb.AddRemoteRequest{Repository: testRepo, Name: "foo"},
+		},
+		{
+			description: "URL starting with a dash",
+			req:         &pb.AddRemoteRequest{Repository: testRepo, Name: "foo", Url: "--upload-pack=touch /tmp/pwned"},
+		},
+		{
+			description: "local path URL",
+			req:         &pb.AddRemoteRequest{Repository: testRepo, Name: "foo", Url: "/etc/passwd"},
+		},
+		{
+			description: "file URL",
+			req:         &pb.AddRemoteRequest{Repository: testRepo, Name: "foo", Url: "file:///etc/passwd"},
+		},
+		{
+			description: "URL with a newline",
+			req:         &pb.AddRemoteRequest{Repository: testRepo, Name: "foo", Url: "https://gitlab.com/foo.git\n[core]"},
+		},
+		{
+			description: "invalid refspec",
+			req:         &pb.AddRemoteRequest{Repository: testRepo, Name: "foo", Url: "https://gitlab.com/foo.git", FetchRefspecs: []string{"refs/a:refs/b:refs/c"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			ctx, cancel := testhelper.Context()
+			defer cancel()
+
+			_, err := client.AddRemote(ctx, tc.req)
+			testhelper.AssertGrpcError(t, err, codes.InvalidArgument, "")
+		})
+	}
+}
+
+func TestSuccessfulRemoveRemote(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	testhelper.MustRunCommand(t, nil, "git", "-C", testRepoPath, "remote", "add", "my-remote", "https://gitlab.com/gitlab-org/gitaly.git")
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	req := &pb.RemoveRemoteRequest{Repository: testRepo, Name: "my-remote"}
+
+	resp, err := client.RemoveRemote(ctx, req)
+	require.NoError(t, err)
+	require.True(t, resp.Result)
+	require.Nil(t, findRemote(t, client, testRepo, "my-remote"))
+
+	// Removing it again must be harmless
+	resp, err = client.RemoveRemote(ctx, req)
+	require.NoError(t, err)
+	require.False(t, resp.Result)
+}
+
+func TestFailedRemoveRemoteDueToValidation(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	_, err := client.RemoveRemote(ctx, &pb.RemoveRemoteRequest{Repository: testRepo, Name: "--all"})
+	testhelper.AssertGrpcError(t, err, codes.InvalidArgument, "")
+}
+
+func TestListRemotes(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	testhelper.MustRunCommand(t, nil, "git", "-C", testRepoPath, "remote", "remove", "origin")
+	testhelper.MustRunCommand(t, nil, "git", "-C", testRepoPath, "remote", "add", "first", "https://gitlab.com/first.git")
+	testhelper.MustRunCommand(t, nil, "git", "-C", testRepoPath, "remote", "add", "--mirror=fetch", "second", "https://gitlab.com/second.git")
+	testhelper.MustRunCommand(t, nil, "git", "-C", testRepoPath, "config", "remote.second.mirror", "true")
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	resp, err := client.ListRemotes(ctx, &pb.ListRemotesRequest{Repository: testRepo})
+	require.NoError(t, err)
+
+	expected := []*pb.ListRemotesResponse_Remote{
+		{Name: "first", Url: "https://gitlab.com/first.git", FetchRefspecs: []string{"+refs/heads/*:refs/remotes/first/*"}},
+		{Name: "second", Url: "https://gitlab.com/second.git", FetchRefspecs: []string{"+refs/*:refs/*"}, Mirror: true},
+	}
+	require.Equal(t, expected, resp.Remotes)
+}
+
+func findRemote(t *testing.T, client pb.RepositoryServiceClient, repo *pb.Repository, name string) *pb.ListRemotesResponse_Remote {
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	resp, err := client.ListRemotes(ctx, &pb.ListRemotesRequest{Repository: repo})
+	require.NoError(t, err)
+
+	for _, remote := range resp.Remotes {
+		if remote.Name == name {
+			return remote
+		}
+	}
+
+	return nil
+}
@@ -1,58 +1,198 @@
 package repository
 
 import (
-	"bytes"
-	"io/ioutil"
-	"os/exec"
-	"strconv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
-	"gitlab.com/gitlab-org/gitaly/internal/command"
 	"gitlab.com/gitlab-org/gitaly/internal/helper"
 
 	pb "gitlab.com/gitlab-org/gitaly-proto/go"
 
-	"github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
+// Maximum number of repositories whose size is cached
+const sizeCacheMaxEntries = 1000
+
+var looseObjectDir = regexp.MustCompile(`\Aobjects/[0-9a-f]{2}/`)
+
+var sizeCache = struct {
+	sync.Mutex
+	entries map[string]*sizeCacheEntry
+}{entries: make(map[string]*sizeCacheEntry)}
+
+type sizeCacheEntry struct {
+	// Modification times of all directories of the repository when the size
+	// was computed. Adding, removing or renaming a file changes them.
+	dirModTimes map[string]time.Time
+	// Sizes and modification times of the files git may change in place,
+	// like reflogs which are appended to
+	files    map[string]fileStat
+	response *pb.RepositorySizeResponse
+}
+
+type fileStat struct {
+	size    int64
+	modTime time.Time
+}
+
 func (s *server) RepositorySize(ctx context.Context, in *pb.RepositorySizeRequest) (*pb.RepositorySizeResponse, error) {
-	path, err := helper.GetPath(in.Repository)
+	repoPath, err := helper.GetPath(in.Repository)
 	if err != nil {
 		return nil, err
 	}
 
-	cmd, err := command.New(ctx, exec.Command("du", "-sk", path), nil, nil, nil)
-	if err != nil {
-		grpc_logrus.Extract(ctx).WithError(err).Warn("ignoring du command error")
-		return repositorySizeResponse(0), nil
+	if in.GetUseCache() {
+		if response := cachedRepositorySize(repoPath); response != nil {
+			return response, nil
+		}
 	}
 
-	sizeLine, err := ioutil.ReadAll(cmd)
-	if err != nil {
-		grpc_logrus.Extract(ctx).WithError(err).Warn("ignoring command read error")
-		return repositorySizeResponse(0), nil
+	entry, err := repositorySize(ctx, repoPath)
+	if os.IsNotExist(err) {
+		return nil, grpc.Errorf(codes.NotFound, "RepositorySize: repository not found")
+	} else if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "RepositorySize: %v", err)
 	}
 
-	if err := cmd.Wait(); err != nil {
-		grpc_logrus.Extract(ctx).WithError(err).Warn("ignoring du wait error")
-		return repositorySizeResponse(0), nil
+	cacheRepositorySize(repoPath, entry)
+
+	return entry.response, nil
+}
+
+// repositorySize walks the repository and adds up the sizes of its files
+// by category. The returned entry also has what is needed to tell whether
+// the size changed since.
+func repositorySize(ctx context.Context, repoPath string) (*sizeCacheEntry, error) {
+	if _, err := os.Stat(repoPath); err != nil {
+		return nil, err
 	}
 
-	sizeParts := bytes.Split(sizeLine, []byte("\t"))
-	if len(sizeParts) != 2 {
-		grpc_logrus.Extract(ctx).Warn("ignoring du malformed output: %q", sizeLine)
-		return repositorySizeResponse(0), nil
+	entry := &sizeCacheEntry{
+		dirModTimes: make(map[string]time.Time),
+		files:       make(map[string]fileStat),
+		response:    &pb.RepositorySizeResponse{},
 	}
 
-	size, err := strconv.ParseInt(string(sizeParts[0]), 10, 0)
+	err := filepath.Walk(repoPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Git may remove files while we walk, e.g. when repacking
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			entry.dirModTimes[path] = info.ModTime()
+			return nil
+		}
+
+		relPath, err := filepath.Rel(repoPath, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+
+		if !isImmutable(relPath) {
+			entry.files[path] = fileStat{size: info.Size(), modTime: info.ModTime()}
+		}
+
+		addToRepositorySize(entry.response, relPath, info)
+		return nil
+	})
 	if err != nil {
-		grpc_logrus.Extract(ctx).WithError(err).Warn("ignoring parsing size error")
-		return repositorySizeResponse(0), nil
+		return nil, err
 	}
 
-	return repositorySizeResponse(size), nil
+	response := entry.response
+	total := response.PackfilesBytes + response.LooseObjectsBytes + response.RefsBytes + response.LfsObjectsBytes + response.OtherBytes
+	response.Size = (total + 1023) / 1024
+
+	return entry, nil
+}
+
+// isImmutable returns true for the files that are never changed once
+// written, like objects. They make up most of a repository, so they aren't
+// checked one by one for changes; adding or removing them changes the
+// modification time of their directory.
+func isImmutable(relPath string) bool {
+	return strings.HasPrefix(relPath, "objects/pack/") ||
+		looseObjectDir.MatchString(relPath) ||
+		strings.HasPrefix(relPath, "lfs/objects/")
+}
+
+func addToRepositorySize(response *pb.RepositorySizeResponse, relPath string, info os.FileInfo) {
+	size := info.Size()
+
+	switch {
+	case strings.HasPrefix(relPath, "objects/pack/"):
+		response.PackfilesBytes += size
+		if strings.HasSuffix(relPath, ".pack") {
+			response.PackfilesCount++
+		}
+	case looseObjectDir.MatchString(relPath):
+		response.LooseObjectsBytes += size
+		response.LooseObjectsCount++
+	case relPath == "packed-refs" || strings.HasPrefix(relPath, "refs/"):
+		response.RefsBytes += size
+	case strings.HasPrefix(relPath, "lfs/objects/"):
+		response.LfsObjectsBytes += size
+	default:
+		response.OtherBytes += size
+	}
 }
 
-func repositorySizeResponse(size int64) *pb.RepositorySizeResponse {
-	return &pb.RepositorySizeResponse{Size: size}
+// cachedRepositorySize returns the cached size of the repository, or nil if
+// there is none or any of its directories or mutable files changed since it
+// was cached.
+func cachedRepositorySize(repoPath string) *pb.RepositorySizeResponse {
+	sizeCache.Lock()
+	entry := sizeCache.entries[repoPath]
+	sizeCache.Unlock()
+
+	if entry == nil {
+		return nil
+	}
+
+	for dir, modTime := range entry.dirModTimes {
+		info, err := os.Stat(dir)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return nil
+		}
+	}
+
+	for file, stat := range entry.files {
+		info, err := os.Stat(file)
+		if err != nil || info.Size() != stat.size || !info.ModTime().Equal(stat.modTime) {
+			return nil
+		}
+	}
+
+	return entry.response
+}
+
+func cacheRepositorySize(repoPath string, entry *sizeCacheEntry) {
+	sizeCache.Lock()
+	defer sizeCache.Unlock()
+
+	if _, ok := sizeCache.entries[repoPath]; !ok && len(sizeCache.entries) >= sizeCacheMaxEntries {
+		// Evict an arbitrary entry to bound memory usage
+		for key := range sizeCache.entries {
+			delete(sizeCache.entries, key)
+			break
+		}
+	}
+
+	sizeCache.entries[repoPath] = entry
 }
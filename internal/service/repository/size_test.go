@@ -1,8 +1,11 @@
 package repository
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
 
 	"gitlab.com/gitlab-org/gitaly/internal/config"
@@ -30,9 +33,6 @@ func TestSuccessfulRepositorySizeRequest(t *testing.T) {
 
 	repoCopyPath := path.Join(storagePath, "fixed-size-repo.git")
 	testhelper.MustRunCommand(t, nil, "cp", "-R", "testdata/fixed-size-repo.git", repoCopyPath)
-	// run `sync` because some filesystems (e.g. ZFS and BTRFS) do lazy-writes
-	// which leads to `du` returning 0 bytes used until it's finally written to disk.
-	testhelper.MustRunCommand(t, nil, "sync")
 	defer os.RemoveAll(repoCopyPath)
 
 	request := &pb.RepositorySizeRequest{
@@ -46,9 +46,11 @@ func TestSuccessfulRepositorySizeRequest(t *testing.T) {
 	defer cancel()
 	response, err := client.RepositorySize(ctx, request)
 	require.NoError(t, err)
-	// We can't test for an exact size because it will be different for systems with different sector sizes,
-	// so we settle for anything greater than zero.
-	require.True(t, response.Size > 0, "size must be greater than zero")
+	// The size is the apparent size of the files, so it doesn't depend on
+	// the sector size or on when the filesystem writes them to disk
+	total := response.PackfilesBytes + response.LooseObjectsBytes + response.RefsBytes + response.LfsObjectsBytes + response.OtherBytes
+	require.True(t, total > 0, "size must be greater than zero")
+	require.Equal(t, (total+1023)/1024, response.Size)
 }
 
 func TestFailedRepositorySizeRequest(t *testing.T) {
@@ -81,3 +83,119 @@ func TestFailedRepositorySizeRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestRepositorySizeBreakdown(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	// Make sure there is at least one loose object and one LFS object
+	testhelper.MustRunCommand(t, bytes.NewReader([]byte("loose object")), "git", "-C", testRepoPath, "hash-object", "-w", "--stdin")
+	lfsObjectDir := path.Join(testRepoPath, "lfs", "objects", "ab", "cd")
+	require.NoError(t, os.MkdirAll(lfsObjectDir, 0755))
+	require.NoError(t, ioutil.WriteFile(path.Join(lfsObjectDir, "abcd1234"), make([]byte, 1000), 0644))
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	response, err := client.RepositorySize(ctx, &pb.RepositorySizeRequest{Repository: testRepo})
+	require.NoError(t, err)
+
+	packs, err := filepath.Glob(path.Join(testRepoPath, "objects", "pack", "*.pack"))
+	require.NoError(t, err)
+	looseObjects, err := filepath.Glob(path.Join(testRepoPath, "objects", "??", "*"))
+	require.NoError(t, err)
+
+	require.Equal(t, int64(len(packs)), response.PackfilesCount)
+	require.Equal(t, int64(len(looseObjects)), response.LooseObjectsCount)
+	require.True(t, response.LooseObjectsBytes > 0, "loose objects size must be greater than zero")
+	require.True(t, response.RefsBytes > 0, "refs size must be greater than zero")
+	require.Equal(t, int64(1000), response.LfsObjectsBytes)
+	require.True(t, response.OtherBytes > 0, "other size must be greater than zero")
+
+	total := response.PackfilesBytes + response.LooseObjectsBytes + response.RefsBytes + response.LfsObjectsBytes + response.OtherBytes
+	require.Equal(t, (total+1023)/1024, response.Size)
+}
+
+func TestRepositorySizeCache(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	testRepo, testRepoPath, cleanupFn := testhelper.NewTestRepo(t)
+	defer cleanupFn()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	// LFS objects are never changed once written, so rewriting one in place
+	// isn't noticed
+	lfsObjectDir := path.Join(testRepoPath, "lfs", "objects", "ab", "cd")
+	require.NoError(t, os.MkdirAll(lfsObjectDir, 0755))
+	lfsObject := path.Join(lfsObjectDir, "abcd1234")
+	require.NoError(t, ioutil.WriteFile(lfsObject, nil, 0644))
+
+	request := &pb.RepositorySizeRequest{Repository: testRepo, UseCache: true}
+
+	before, err := client.RepositorySize(ctx, request)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(lfsObject, make([]byte, 1024), 0644))
+
+	// The rewrite isn't seen, so the size must come from the cache
+	cached, err := client.RepositorySize(ctx, request)
+	require.NoError(t, err)
+	require.Equal(t, before, cached)
+
+	uncached, err := client.RepositorySize(ctx, &pb.RepositorySizeRequest{Repository: testRepo})
+	require.NoError(t, err)
+	require.Equal(t, before.LfsObjectsBytes+1024, uncached.LfsObjectsBytes)
+
+	// Appending to a file in place, like git does to reflogs, invalidates
+	// the cache
+	reflog, err := os.OpenFile(path.Join(testRepoPath, "gitaly-size-test.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	defer reflog.Close()
+
+	cached, err = client.RepositorySize(ctx, request)
+	require.NoError(t, err)
+
+	_, err = reflog.Write(make([]byte, 512))
+	require.NoError(t, err)
+
+	appended, err := client.RepositorySize(ctx, request)
+	require.NoError(t, err)
+	require.Equal(t, cached.OtherBytes+512, appended.OtherBytes)
+	require.Equal(t, uncached.LfsObjectsBytes, appended.LfsObjectsBytes)
+
+	// Adding a file changes the mtime of its directory, which invalidates
+	// the cache
+	require.NoError(t, ioutil.WriteFile(path.Join(testRepoPath, "refs", "heads", "new-file"), make([]byte, 2048), 0644))
+
+	after, err := client.RepositorySize(ctx, request)
+	require.NoError(t, err)
+	require.Equal(t, appended.RefsBytes+2048, after.RefsBytes)
+	require.Equal(t, appended.OtherBytes, after.OtherBytes)
+}
+
+func TestRepositorySizeNotFound(t *testing.T) {
+	server := runRepoServer(t)
+	defer server.Stop()
+
+	client, conn := newRepositoryClient(t)
+	defer conn.Close()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	request := &pb.RepositorySizeRequest{Repository: &pb.Repository{StorageName: testRepo.StorageName, RelativePath: "does-not-exist.git"}}
+	_, err := client.RepositorySize(ctx, request)
+	testhelper.AssertGrpcError(t, err, codes.NotFound, "")
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/command"
+	"gitlab.com/gitlab-org/gitaly/internal/config"
+	"gitlab.com/gitlab-org/gitaly/internal/healthcheck"
+	"gitlab.com/gitlab-org/gitaly/internal/version"
+)
+
+func (s *server) ServerInfo(ctx context.Context, in *pb.ServerInfoRequest) (*pb.ServerInfoResponse, error) {
+	info, err := s.cachedInfo(ctx)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Internal, "ServerInfo: %v", err)
+	}
+
+	return &pb.ServerInfoResponse{
+		ServerVersion:   version.GetVersion(),
+		GitVersion:      info.GitVersion,
+		RubyStatus:      s.rubyStatus(),
+		StorageStatuses: info.StorageStatuses,
+	}, nil
+}
+
+// cachedInfo returns the git version and storage statuses, which are costly
+// to compute, from a cache that expires after infoCacheTTL. The checks run
+// outside of infoMu, so a hung storage can't block the calls that follow.
+func (s *server) cachedInfo(ctx context.Context) (*pb.ServerInfoResponse, error) {
+	s.infoMu.Lock()
+	info, cachedAt := s.infoCache, s.infoCachedAt
+	s.infoMu.Unlock()
+
+	if info != nil && time.Since(cachedAt) < infoCacheTTL {
+		return info, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthcheck.Timeout)
+	defer cancel()
+
+	gitVersion, err := gitVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info = &pb.ServerInfoResponse{
+		GitVersion:      gitVersion,
+		StorageStatuses: storageStatuses(),
+	}
+
+	s.infoMu.Lock()
+	s.infoCache = info
+	s.infoCachedAt = time.Now()
+	s.infoMu.Unlock()
+
+	return info, nil
+}
+
+func (s *server) rubyStatus() *pb.ServerInfoResponse_RubyStatus {
+	if s.ruby == nil || s.ruby.Process == nil {
+		return &pb.ServerInfoResponse_RubyStatus{}
+	}
+
+	status := s.ruby.Status()
+	return &pb.ServerInfoResponse_RubyStatus{
+		Running:            status.Pid > 0,
+		Pid:                int32(status.Pid),
+		CircuitBreakerOpen: status.CircuitBreakerOpen,
+	}
+}
+
+func gitVersion(ctx context.Context) (string, error) {
+	cmd, err := command.Git(ctx, "--version")
+	if err != nil {
+		return "", err
+	}
+
+	output, err := ioutil.ReadAll(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return "", fmt.Errorf("git --version: %v", err)
+	}
+
+	return strings.TrimPrefix(strings.TrimSpace(string(output)), "git version "), nil
+}
+
+// storageStatuses probes all storages concurrently, so that each hung
+// storage doesn't add another healthcheck.Timeout to the call.
+func storageStatuses() []*pb.ServerInfoResponse_StorageStatus {
+	statuses := make([]*pb.ServerInfoResponse_StorageStatus, len(config.Config.Storages))
+
+	var wg sync.WaitGroup
+	for i, storage := range config.Config.Storages {
+		wg.Add(1)
+		go func(i int, storage config.Storage) {
+			defer wg.Done()
+			statuses[i] = storageStatus(storage)
+		}(i, storage)
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+func storageStatus(storage config.Storage) *pb.ServerInfoResponse_StorageStatus {
+	status := &pb.ServerInfoResponse_StorageStatus{StorageName: storage.Name}
+
+	// An unreadable or hung storage is reported with all checks failed
+	if probed, err := healthcheck.CheckStorage(storage.Path); err == nil {
+		status.Readable = true
+		status.Writeable = probed.Writeable
+		status.FreeBytes = probed.FreeBytes
+	}
+
+	return status
+}
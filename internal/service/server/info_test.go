@@ -0,0 +1,75 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/config"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+func TestServerInfo(t *testing.T) {
+	defer func(oldStorages []config.Storage) {
+		config.Config.Storages = oldStorages
+	}(config.Config.Storages)
+
+	tempDir, err := ioutil.TempDir("", "gitaly-server-info")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	readOnlyPath := path.Join(tempDir, "read-only")
+	require.NoError(t, os.Mkdir(readOnlyPath, 0500))
+	defer os.Chmod(readOnlyPath, 0700)
+
+	config.Config.Storages = []config.Storage{
+		{Name: "default", Path: testhelper.GitlabTestStoragePath()},
+		{Name: "read-only", Path: readOnlyPath},
+		{Name: "missing", Path: path.Join(tempDir, "missing")},
+	}
+
+	server := runServer(t, nil)
+	defer server.Stop()
+
+	client, conn := newServerClient(t)
+	defer conn.Close()
+
+	ctx, cancel := testhelper.Context()
+	defer cancel()
+
+	resp, err := client.ServerInfo(ctx, &pb.ServerInfoRequest{})
+	require.NoError(t, err)
+
+	gitVersion := strings.TrimSpace(string(testhelper.MustRunCommand(t, nil, "git", "--version")))
+	require.Equal(t, gitVersion, "git version "+resp.GitVersion)
+	require.Equal(t, &pb.ServerInfoResponse_RubyStatus{}, resp.RubyStatus)
+
+	require.Len(t, resp.StorageStatuses, 3)
+
+	defaultStatus := resp.StorageStatuses[0]
+	require.Equal(t, "default", defaultStatus.StorageName)
+	require.True(t, defaultStatus.Readable)
+	require.True(t, defaultStatus.Writeable)
+	require.NotZero(t, defaultStatus.FreeBytes)
+
+	readOnlyStatus := resp.StorageStatuses[1]
+	require.Equal(t, "read-only", readOnlyStatus.StorageName)
+	require.True(t, readOnlyStatus.Readable)
+	if os.Getuid() != 0 {
+		// root can write anywhere
+		require.False(t, readOnlyStatus.Writeable)
+	}
+
+	require.Equal(t, &pb.ServerInfoResponse_StorageStatus{StorageName: "missing"}, resp.StorageStatuses[2])
+
+	files, err := ioutil.ReadDir(testhelper.GitlabTestStoragePath())
+	require.NoError(t, err)
+	for _, f := range files {
+		require.False(t, strings.HasPrefix(f.Name(), ".gitaly-write-check"), "write check file left behind")
+	}
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/rubyserver"
+)
+
+// How long the git version and storage statuses returned by ServerInfo are
+// reused before checking again
+const infoCacheTTL = 5 * time.Second
+
+type server struct {
+	ruby *rubyserver.Server
+
+	infoMu       sync.Mutex
+	infoCache    *pb.ServerInfoResponse
+	infoCachedAt time.Time
+}
+
+// NewServer creates a new instance of a grpc ServerServiceServer
+func NewServer(rs *rubyserver.Server) pb.ServerServiceServer {
+	return &server{ruby: rs}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/rubyserver"
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+var (
+	serverSocketPath = testhelper.GetTemporaryGitalySocketFileName()
+)
+
+func runServer(t *testing.T, rs *rubyserver.Server) *grpc.Server {
+	server := testhelper.NewTestGrpcServer(t, nil, nil)
+	listener, err := net.Listen("unix", serverSocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pb.RegisterServerServiceServer(server, NewServer(rs))
+	reflection.Register(server)
+
+	go server.Serve(listener)
+
+	return server
+}
+
+func newServerClient(t *testing.T) (pb.ServerServiceClient, *grpc.ClientConn) {
+	connOpts := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(addr string, _ time.Duration) (net.Conn, error) {
+			return net.Dial("unix", addr)
+		}),
+	}
+	conn, err := grpc.Dial(serverSocketPath, connOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pb.NewServerServiceClient(conn), conn
+}
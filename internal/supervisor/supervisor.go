@@ -53,6 +53,19 @@ type Process struct {
 	shutdown chan struct{}
 	done     chan struct{}
 	stopOnce sync.Once
+
+	// Current state, see Status
+	statusMu sync.RWMutex
+	status   Status
+}
+
+// Status describes the current state of a supervised process.
+type Status struct {
+	// Pid of the running process, 0 if it is not running
+	Pid int
+	// CircuitBreakerOpen is true while the process is not being respawned
+	// because it crashed too often.
+	CircuitBreakerOpen bool
 }
 
 // New creates a new proces instance.
@@ -92,11 +105,13 @@ func watch(p *Process) {
 	for {
 		if crashes >= config.CrashThreshold {
 			logger.Warn("opening circuit breaker")
+			p.setStatus(Status{CircuitBreakerOpen: true})
 			select {
 			case <-p.done:
 				return
 			case <-time.After(config.CrashWaitTime):
 				logger.Warn("closing circuit breaker")
+				p.setStatus(Status{})
 				crashes = 0
 			}
 		}
@@ -108,9 +123,12 @@ func watch(p *Process) {
 			continue
 		}
 
+		p.setStatus(Status{Pid: cmd.Process.Pid})
+
 		waitCh := make(chan struct{})
 		go func() {
 			logger.WithError(cmd.Wait()).Warn("exited")
+			p.setStatus(Status{})
 			close(waitCh)
 		}()
 
@@ -168,6 +186,21 @@ func getRss(pid int) int {
 	return rss
 }
 
+// Status returns the current state of the process.
+func (p *Process) Status() Status {
+	p.statusMu.RLock()
+	defer p.statusMu.RUnlock()
+
+	return p.status
+}
+
+func (p *Process) setStatus(status Status) {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+
+	p.status = status
+}
+
 // Stop terminates the process.
 func (p *Process) Stop() {
 	if p == nil {
@@ -91,6 +91,10 @@ func TestTooManyCrashes(t *testing.T) {
 	require.Error(t, err, "circuit breaker should cause a connection error / timeout")
 
 	require.Equal(t, config.CrashThreshold, len(pids), "number of pids should equal circuit breaker threshold")
+
+	status := process.Status()
+	require.True(t, status.CircuitBreakerOpen, "circuit breaker should be reported as open")
+	require.Equal(t, 0, status.Pid, "no process should be running")
 }
 
 func TestSpawnFailure(t *testing.T) {
@@ -0,0 +1,51 @@
+package tempdir
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+
+	log "github.com/Sirupsen/logrus"
+
+	pb "gitlab.com/gitlab-org/gitaly-proto/go"
+	"gitlab.com/gitlab-org/gitaly/internal/helper"
+)
+
+const (
+	// GitalyDataPrefix is the top-level directory Gitaly uses for its own
+	// data inside a storage. Its name starts with a '+' so that it can't
+	// clash with a GitLab namespace.
+	GitalyDataPrefix = "+gitaly"
+
+	tmpRootPrefix = GitalyDataPrefix + "/tmp"
+)
+
+// New returns the path of a new temporary directory in the storage of repo.
+// The directory is removed when ctx is done. Keeping the directory in the
+// same storage as the repository allows files to be renamed into it.
+func New(ctx context.Context, repo *pb.Repository) (string, error) {
+	storagePath, err := helper.GetStorageByName(repo.GetStorageName())
+	if err != nil {
+		return "", err
+	}
+
+	root := path.Join(storagePath, tmpRootPrefix)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", err
+	}
+
+	tempDir, err := ioutil.TempDir(root, "repo")
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		<-ctx.Done()
+		if err := os.RemoveAll(tempDir); err != nil {
+			log.WithError(err).WithField("path", tempDir).Warn("failed to remove temporary directory")
+		}
+	}()
+
+	return tempDir, nil
+}
@@ -0,0 +1,40 @@
+package tempdir
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitaly/internal/testhelper"
+)
+
+func TestNewRemovedWhenContextIsDone(t *testing.T) {
+	testRepo := testhelper.TestRepository()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	tempDir, err := New(ctx, testRepo)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(tempDir, path.Join(testhelper.GitlabTestStoragePath(), tmpRootPrefix)))
+
+	fi, err := os.Stat(tempDir)
+	require.NoError(t, err)
+	require.True(t, fi.IsDir())
+
+	cancel()
+
+	// The directory is removed asynchronously
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(tempDir); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("temporary directory %q was not removed", tempDir)
+}
@@ -0,0 +1,112 @@
+package testhelper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// CreateCommit commits files on top of parent, or as a root commit if
+// parent is empty, and points branch to the new commit. It returns the ID
+// of the new commit.
+func CreateCommit(t *testing.T, repoPath, branch, parent string, files map[string]string) string {
+	opts := CommitOptions{Message: "Update " + branch, Files: files}
+	if parent != "" {
+		opts.Parents = []string{parent}
+	}
+
+	commitID := WriteCommit(t, repoPath, opts)
+	mustRunGit(t, repoPath, os.Environ(), "", "update-ref", "refs/heads/"+branch, commitID)
+
+	return commitID
+}
+
+// CommitOptions describe a commit written by WriteCommit
+type CommitOptions struct {
+	// Message defaults to "Commit"
+	Message string
+	// Parents of the commit. Files are written on top of the tree of the
+	// first parent, or of an empty tree for a root commit.
+	Parents []string
+	Files   map[string]string
+	// AuthorName defaults to Scrooge McDuck, who always is the committer
+	AuthorName string
+	// Date is used as author and committer date if set
+	Date time.Time
+}
+
+// WriteCommit writes a commit without updating any ref, and returns its ID
+func WriteCommit(t *testing.T, repoPath string, opts CommitOptions) string {
+	tempDir, err := ioutil.TempDir("", "gitaly-create-commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	authorName := opts.AuthorName
+	if authorName == "" {
+		authorName = "Scrooge McDuck"
+	}
+
+	env := append(os.Environ(),
+		"GIT_INDEX_FILE="+path.Join(tempDir, "index"),
+		"GIT_AUTHOR_NAME="+authorName,
+		"GIT_AUTHOR_EMAIL=scrooge@mcduck.com",
+		"GIT_COMMITTER_NAME=Scrooge McDuck",
+		"GIT_COMMITTER_EMAIL=scrooge@mcduck.com",
+	)
+	if !opts.Date.IsZero() {
+		date := fmt.Sprintf("%d +0000", opts.Date.Unix())
+		env = append(env, "GIT_AUTHOR_DATE="+date, "GIT_COMMITTER_DATE="+date)
+	}
+
+	message := opts.Message
+	if message == "" {
+		message = "Commit"
+	}
+
+	commitArgs := []string{"commit-tree", "-m", message}
+	for _, parent := range opts.Parents {
+		commitArgs = append(commitArgs, "-p", parent)
+	}
+	if len(opts.Parents) > 0 {
+		mustRunGit(t, repoPath, env, "", "read-tree", opts.Parents[0])
+	}
+
+	for filePath, content := range opts.Files {
+		blobID := mustRunGit(t, repoPath, env, content, "hash-object", "-w", "--stdin")
+		mustRunGit(t, repoPath, env, "", "update-index", "--add", "--cacheinfo", "100644", blobID, filePath)
+	}
+
+	treeID := mustRunGit(t, repoPath, env, "", "write-tree")
+
+	return mustRunGit(t, repoPath, env, "", append(commitArgs, treeID)...)
+}
+
+func mustRunGit(t *testing.T, repoPath string, env []string, stdin string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"--git-dir", repoPath}, args...)...)
+	cmd.Env = env
+	cmd.Stdin = strings.NewReader(stdin)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %v: %s", args, err, output)
+	}
+
+	return strings.TrimSpace(string(output))
+}
+
+// RevParse returns the object ID revision points to in the repository
+func RevParse(t *testing.T, repoPath, revision string) string {
+	return strings.TrimSpace(string(MustRunCommand(t, nil, "git", "--git-dir", repoPath, "rev-parse", revision)))
+}
+
+// ShowFile returns the content of filePath at revision
+func ShowFile(t *testing.T, repoPath, revision, filePath string) string {
+	return string(MustRunCommand(t, nil, "git", "--git-dir", repoPath, "show", revision+":"+filePath))
+}
@@ -91,6 +91,25 @@ func TestRepository() *pb.Repository {
 	return repo
 }
 
+// NewTestRepo creates a bare copy of the gitlab-test repo in the test
+// storage, for tests that need to modify a repository. The returned cleanup
+// function removes the copy.
+func NewTestRepo(t *testing.T) (*pb.Repository, string, func()) {
+	testRepo := TestRepository()
+	storagePath := GitlabTestStoragePath()
+
+	repoPath, err := ioutil.TempDir(storagePath, "test-repo-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	MustRunCommand(t, nil, "git", "clone", "--bare", "--quiet", path.Join(storagePath, testRepo.GetRelativePath()), repoPath)
+
+	repo := &pb.Repository{StorageName: testRepo.GetStorageName(), RelativePath: filepath.Base(repoPath)}
+
+	return repo, repoPath, func() { os.RemoveAll(repoPath) }
+}
+
 // AssertGrpcError asserts the passed err is of the same code as expectedCode. Optionally, it can
 // assert the error contains the text of containsText if the latter is not an empty string.
 func AssertGrpcError(t *testing.T, err error, expectedCode codes.Code, containsText string) {
@@ -251,3 +270,32 @@ func mustFindNoRunningChildProcess() {
 func Context() (context.Context, func()) {
 	return context.WithCancel(context.Background())
 }
+
+// SetupGitlabShellHooks points gitlab-shell to a temporary directory with
+// the given hook scripts, keyed by hook name. The returned function restores
+// the previous gitlab-shell directory.
+func SetupGitlabShellHooks(t *testing.T, scripts map[string]string) func() {
+	shellDir, err := ioutil.TempDir("", "gitaly-gitlab-shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hooksDir := path.Join(shellDir, "hooks")
+	if err := os.Mkdir(hooksDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(path.Join(hooksDir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldDir := config.Config.GitlabShell.Dir
+	config.Config.GitlabShell.Dir = shellDir
+
+	return func() {
+		config.Config.GitlabShell.Dir = oldDir
+		os.RemoveAll(shellDir)
+	}
+}